@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -9,18 +10,47 @@ import (
 	"github.com/will-henderson/mumax-vhf/solver"
 )
 
+var strict = flag.Bool("strict", false, "exit if the script sets up physics which the solver does not model")
+var stability = flag.Bool("stability", false, "check that the ground state is a stable minimum, by diagonalising the dense Hessian")
+
 func main() {
+	flag.Parse()
+
 	defer en.InitAndClose()
 
-	filename := os.Args[1]
+	filename := flag.Arg(0)
 	bytes, err := os.ReadFile(filename)
 	if err != nil {
 		fmt.Println(err)
 	}
 	data.Setup(string(bytes))
 
+	if err := solver.SetSolver(flag.Arg(1)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if features := solver.ValidateSolver(); len(features) > 0 {
+		if *strict {
+			fmt.Fprintln(os.Stderr, features)
+			os.Exit(1)
+		}
+		for _, f := range features {
+			fmt.Println("warning: not modelled:", f)
+		}
+	}
+
 	en.Relax()
-	solver.SetSolver(os.Args[2])
+
+	if *stability {
+		if report, err := solver.Stability(); err != nil {
+			fmt.Println("warning: the stability of the ground state could not be checked:", err)
+		} else if !report.Stable() {
+			fmt.Printf("warning: ground state is not a stable minimum: residual torque %g T, %d unstable directions\n",
+				report.MaxTorque, len(report.Unstable))
+		}
+	}
+
 	solver.Modes()
 
 }
@@ -0,0 +1,41 @@
+package data
+
+import (
+	"reflect"
+
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+)
+
+var (
+	magnetisationBuffer **data.Slice //address of en.M.buffer_ in memory
+)
+
+// MagnetisationBuffer returns the address of en.M.buffer_, a pointer to a slice on the GPU storing the magnetisation.
+// Cached on initial call.
+func MagnetisationBuffer() **data.Slice {
+	if magnetisationBuffer == nil {
+
+		//could check that M has actually been initialised first
+
+		M := reflect.ValueOf(&en.M).Elem()
+		buffer := M.Field(0)
+		magnetisationBuffer = (**data.Slice)(buffer.Addr().UnsafePointer())
+
+	}
+	return magnetisationBuffer
+}
+
+// WithMagnetisation calls fn with the mumax magnetisation temporarily replaced by s, so that the mumax fields of s can be evaluated.
+// Note that this assumes that s lives on the GPU.
+func WithMagnetisation(s *data.Slice, fn func()) {
+
+	magnetisationBuffer := MagnetisationBuffer()
+	m0 := *magnetisationBuffer
+	*magnetisationBuffer = s
+
+	fn()
+
+	*magnetisationBuffer = m0
+
+}
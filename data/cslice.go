@@ -12,8 +12,16 @@ type CSlice struct {
 	real, imag *data.Slice
 }
 
-func CSliceFromParts(real, imag *data.Slice) CSlice {
-	return CSlice{real: real, imag: imag}
+// CSliceFromParts returns the complex slice with the given real and imaginary parts, which must have the same number of components
+// and size, and live in the same memory.
+func CSliceFromParts(real, imag *data.Slice) (CSlice, error) {
+	if real.NComp() != imag.NComp() || real.Size() != imag.Size() {
+		return CSlice{}, &SizeError{Op: "CSliceFromParts", NComp: imag.NComp(), WantNComp: real.NComp(), Size: imag.Size(), WantSize: real.Size()}
+	}
+	if real.CPUAccess() != imag.CPUAccess() {
+		return CSlice{}, &OptionError{Option: "parts of a CSlice", Reason: "the real and imaginary parts do not live in the same memory"}
+	}
+	return CSlice{real: real, imag: imag}, nil
 }
 
 func NewCSlice(nComp int, size [3]int) CSlice {
@@ -106,7 +114,7 @@ func (cs CSlice) CPUAccess() bool {
 	} else if !r && !i {
 		return false
 	} else {
-		panic("the real and imaginary parts do not live in the same memory")
+		panic(&OptionError{Option: "CSlice", Reason: "the real and imaginary parts do not live in the same memory"})
 	}
 }
 
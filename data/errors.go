@@ -0,0 +1,100 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+)
+
+// The errors returned by the solver, data and quickdisp packages. Inside a solve, they are panicked with,
+// and the functions of the API which return an error recover them with Catch.
+
+// A SizeError reports that the number of components or the size of a slice does not match that of what it is combined with.
+type SizeError struct {
+	Op               string
+	NComp, WantNComp int
+	Size, WantSize   [3]int
+}
+
+func (e *SizeError) Error() string {
+	if e.NComp != e.WantNComp {
+		return fmt.Sprintf("%s: %d components; want %d", e.Op, e.NComp, e.WantNComp)
+	}
+	return fmt.Sprintf("%s: size %v; want %v", e.Op, e.Size, e.WantSize)
+}
+
+// An OptionError reports an invalid option of a solver, or a system which the solver cannot handle.
+type OptionError struct {
+	Option string
+	Reason string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.Option, e.Reason)
+}
+
+// A ConvergenceError reports that a solver stopped before all the modes it sought had converged.
+// Solvers which return it from ModesContext also return the modes which did converge.
+type ConvergenceError struct {
+	Solver            string
+	Converged, Wanted int
+	Iterations        int
+}
+
+func (e *ConvergenceError) Error() string {
+	return fmt.Sprintf("%s stopped after %d iterations with %d of %d modes converged", e.Solver, e.Iterations, e.Converged, e.Wanted)
+}
+
+// A BackendError reports the failure of a routine of LAPACK, ARPACK or gonum, with its info code if it has one.
+type BackendError struct {
+	Routine string
+	Info    int
+	Reason  string
+}
+
+func (e *BackendError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("%s failed: info = %d", e.Routine, e.Info)
+	}
+	return fmt.Sprintf("%s failed: %s", e.Routine, e.Reason)
+}
+
+// An IOError reports the failure to read or write a file.
+type IOError struct {
+	Op   string
+	Path string
+	Err  error
+}
+
+func (e *IOError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Path, e.Err)
+}
+
+func (e *IOError) Unwrap() error {
+	return e.Err
+}
+
+// Catch sets *err to r, recovered from a panic, if it is one of the errors of this file, and otherwise panics again with it.
+// It is deferred by the functions of the API which return an error, as
+//
+//	defer func() { Catch(recover(), &err) }()
+//
+// so that the errors panicked with inside them are returned instead.
+func Catch(r interface{}, err *error) {
+	if r == nil {
+		return
+	}
+	if e, ok := r.(error); ok {
+		var (
+			sizeErr        *SizeError
+			optionErr      *OptionError
+			convergenceErr *ConvergenceError
+			backendErr     *BackendError
+			ioErr          *IOError
+		)
+		if errors.As(e, &sizeErr) || errors.As(e, &optionErr) || errors.As(e, &convergenceErr) || errors.As(e, &backendErr) || errors.As(e, &ioErr) {
+			*err = e
+			return
+		}
+	}
+	panic(r)
+}
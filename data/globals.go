@@ -6,6 +6,14 @@ import (
 
 var (
 	TensorFieldFactor_ *data.Slice
+
+	// Damping includes the Gilbert damping term, with the damping parameter en.Alpha, in the linearised dynamics.
+	// The eigenproblem is then non-Hermitian, and its eigenfrequencies complex.
+	Damping = false
+
+	// SpinTransfer includes the Zhang-Li and Slonczewski spin-transfer torques from the current density en.J in the linearised dynamics.
+	// These are not Hermitian either, and a mode with a negative decay rate grows, signalling auto-oscillation.
+	SpinTransfer = false
 )
 
 func ResetGlobals() {
@@ -52,7 +52,7 @@ func (t Tensor) AddIdx(c, c_, i, j, k, i_, j_, k_ int, val float64) {
 func AddTensors(Ns ...Tensor) Tensor {
 
 	if len(Ns) == 0 {
-		panic("can't determine size to return")
+		panic(&OptionError{Option: "AddTensors", Reason: "no tensors to add, so the size to return is unknown"})
 	}
 
 	NComp := Ns[0].NComp
@@ -208,15 +208,12 @@ func (t Tensor) Copy() Tensor {
 	return Tensor{n: n_, NComp: t.NComp, Size: t.Size}
 }
 
-// TSP (Tensor Slice Product) returns the operation of a tensor on a real slice
-func (t Tensor) TSP(v *data.Slice) *data.Slice {
+// TSP (Tensor Slice Product) returns the operation of a tensor on a real slice.
+// It returns a *SizeError if the slice does not have the number of components and size of the tensor.
+func (t Tensor) TSP(v *data.Slice) (*data.Slice, error) {
 
-	//check that the slice has the same number of components:
-	if v.NComp() != t.NComp {
-		panic("number of components are not the same")
-	}
-	if t.Size != v.Size() {
-		panic("sizes do not match")
+	if err := t.checkSlice("TSP", v); err != nil {
+		return nil, err
 	}
 	Nx := t.Size[0]
 	Ny := t.Size[1]
@@ -278,35 +275,123 @@ func (t Tensor) TSP(v *data.Slice) *data.Slice {
 
 	//put back on the gpu if that is where the input was from.
 	if cpu {
-		return result
+		return result, nil
 	} else {
 		resGPU := cuda.NewSlice(3, t.Size)
 		data.Copy(resGPU, result)
-		return resGPU
+		return resGPU, nil
 	}
 }
 
+// checkSlice returns a *SizeError for the operation op if v does not have the number of components and size of the tensor.
+func (t Tensor) checkSlice(op string, v *data.Slice) error {
+	if v.NComp() != t.NComp || v.Size() != t.Size {
+		return &SizeError{Op: op, NComp: v.NComp(), WantNComp: t.NComp, Size: v.Size(), WantSize: t.Size}
+	}
+	return nil
+}
+
+// TSPBatch returns the operation of a tensor on each of the real slices vs, like TSP,
+// but passes over the tensor once for all of them rather than once for each.
+func (t Tensor) TSPBatch(vs []*data.Slice) ([]*data.Slice, error) {
+
+	for _, v := range vs {
+		if err := t.checkSlice("TSPBatch", v); err != nil {
+			return nil, err
+		}
+	}
+	length := t.Length()
+
+	//work with 64 bit for doing the tensor slice product, on flattened copies of the inputs on the cpu.
+	in := make([][][]float64, len(vs))
+	out := make([][][]float64, len(vs))
+	for b, v := range vs {
+		if !v.CPUAccess() {
+			v = v.HostCopy()
+		}
+		in[b] = make([][]float64, t.NComp)
+		out[b] = make([][]float64, t.NComp)
+		for c := 0; c < t.NComp; c++ {
+			in[b][c] = make([]float64, length)
+			out[b][c] = make([]float64, length)
+			for idx, x := range v.Host()[c] {
+				in[b][c][idx] = float64(x)
+			}
+		}
+	}
+
+	for c := 0; c < t.NComp; c++ {
+		for c_ := 0; c_ < t.NComp; c_++ {
+			for idx := 0; idx < length; idx++ {
+				row := t.n[c][c_][idx]
+				for b := range vs {
+					sum := 0.
+					x := in[b][c_]
+					for idx_, n := range row {
+						sum += n * x[idx_]
+					}
+					out[b][c][idx] += sum
+				}
+			}
+		}
+	}
+
+	results := make([]*data.Slice, len(vs))
+	for b, v := range vs {
+		result := data.NewSlice(t.NComp, t.Size)
+		for c := 0; c < t.NComp; c++ {
+			resArr := result.Host()[c]
+			for idx := range resArr {
+				resArr[idx] = float32(out[b][c][idx])
+			}
+		}
+
+		//put back on the gpu if that is where the input was from.
+		if v.CPUAccess() {
+			results[b] = result
+		} else {
+			resGPU := cuda.NewSlice(t.NComp, t.Size)
+			data.Copy(resGPU, result)
+			results[b] = resGPU
+		}
+	}
+
+	return results, nil
+}
+
 // TCSP (Tensor Complex Slice Product) returns the operation of a tensor on a complex slice
-func (t Tensor) TCSP(v CSlice) CSlice {
+func (t Tensor) TCSP(v CSlice) (CSlice, error) {
 
 	//don't need to check that it is on host because TSP does this
-	return CSlice{
-		real: t.TSP(v.Real()),
-		imag: t.TSP(v.Imag()),
+	real, err := t.TSP(v.Real())
+	if err != nil {
+		return CSlice{}, err
+	}
+	imag, err := t.TSP(v.Imag())
+	if err != nil {
+		return CSlice{}, err
 	}
+	return CSlice{real: real, imag: imag}, nil
 
 }
 
 // ITCSP (Imagninary Tensor Complex Slice Product) returns the operation of a i * tensor on a complex.
 // This is primarily used as it is part of the eigenvalue equation.
-func (t Tensor) ITCSP(v CSlice) CSlice {
+func (t Tensor) ITCSP(v CSlice) (CSlice, error) {
 
 	cpu := v.CPUAccess()
 	if !cpu {
 		v = v.HostCopy()
 	}
 
-	w := t.TSP(v.Imag())
+	w, err := t.TSP(v.Imag())
+	if err != nil {
+		return CSlice{}, err
+	}
+	wr, err := t.TSP(v.Real())
+	if err != nil {
+		return CSlice{}, err
+	}
 	wVec := w.Tensors()
 
 	for c := 0; c < 3; c++ {
@@ -321,13 +406,13 @@ func (t Tensor) ITCSP(v CSlice) CSlice {
 
 	result := CSlice{
 		real: w,
-		imag: t.TSP(v.Real()),
+		imag: wr,
 	}
 
 	if cpu {
-		return result
+		return result, nil
 	} else {
-		return result.DevCopy()
+		return result.DevCopy(), nil
 	}
 }
 
@@ -335,7 +420,7 @@ func (t Tensor) ITCSP(v CSlice) CSlice {
 func (t Tensor) XY() Tensor {
 
 	if t.NComp < 2 {
-		panic("there is not an X and Y component")
+		panic(&SizeError{Op: "Tensor.XY", NComp: t.NComp, WantNComp: 2, Size: t.Size, WantSize: t.Size})
 	}
 
 	length := t.Length()
@@ -1,30 +1,28 @@
 package field
 
 import (
+	"github.com/mumax/3/cuda"
 	"github.com/mumax/3/data"
-	en "github.com/mumax/3/engine"
+
 	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
 // SetDemagComplex sets the the slices beff_real and beff_imag to, repectively, the real and imaginary components of the demagnetising field
 // B(s) for a complex magnetisation s, with real component s_real and imaginary component s_imag.
 // Note that this assumes that the inputs live on the GPU.
+//
+// Deprecated: use SetSIFieldComplex, which includes all the registered interactions.
 func SetDemagComplex(b, s CSlice) {
 	SetDemagField(b.Real(), s.Real())
 	SetDemagField(b.Imag(), s.Imag())
 }
 
-// SetDemagField adds the anisotropy field B(s) for a real magnetisation s to dst.
+// SetDemagField sets dst to the demagnetising field B(s) for a real magnetisation s.
 // Note that this assumes that the inputs live on the GPU.
+//
+// Deprecated: use mag.DemagInteraction{}.AddField.
 func SetDemagField(dst, s *data.Slice) {
-
-	magnetisationBuffer := GetMagnetisationBuffer()
-	m0 := *magnetisationBuffer
-
-	*magnetisationBuffer = s
-
-	en.SetDemagField(dst)
-
-	*magnetisationBuffer = m0
-
+	cuda.Zero(dst)
+	mag.DemagInteraction{}.AddField(dst, s)
 }
@@ -1,6 +1,8 @@
 package field
 
 import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
 	en "github.com/mumax/3/engine"
 
 	. "github.com/will-henderson/mumax-vhf/data"
@@ -32,7 +34,10 @@ func TestEigenMatrix(t *testing.T) {
 		numTests := 10
 		for i := 0; i < numTests; i++ {
 			rnd := tests.RandomCSlice(3, en.MeshSize(), rng)
-			ep_tens := EigenProblem.ITCSP(rnd)
+			ep_tens, tensErr := EigenProblem.ITCSP(rnd)
+			if tensErr != nil {
+				t.Fatalf("%d: %v", test_idx, tensErr)
+			}
 
 			rndGPU := rnd.DevCopy()
 
@@ -53,3 +58,108 @@ func TestEigenMatrix(t *testing.T) {
 	}
 
 }
+
+// TestOperateBatch checks that the linear evolution applied to a block of vectors at once, on the GPU and on the CPU,
+// is the same as that applied to each vector separately.
+func TestOperateBatch(t *testing.T) {
+
+	defer en.InitAndClose()()
+	testcases := tests.Load()
+
+	for test_idx, s := range testcases {
+
+		seed := 0
+		rng := rand.New(rand.NewSource(int64(seed)))
+
+		Setup(s)
+
+		en.Relax()
+
+		le := NewLinearEvolution()
+
+		k := 4
+		inCPU := make([]*data.Slice, k)
+		inGPU := make([]*data.Slice, k)
+		separate := make([]*data.Slice, k)
+		batchGPU := make([]*data.Slice, k)
+		batchCPU := make([]*data.Slice, k)
+		for i := 0; i < k; i++ {
+			inCPU[i] = tests.RandomSlice(3, en.MeshSize(), rng)
+			inGPU[i] = cuda.NewSlice(3, en.MeshSize())
+			data.Copy(inGPU[i], inCPU[i])
+
+			separate[i] = cuda.NewSlice(3, en.MeshSize())
+			le.Operate(separate[i], inGPU[i])
+
+			batchGPU[i] = cuda.NewSlice(3, en.MeshSize())
+			batchCPU[i] = data.NewSlice(3, en.MeshSize())
+		}
+
+		le.OperateBatch(batchGPU, inGPU)
+		le.OperateBatch(batchCPU, inCPU)
+
+		for i := 0; i < k; i++ {
+			if err := tests.EqualSlices(batchGPU[i], separate[i], 1e-3); err > 0 {
+				t.Errorf("%d: vector %d on the GPU is not equal: %d%% error", test_idx, i, 100*err/(3*separate[i].Len()))
+			}
+			if err := tests.EqualSlices(batchCPU[i], separate[i], 1e-3); err > 0 {
+				t.Errorf("%d: vector %d on the CPU is not equal: %d%% error", test_idx, i, 100*err/(3*separate[i].Len()))
+			}
+
+			inGPU[i].Free()
+			separate[i].Free()
+			batchGPU[i].Free()
+		}
+
+	}
+
+}
+
+// TestSystemEvolution checks that the linear evolution of the System set up in mumax, applied on the CPU with its tensors,
+// is the same as the LinearEvolution which uses the mumax effective fields.
+func TestSystemEvolution(t *testing.T) {
+
+	defer en.InitAndClose()()
+	testcases := tests.Load()
+
+	for test_idx, s := range testcases {
+
+		seed := 0
+		rng := rand.New(rand.NewSource(int64(seed)))
+
+		Setup(s)
+
+		en.Relax()
+
+		le := NewLinearEvolution()
+		sys, err := mag.EngineSystem()
+		if err != nil {
+			t.Fatalf("%d: %v", test_idx, err)
+		}
+		se := NewSystemEvolution(sys)
+
+		rnd := tests.RandomSlice(3, en.MeshSize(), rng)
+		rndGPU := cuda.NewSlice(3, en.MeshSize())
+		data.Copy(rndGPU, rnd)
+
+		resGPU := cuda.NewSlice(3, en.MeshSize())
+		resCPU := data.NewSlice(3, en.MeshSize())
+
+		le.Operate(resGPU, rndGPU)
+		se.Operate(resCPU, rnd)
+		if err := tests.EqualSlices(resGPU, resCPU, 1e-3); err > 0 {
+			t.Errorf("%d: Operate is not equal: %d%% error", test_idx, 100*err/(3*rnd.Len()))
+		}
+
+		le.OperateHamiltonian(resGPU, rndGPU)
+		se.OperateHamiltonian(resCPU, rnd)
+		if err := tests.EqualSlices(resGPU, resCPU, 1e-3); err > 0 {
+			t.Errorf("%d: OperateHamiltonian is not equal: %d%% error", test_idx, 100*err/(3*rnd.Len()))
+		}
+
+		rndGPU.Free()
+		resGPU.Free()
+
+	}
+
+}
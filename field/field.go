@@ -1,17 +1,12 @@
 package field
 
 import (
-	"reflect"
-
 	"github.com/mumax/3/cuda"
 	"github.com/mumax/3/data"
 	en "github.com/mumax/3/engine"
 
 	. "github.com/will-henderson/mumax-vhf/data"
-)
-
-var (
-	magnetisationBuffer **data.Slice //address of en.M.buffer_ in memory
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
 // SetFieldComplex sets the the slices beff_real and beff_imag to, repectively, the real and imaginary components of the field
@@ -19,9 +14,7 @@ var (
 // Note that this assumes that the inputs live on the GPU.
 func SetFieldComplex(b, s CSlice) {
 
-	SetDemagComplex(b, s)
-	AddExchangeComplex(b, s)
-	AddAnisotropyComplex(b, s)
+	SetSIFieldComplex(b, s)
 
 	en.B_ext.AddTo(b.Real())
 
@@ -29,32 +22,23 @@ func SetFieldComplex(b, s CSlice) {
 
 // SetSIFieldComplex sets res to the value -(1/Ms) * Σ_r' H_rr' s_r' ,
 // that is, the operation of the self-interaction tensor on a complex magnetisation s.
-// It is the self-interaction field (i.e. without the external field Bext) created by the complex magnetisation s.
+// It is the self-interaction field (i.e. without the external field Bext) created by the complex magnetisation s,
+// with contributions from all the interactions registered in package mag.
 func SetSIFieldComplex(b, s CSlice) {
-	SetDemagComplex(b, s)
-	AddExchangeComplex(b, s)
-	AddAnisotropyComplex(b, s)
+	SetSIField(b.Real(), s.Real())
+	SetSIField(b.Imag(), s.Imag())
 }
 
 func SetSIField(b *data.Slice, s *data.Slice) {
-	SetDemagField(b, s)
-	AddExchangeField(b, s)
-	AddAnisotropyField(b, s)
+	cuda.Zero(b)
+	mag.AddSIField(b, s)
 }
 
-// getMagnetisationBuffer returns the address of en.M.buffer_, a pointer to a slice on the GPU storing the magnetisation.
-// Cached on initial call.
+// GetMagnetisationBuffer returns the address of en.M.buffer_, a pointer to a slice on the GPU storing the magnetisation.
+//
+// Deprecated: use MagnetisationBuffer from package data.
 func GetMagnetisationBuffer() **data.Slice {
-	if magnetisationBuffer == nil {
-
-		//could check that M has actually been initialised first
-
-		M := reflect.ValueOf(&en.M).Elem()
-		buffer := M.Field(0)
-		magnetisationBuffer = (**data.Slice)(buffer.Addr().UnsafePointer())
-
-	}
-	return magnetisationBuffer
+	return MagnetisationBuffer()
 }
 
 // returns the ground state magnetic field, but as a scalar for each position
@@ -64,10 +48,8 @@ func GroundStateField() *data.Slice {
 	dst := cuda.NewSlice(3, en.Mesh().Size())
 	defer dst.Free()
 
-	en.SetDemagField(dst)
-	en.AddExchangeField(dst)
-	en.AddAnisotropyField(dst)
-	en.B_ext.AddTo(dst)
+	cuda.Zero(dst)
+	mag.AddGroundStateField(dst)
 
 	//dst now holds the ground state field.
 	result := cuda.NewSlice(1, en.Mesh().Size())
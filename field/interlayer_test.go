@@ -0,0 +1,65 @@
+package field
+
+import (
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+	"github.com/will-henderson/mumax-vhf/tests"
+
+	"math/rand"
+	"testing"
+)
+
+// TestInterlayerEigenMatrix checks that with a bilinear and biquadratic coupling between the top and bottom layers,
+// the operation of the eigenmatrix is the same for the explicit calculation and for that obtained via mumax effective fields.
+func TestInterlayerEigenMatrix(t *testing.T) {
+
+	defer en.InitAndClose()()
+	defer func() { mag.InterlayerCouplings = nil }()
+	testcases := tests.Load()
+
+	for test_idx, s := range testcases {
+
+		seed := 0
+		rng := rand.New(rand.NewSource(int64(seed)))
+
+		Setup(s)
+
+		Nz := en.MeshSize()[2]
+		if Nz == 1 {
+			continue
+		}
+
+		en.Relax()
+
+		mag.InterlayerCouplings = []mag.InterlayerCoupling{mag.NewPlaneCoupling(0, Nz-1, -1e-4, -2e-5)}
+
+		EigenProblem := mag.EigenProblemTensor()
+		le := NewLinearEvolution()
+
+		numTests := 10
+		for i := 0; i < numTests; i++ {
+			rnd := tests.RandomCSlice(3, en.MeshSize(), rng)
+			ep_tens, tensErr := EigenProblem.ITCSP(rnd)
+			if tensErr != nil {
+				t.Fatalf("%d: %v", test_idx, tensErr)
+			}
+
+			rndGPU := rnd.DevCopy()
+
+			ep_mumax := NewCSlice(3, en.MeshSize())
+			le.OperateComplex(&ep_mumax, rndGPU)
+
+			err := tests.EqualCSlices(ep_mumax, ep_tens, 1e-3)
+			if err > 0 {
+				t.Errorf("%d: Fields are not equal: %d%% error", test_idx, 100*err/(3*ep_mumax.Len()))
+			}
+
+			rndGPU.Free()
+			ep_mumax.Free()
+		}
+
+		mag.InterlayerCouplings = nil
+	}
+}
@@ -1,48 +1,301 @@
 package field
 
 import (
+	"sync"
+
 	"github.com/mumax/3/cuda"
 	"github.com/mumax/3/data"
 	en "github.com/mumax/3/engine"
 
 	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
 type LinearEvolution struct {
 	groundStateField *data.Slice
+
+	// when Damping is set, these hold -α²/(1+α²) and α/(1+α²) at each position. Otherwise they are nil.
+	dampingCorrection, dampingRate *data.Slice
+
+	// when SpinTransfer is set, this holds the linearised spin-transfer torques. Otherwise it is nil.
+	stt *mag.LinearSTT
+
+	// when there are mag.PinnedSurfaces, this is zero at the pinned cells and one elsewhere. Otherwise it is nil.
+	unpinned *data.Slice
+
+	// the self-interaction fields of the blocks of OperateBatch on the GPU, which keep the stacked demagnetising convolution.
+	batch *mag.SIFieldBatch
+
+	// the eigenproblem tensor used by OperateBatch for inputs on the CPU, built on first use.
+	tensor *lazyTensor
+}
+
+type lazyTensor struct {
+	once sync.Once
+	t    Tensor
 }
 
 func NewLinearEvolution() *LinearEvolution {
-	return &LinearEvolution{groundStateField: GroundStateField()}
+	mag.LineariseInterlayer()
+	le := &LinearEvolution{groundStateField: GroundStateField(), batch: mag.NewSIFieldBatch(), tensor: new(lazyTensor)}
+	if Damping {
+		le.dampingCorrection, le.dampingRate = dampingFactors()
+	}
+	if SpinTransfer {
+		le.stt = mag.NewLinearSTT()
+	}
+	if len(mag.PinnedSurfaces) > 0 {
+		le.unpinned = unpinnedMask()
+	}
+	return le
 }
 
 // this returns the operation divided by i. such that it is real.
+// The cells of mag.PinnedSurfaces have no degrees of freedom, so they are zero in the result, and their values in s are ignored.
 func (l LinearEvolution) Operate(res *data.Slice, s *data.Slice) {
 
-	SetSIField(res, s)
-	cuda.Scale(res, res, -1)
-	cuda.AddMul1D(res, l.groundStateField, s)
+	s, recycle := l.pinnedInput(s)
+	defer recycle()
+
+	l.operateHamiltonian(res, s)
 	cuda.CrossProduct(res, en.M.Buffer(), res)
+	l.damp(res)
 
 	//now multiply by the dynamic factor: i * γ
 	cuda.Scale(res, res, float32(en.GammaLL))
+	l.addSTT(res, s, 1)
+	l.pin(res)
+
+}
+
+// OperateBatch sets each res[i] to the operation on s[i], as Operate does, for a block of vectors at once.
+// On the GPU, the demagnetising fields of the whole block are found with a single convolution, and the ground state field is scaled once.
+// If the inputs are on the CPU, it applies mag.EigenProblemTensor to the block instead, which is built on the first such call.
+// This is a different code path, which builds the same terms from the registered interactions, the damping, the spin-transfer torques
+// and the pinning; TestOperateBatch checks that the two agree.
+func (l LinearEvolution) OperateBatch(res, s []*data.Slice) {
+
+	if len(res) != len(s) {
+		panic(&OptionError{Option: "OperateBatch", Reason: "it needs a result for each input"})
+	}
+	if len(s) == 0 {
+		return
+	}
+
+	if s[0].CPUAccess() {
+		l.tensor.once.Do(func() { l.tensor.t = mag.EigenProblemTensor() })
+		results, err := l.tensor.t.TSPBatch(s)
+		if err != nil {
+			panic(err)
+		}
+		for i, r := range results {
+			data.Copy(res[i], r)
+		}
+		return
+	}
+
+	if l.unpinned != nil {
+		pinned := make([]*data.Slice, len(s))
+		for i := range s {
+			var recycle func()
+			pinned[i], recycle = l.pinnedInput(s[i])
+			defer recycle()
+		}
+		s = pinned
+	}
+
+	for i := range res {
+		cuda.Zero(res[i])
+	}
+	l.batch.AddSIField(res, s)
+
+	γB0 := cuda.Buffer(1, l.groundStateField.Size())
+	defer cuda.Recycle(γB0)
+	cuda.Scale(γB0, l.groundStateField, float32(en.GammaLL))
+
+	for i := range res {
+		// γ (B0 s - SI(s)), so that the dynamic factor is included before the cross product.
+		cuda.Scale(res[i], res[i], -float32(en.GammaLL))
+		cuda.AddMul1D(res[i], γB0, s[i])
+		cuda.CrossProduct(res[i], en.M.Buffer(), res[i])
+		l.damp(res[i])
+		l.addSTT(res[i], s[i], 1)
+		l.pin(res[i])
+	}
+
+}
+
+// OperateHamiltonian sets res to the field which drives the precession of a magnetisation s, B0 s - (-(1/Ms) Σ_r' H_rr' s_r'),
+// that is, the operation of the linear Hamiltonian divided by Ms, before it is crossed with the ground state magnetisation.
+// The linear Hamiltonian is symmetric, and is positive definite on the transverse components for a stable ground state.
+// Pinned cells are treated as in Operate.
+func (l LinearEvolution) OperateHamiltonian(res *data.Slice, s *data.Slice) {
+
+	s, recycle := l.pinnedInput(s)
+	defer recycle()
+
+	l.operateHamiltonian(res, s)
+	l.pin(res)
+
+}
+
+func (l LinearEvolution) operateHamiltonian(res *data.Slice, s *data.Slice) {
+
+	SetSIField(res, s)
+	cuda.Scale(res, res, -1)
+	cuda.AddMul1D(res, l.groundStateField, s)
 
 }
 
 // we pass the return by reference
+// Pinned cells are treated as in Operate.
 func (l LinearEvolution) OperateComplex(res *CSlice, s CSlice) {
 
-	SetSIFieldComplex(*res, s)
+	sReal, recycleReal := l.pinnedInput(s.Real())
+	defer recycleReal()
+	sImag, recycleImag := l.pinnedInput(s.Imag())
+	defer recycleImag()
+
+	SetSIField(res.Real(), sReal)
+	SetSIField(res.Imag(), sImag)
 	SScal(*res, *res, -1)
-	cuda.AddMul1D(res.Real(), l.groundStateField, s.Real())
-	cuda.AddMul1D(res.Imag(), l.groundStateField, s.Imag())
+	cuda.AddMul1D(res.Real(), l.groundStateField, sReal)
+	cuda.AddMul1D(res.Imag(), l.groundStateField, sImag)
 	cuda.CrossProduct(res.Real(), en.M.Buffer(), res.Real())
 	cuda.CrossProduct(res.Imag(), en.M.Buffer(), res.Imag())
+	l.damp(res.Real())
+	l.damp(res.Imag())
 
 	//now multiply by the dynamic factor: i * γ
 	cuda.Scale(res.Real(), res.Real(), float32(en.GammaLL))
 	cuda.Scale(res.Imag(), res.Imag(), -float32(en.GammaLL))
+	l.addSTT(res.Real(), sReal, 1)
+	l.addSTT(res.Imag(), sImag, -1)
+	l.pin(res.Real())
+	l.pin(res.Imag())
 
 	res.SwitchParts()
 
 }
+
+// pinnedInput returns a copy of s with the pinned cells set to zero, and a function which recycles it.
+// If no cells are pinned, it returns s itself.
+func (l LinearEvolution) pinnedInput(s *data.Slice) (*data.Slice, func()) {
+	if l.unpinned == nil {
+		return s, func() {}
+	}
+	pinned := cuda.Buffer(s.NComp(), s.Size())
+	data.Copy(pinned, s)
+	l.pin(pinned)
+	return pinned, func() { cuda.Recycle(pinned) }
+}
+
+// pin sets the pinned cells of s to zero. It does nothing if no cells are pinned.
+func (l LinearEvolution) pin(s *data.Slice) {
+	if l.unpinned == nil {
+		return
+	}
+	for c := 0; c < s.NComp(); c++ {
+		cuda.Mul(s.Comp(c), s.Comp(c), l.unpinned)
+	}
+}
+
+// unpinnedMask returns a slice on the GPU which is zero at the cells of mag.PinnedSurfaces and one elsewhere.
+func unpinnedMask() *data.Slice {
+
+	size := en.MeshSize()
+	maskCPU := data.NewSlice(1, size)
+	mask := maskCPU.Host()[0]
+	for idx, pinned := range mag.PinnedCells() {
+		if !pinned {
+			mask[idx] = 1
+		}
+	}
+
+	maskGPU := cuda.NewSlice(1, size)
+	data.Copy(maskGPU, maskCPU)
+	return maskGPU
+}
+
+// damp replaces the precessional term τ = m x B by the Landau-Lifshitz-Gilbert term (τ + α m x τ) / (1 + α²).
+// It does nothing if the LinearEvolution was created without Damping.
+func (l LinearEvolution) damp(τ *data.Slice) {
+
+	if l.dampingRate == nil {
+		return
+	}
+
+	mxτ := cuda.Buffer(3, τ.Size())
+	defer cuda.Recycle(mxτ)
+
+	cuda.CrossProduct(mxτ, en.M.Buffer(), τ)
+	cuda.AddMul1D(τ, l.dampingCorrection, τ)
+	cuda.AddMul1D(τ, l.dampingRate, mxτ)
+
+}
+
+// addSTT adds f times the linearised spin-transfer torque of s to res.
+// It does nothing if the LinearEvolution was created without SpinTransfer.
+// The coefficients of the torque stay on the GPU between calls, until Free is called.
+func (l LinearEvolution) addSTT(res, s *data.Slice, f float32) {
+
+	if l.stt == nil {
+		return
+	}
+
+	τ := cuda.Buffer(3, s.Size())
+	defer cuda.Recycle(τ)
+	cuda.Zero(τ)
+	l.stt.AddTo(τ, s)
+	cuda.Madd2(res, res, τ, 1, f)
+
+}
+
+// Free frees the slices on the GPU held by the LinearEvolution. It must not be used afterwards.
+func (l LinearEvolution) Free() {
+	l.groundStateField.Free()
+	l.batch.Free()
+	if l.dampingCorrection != nil {
+		l.dampingCorrection.Free()
+		l.dampingRate.Free()
+	}
+	if l.stt != nil {
+		l.stt.Free()
+	}
+	if l.unpinned != nil {
+		l.unpinned.Free()
+	}
+}
+
+// dampingFactors returns slices on the GPU holding -α²/(1+α²) and α/(1+α²) at each position.
+func dampingFactors() (correction, rate *data.Slice) {
+
+	alphaGPU, rA := en.Alpha.Slice()
+	alpha := alphaGPU.HostCopy().Scalars()
+	if rA {
+		cuda.Recycle(alphaGPU)
+	}
+
+	size := en.MeshSize()
+	correctionCPU := data.NewSlice(1, size)
+	rateCPU := data.NewSlice(1, size)
+	c := correctionCPU.Scalars()
+	r := rateCPU.Scalars()
+
+	for k := 0; k < size[2]; k++ {
+		for j := 0; j < size[1]; j++ {
+			for i := 0; i < size[0]; i++ {
+				α := alpha[k][j][i]
+				c[k][j][i] = -α * α / (1 + α*α)
+				r[k][j][i] = α / (1 + α*α)
+			}
+		}
+	}
+
+	correction = cuda.NewSlice(1, size)
+	rate = cuda.NewSlice(1, size)
+	data.Copy(correction, correctionCPU)
+	data.Copy(rate, rateCPU)
+
+	return correction, rate
+}
@@ -4,22 +4,39 @@ import (
 	"github.com/mumax/3/cuda"
 	"github.com/mumax/3/data"
 	en "github.com/mumax/3/engine"
+
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
 type RotationToZ struct {
 	R [3][3]*data.Slice
 }
 
+// InitRotation initialises R, the pointwise rotation matrices on the GPU used for rotating to z.
+// The frames are built on the CPU by mag.LocalFrame, so they are the same as those of mag.RotationToZ for the same gauge.
 func (rtz *RotationToZ) InitRotation() {
-	m := en.M.Buffer()
 
+	rotCPU := new(mag.RotationToZ)
+	rotCPU.InitRotation()
+
+	size := en.MeshSize()
 	for c := 0; c < 3; c++ {
 		for c_ := 0; c_ < 3; c_++ {
-			rtz.R[c][c_] = cuda.NewSlice(1, en.MeshSize())
+
+			RCPU := data.NewSlice(1, size)
+			r := RCPU.Scalars()
+			for k := 0; k < size[2]; k++ {
+				for j := 0; j < size[1]; j++ {
+					for i := 0; i < size[0]; i++ {
+						r[k][j][i] = float32(rotCPU.R[k][j][i][c][c_])
+					}
+				}
+			}
+
+			rtz.R[c][c_] = cuda.NewSlice(1, size)
+			data.Copy(rtz.R[c][c_], RCPU)
 		}
 	}
-
-	cuda.InitRotation(m, rtz.R)
 }
 
 // it returns a two component slice living in the space perpendicular to the ground state magnetisation.
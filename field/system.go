@@ -0,0 +1,106 @@
+package field
+
+import (
+	"github.com/mumax/3/data"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// A SystemEvolution is the linear evolution of a mag.System, with the same operations as a LinearEvolution.
+// They are applied on the CPU with the tensors of the System, so it does not use the mumax engine,
+// and several can exist at once. Inputs and results may live on the CPU or the GPU.
+type SystemEvolution struct {
+	eigenProblem Tensor
+
+	// the linear Hamiltonian divided by Ms at each position, so that it gives the field driving the precession.
+	hamiltonian Tensor
+}
+
+// NewSystemEvolution builds the tensors of the linear evolution of the System s.
+// It panics with a *SizeError if the slices of s do not match its mesh (see System.Check).
+func NewSystemEvolution(s *mag.System) *SystemEvolution {
+
+	lht := s.LinearHamiltonianTensor()
+	eigenProblem := s.DynamicOperate(lht)
+
+	ms := s.Msat.Scalars()
+	for k := 0; k < s.Size[2]; k++ {
+		for j := 0; j < s.Size[1]; j++ {
+			for i := 0; i < s.Size[0]; i++ {
+
+				scale := 0.
+				if ms[k][j][i] != 0 {
+					scale = 1 / float64(ms[k][j][i])
+				}
+				for k_ := 0; k_ < s.Size[2]; k_++ {
+					for j_ := 0; j_ < s.Size[1]; j_++ {
+						for i_ := 0; i_ < s.Size[0]; i_++ {
+							for c := 0; c < 3; c++ {
+								for c_ := 0; c_ < 3; c_++ {
+									lht.SetIdx(c, c_, i, j, k, i_, j_, k_, scale*lht.GetIdx(c, c_, i, j, k, i_, j_, k_))
+								}
+							}
+						}
+					}
+				}
+
+			}
+		}
+	}
+
+	return &SystemEvolution{eigenProblem: eigenProblem, hamiltonian: lht}
+}
+
+// Operate sets res to the operation on s, divided by i such that it is real, as LinearEvolution.Operate does.
+func (l SystemEvolution) Operate(res *data.Slice, s *data.Slice) {
+	l.apply(l.eigenProblem, res, s)
+}
+
+// OperateBatch sets each res[i] to the operation on s[i], passing over the tensor once for the whole block.
+func (l SystemEvolution) OperateBatch(res, s []*data.Slice) {
+
+	if len(res) != len(s) {
+		panic(&OptionError{Option: "OperateBatch", Reason: "it needs a result for each input"})
+	}
+	results, err := l.eigenProblem.TSPBatch(s)
+	if err != nil {
+		panic(err)
+	}
+	for i, r := range results {
+		data.Copy(res[i], r)
+		freeDevice(r)
+	}
+}
+
+// OperateHamiltonian sets res to the field which drives the precession of a magnetisation s, as LinearEvolution.OperateHamiltonian does.
+func (l SystemEvolution) OperateHamiltonian(res *data.Slice, s *data.Slice) {
+	l.apply(l.hamiltonian, res, s)
+}
+
+// OperateComplex sets res to the operation on the complex magnetisation s, as LinearEvolution.OperateComplex does.
+func (l SystemEvolution) OperateComplex(res *CSlice, s CSlice) {
+	result, err := l.eigenProblem.ITCSP(s)
+	if err != nil {
+		panic(err)
+	}
+	Copy(*res, result)
+	freeDevice(result.Real())
+	freeDevice(result.Imag())
+}
+
+func (l SystemEvolution) apply(t Tensor, res, s *data.Slice) {
+	result, err := t.TSP(s)
+	if err != nil {
+		panic(err)
+	}
+	data.Copy(res, result)
+	freeDevice(result)
+}
+
+// freeDevice frees a result of the tensor products, which is copied to the GPU when the input lives there.
+func freeDevice(s *data.Slice) {
+	if !s.CPUAccess() {
+		s.Free()
+	}
+}
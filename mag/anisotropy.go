@@ -1,9 +1,6 @@
 package mag
 
 import (
-	"github.com/mumax/3/cuda"
-	en "github.com/mumax/3/engine"
-
 	. "github.com/will-henderson/mumax-vhf/data"
 )
 
@@ -11,36 +8,40 @@ import (
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 // This forces the updating of the tensor, rather than potentially returning a cached value.
 func UniAnisTensor() Tensor {
+	return engineSystem().UniAnisTensor()
+}
 
-	Ku1GPU, rM := en.Ku1.Slice()
-	Ku1 := Ku1GPU.HostCopy().Scalars()
-	if rM {
-		cuda.Recycle(Ku1GPU)
-	}
+// UniAnisTensor returns the self-interaction tensor for the uniaxial anisotropy interaction of the System.
+func (s *System) UniAnisTensor() Tensor {
 
-	AnisUGPU, rM := en.AnisU.Slice()
-	AnisU := AnisUGPU.HostCopy().Vectors()
-	if rM {
-		cuda.Recycle(AnisUGPU)
-	}
+	t := ZeroTensor(3, s.Size)
+	n := t.To4D()
+	s.uniAnisElements(func(c, c_, idx, idx_ int, v float64) { n[c][c_][idx][idx_] += v })
+	return t
+
+}
+
+// uniAnisElements calls add with each element of the uniaxial anisotropy tensor of the System, indexed as Tensor.Idx.
+func (s *System) uniAnisElements(add func(c, c_, idx, idx_ int, v float64)) {
+
+	s.check()
+	Ku1 := s.Ku1.Scalars()
+	AnisU := s.AnisU.Vectors()
 
-	t := ZeroTensor(3, en.MeshSize())
-	Nx := en.MeshSize()[0]
-	Ny := en.MeshSize()[1]
-	Nz := en.MeshSize()[2]
+	Nx := s.Size[0]
+	Ny := s.Size[1]
+	Nz := s.Size[2]
 
 	for k := 0; k < Nz; k++ {
 		for j := 0; j < Ny; j++ {
 			for i := 0; i < Nx; i++ {
+				idx := Nx*(Ny*k+j) + i
 				for c := 0; c < 3; c++ {
 					for c_ := 0; c_ < 3; c_++ {
-						t.SetIdx(c, c_, i, j, k, i, j, k, float64(-2*Ku1[k][j][i]*AnisU[c][k][j][i]*AnisU[c_][k][j][i]))
+						add(c, c_, idx, idx, float64(-2*Ku1[k][j][i]*AnisU[c][k][j][i]*AnisU[c_][k][j][i]))
 					}
 				}
 			}
 		}
 	}
-
-	return t
-
 }
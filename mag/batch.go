@@ -0,0 +1,231 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/mag"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// A BatchInteraction is an Interaction which adds the fields of several magnetisations at once, more cheaply than one at a time.
+type BatchInteraction interface {
+	Interaction
+
+	// AddFieldBatch adds the linearised field of the interaction for each real magnetisation s[i] to dst[i], as AddField does.
+	// Note that this assumes that the inputs live on the GPU.
+	AddFieldBatch(dst, s []*data.Slice)
+}
+
+// An SIFieldBatch adds the self-interaction fields of blocks of magnetisations, keeping the stacked demagnetising convolution,
+// which is expensive to build, between calls. It is rebuilt when the mesh or the size of the block changes.
+// The GPU memory it holds is released by Free.
+type SIFieldBatch struct {
+	stacked *stackedDemag
+}
+
+func NewSIFieldBatch() *SIFieldBatch {
+	return new(SIFieldBatch)
+}
+
+// AddSIField adds the self-interaction field of all the registered interactions for each real magnetisation s[i] to dst[i],
+// as AddSIField does. Interactions which are not BatchInteractions add the fields one at a time.
+// Note that this assumes that the inputs live on the GPU.
+func (b *SIFieldBatch) AddSIField(dst, s []*data.Slice) {
+	if len(dst) != len(s) {
+		panic(&OptionError{Option: "SIFieldBatch", Reason: "it needs a destination for each magnetisation"})
+	}
+	for _, ri := range interactions {
+		switch i := ri.interaction.(type) {
+		case DemagInteraction:
+			b.addDemagField(dst, s)
+		case BatchInteraction:
+			i.AddFieldBatch(dst, s)
+		default:
+			for n := range s {
+				i.AddField(dst[n], s[n])
+			}
+		}
+	}
+}
+
+// addDemagField adds the demagnetising fields of the block as DemagInteraction.AddFieldBatch does, with the convolution kept by b.
+func (b *SIFieldBatch) addDemagField(dst, s []*data.Slice) {
+
+	if !en.EnableDemag || len(s) == 0 {
+		return
+	}
+	if en.Mesh().PBC()[2] != 0 || len(s) == 1 {
+		for i := range s {
+			DemagInteraction{}.AddField(dst[i], s[i])
+		}
+		return
+	}
+
+	if !b.stacked.matches(len(s)) {
+		b.Free()
+		b.stacked = newStackedDemag(len(s))
+	} else {
+		b.stacked.update()
+	}
+	b.stacked.addField(dst, s)
+}
+
+// Free frees the GPU memory held by the SIFieldBatch. It may be used again afterwards, and then rebuilds the convolution.
+func (b *SIFieldBatch) Free() {
+	if b.stacked != nil {
+		b.stacked.free()
+		b.stacked = nil
+	}
+}
+
+// AddFieldBatch finds the demagnetising fields of all the magnetisations with a single convolution, by stacking them along z
+// with gaps between them, and convolving with the kernel of the mesh truncated so that the copies do not interact.
+// The stacked convolution is periodic along z, with each copy taking the padded size of a single mesh, so it costs no more than
+// the convolutions of the magnetisations one at a time.
+// With periodic boundaries along z, the magnetisations cannot be stacked, and their fields are found one at a time.
+// The convolution is built for each call and then freed; an SIFieldBatch keeps it between calls.
+func (d DemagInteraction) AddFieldBatch(dst, s []*data.Slice) {
+	b := NewSIFieldBatch()
+	defer b.Free()
+	b.addDemagField(dst, s)
+}
+
+// A stackedDemag is the demagnetising convolution of k copies of the mesh stacked along z, every stride cells,
+// which is periodic along z with period k * stride.
+type stackedDemag struct {
+	size, pbc [3]int
+	cellsize  [3]float64
+	accuracy  float64
+	k, stride int
+	conv      *cuda.DemagConvolution
+	in, out   *data.Slice
+	vol, msat *data.Slice
+	cellsPerZ int // Nx * Ny, the number of cells in a layer.
+}
+
+// matches reports whether sd is the stacked convolution of k copies of the current mesh. It is false if sd is nil.
+func (sd *stackedDemag) matches(k int) bool {
+	mesh := en.Mesh()
+	return sd != nil && sd.k == k && sd.size == mesh.Size() && sd.pbc == mesh.PBC() &&
+		sd.cellsize == mesh.CellSize() && sd.accuracy == en.DemagAccuracy
+}
+
+func newStackedDemag(k int) *stackedDemag {
+
+	mesh := en.Mesh()
+	size := mesh.Size()
+	pbc := mesh.PBC()
+	cellsize := mesh.CellSize()
+
+	kernel := mag.DemagKernel(size, pbc, cellsize, en.DemagAccuracy, *en.Flag_cachedir)
+	kSize := kernel[0][0].Size()
+
+	// each copy takes the padded size along z of a single mesh, which is at least 2 Nz - 1, so that cells of different copies
+	// are at least Nz apart in z, also across the periodic boundary, beyond the range of the truncated kernel.
+	// The size of the kernel sets that of the convolution, so the stacked mesh is not padded further.
+	stride := kSize[2]
+	stackedSize := [3]int{size[0], size[1], k * stride}
+	padded := [3]int{kSize[0], kSize[1], stackedSize[2]}
+
+	var stackedKernel [3][3]*data.Slice
+	for c := 0; c < 3; c++ {
+		for c_ := c; c_ < 3; c_++ {
+			sk := data.NewSlice(1, padded)
+			if kernel[c][c_] != nil {
+				src := kernel[c][c_].Scalars()
+				dst := sk.Scalars()
+				for z := 0; z < kSize[2]; z++ {
+
+					// the displacement along z of this element of the kernel, which is within (-Nz, Nz).
+					dz := z
+					if z > kSize[2]/2 {
+						dz = z - kSize[2]
+					}
+					if dz <= -size[2] || dz >= size[2] {
+						continue
+					}
+					zs := (dz + padded[2]) % padded[2]
+					for y := 0; y < kSize[1]; y++ {
+						copy(dst[zs][y], src[z][y])
+					}
+				}
+			}
+			stackedKernel[c][c_] = sk
+			stackedKernel[c_][c] = sk
+		}
+	}
+
+	sd := &stackedDemag{
+		size:      size,
+		pbc:       pbc,
+		cellsize:  cellsize,
+		accuracy:  en.DemagAccuracy,
+		k:         k,
+		stride:    stride,
+		conv:      cuda.NewDemag(stackedSize, [3]int{pbc[0], pbc[1], 0}, stackedKernel, false),
+		in:        cuda.NewSlice(3, stackedSize),
+		out:       cuda.NewSlice(3, stackedSize),
+		msat:      cuda.NewSlice(1, stackedSize),
+		cellsPerZ: size[0] * size[1],
+	}
+	cuda.Zero(sd.in)
+	cuda.Zero(sd.msat)
+	if en.Geometry().Gpu() != nil {
+		sd.vol = cuda.NewSlice(1, stackedSize)
+		cuda.Zero(sd.vol)
+	}
+	sd.update()
+	return sd
+}
+
+// update copies the saturation magnetisation and the geometry into each copy of the mesh, as they may change between calls.
+func (sd *stackedDemag) update() {
+
+	msat := HostSlice(en.Msat)
+	vol := en.Geometry().Gpu()
+	if (vol == nil) != (sd.vol == nil) {
+		panic(&OptionError{Option: "geometry", Reason: "it was set or removed while the stacked demagnetising convolution was in use"})
+	}
+
+	for i := 0; i < sd.k; i++ {
+		data.Copy(sd.layers(sd.msat, i), msat)
+		if vol != nil {
+			data.Copy(sd.layers(sd.vol, i), vol)
+		}
+	}
+}
+
+// layers returns the part of the stacked slice s which holds copy i of the mesh.
+func (sd *stackedDemag) layers(s *data.Slice, i int) *data.Slice {
+	start := i * sd.stride * sd.cellsPerZ
+	return s.Slice(start, start+sd.size[2]*sd.cellsPerZ)
+}
+
+func (sd *stackedDemag) addField(dst, s []*data.Slice) {
+
+	for i := range s {
+		data.Copy(sd.layers(sd.in, i), s[i])
+	}
+
+	msat := cuda.ToMSlice(sd.msat)
+	sd.conv.Exec(sd.out, sd.in, sd.vol, msat)
+
+	B := cuda.Buffer(3, dst[0].Size())
+	defer cuda.Recycle(B)
+	for i := range dst {
+		data.Copy(B, sd.layers(sd.out, i))
+		cuda.Madd2(dst[i], dst[i], B, 1, 1)
+	}
+}
+
+func (sd *stackedDemag) free() {
+	sd.conv.Free()
+	sd.in.Free()
+	sd.out.Free()
+	sd.msat.Free()
+	if sd.vol != nil {
+		sd.vol.Free()
+	}
+}
@@ -1,7 +1,6 @@
 package mag
 
 import (
-	"github.com/mumax/3/cuda"
 	en "github.com/mumax/3/engine"
 	"github.com/mumax/3/mag"
 
@@ -11,20 +10,22 @@ import (
 // DemagTensor returns the self-interaction tensor for the Demagnetising interaction.
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 func DemagTensor() Tensor {
+	return engineSystem().DemagTensor()
+}
 
-	kernel := mag.DemagKernel(en.Mesh().Size(), en.Mesh().PBC(), en.Mesh().CellSize(), en.DemagAccuracy, *en.Flag_cachedir)
-	t := ZeroTensor(3, en.MeshSize())
+// DemagTensor returns the self-interaction tensor for the Demagnetising interaction of the System, whether or not EnableDemag is set.
+func (s *System) DemagTensor() Tensor {
 
-	MsatGPU, rM := en.Msat.Slice()
-	Msat := MsatGPU.HostCopy().Scalars()
-	if rM {
-		cuda.Recycle(MsatGPU)
-	}
+	s.check()
+	kernel := mag.DemagKernel(s.Size, s.PBC, s.CellSize, s.DemagAccuracy, *en.Flag_cachedir)
+	t := ZeroTensor(3, s.Size)
+
+	Msat := s.Msat.Scalars()
 
 	size := kernel[0][0].Size()
-	Nx := en.MeshSize()[0]
-	Ny := en.MeshSize()[1]
-	Nz := en.MeshSize()[2]
+	Nx := s.Size[0]
+	Ny := s.Size[1]
+	Nz := s.Size[2]
 
 	for c := 0; c < 3; c++ {
 		for c_ := 0; c_ < 3; c_++ {
@@ -42,3 +42,100 @@ func TestEnergy(t *testing.T) {
 		}
 	}
 }
+
+// TestEnergies tests equality between the energies of each interaction calculated by mumax and in the energy report,
+// and that the energies of the regions sum to the total.
+// The energies are calculated for the ground state.
+func TestEnergies(t *testing.T) {
+
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		Setup(s)
+
+		en.Relax()
+
+		report := Energies()
+
+		terms := []struct {
+			name string
+			want float64
+			got  EnergyTerm
+		}{
+			{"Demag", en.GetDemagEnergy(), report.Demag},
+			{"Exchange", en.GetExchangeEnergy(), report.Exchange},
+			{"Anisotropy", en.GetAnisotropyEnergy(), report.Anisotropy},
+			{"Zeeman", en.GetZeemanEnergy(), report.Zeeman},
+		}
+
+		for _, term := range terms {
+			if tests.EqualScalars(term.want, term.got.Total, 1e-2) > 0 {
+				t.Errorf("%d: %s Energy was %e; want %e", test_idx, term.name, term.got.Total, term.want)
+			}
+
+			sum := 0.
+			for _, E := range term.got.Regions {
+				sum += E
+			}
+			if tests.EqualScalars(term.got.Total, sum, 1e-6) > 0 {
+				t.Errorf("%d: %s Energy of the regions sums to %e; want %e", test_idx, term.name, sum, term.got.Total)
+			}
+		}
+	}
+}
+
+// TestEnergiesPeriodic tests equality between the exchange energy calculated by mumax, in the energy report and via the self-interaction tensor
+// on a mesh which is periodic along x and y, for a random magnetisation, so the exchange across the boundaries is included.
+func TestEnergiesPeriodic(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Setup(`
+		SetGridSize(4, 3, 2)
+		SetCellSize(2e-9, 3e-9, 2e-9)
+		SetPBC(1, 1, 0)
+		Msat = 8e5
+		Aex = 1.3e-11
+	`)
+	en.M.Set(en.RandomMagSeed(0))
+
+	want := en.GetExchangeEnergy()
+	if got := Energies().Exchange.Total; tests.EqualScalars(want, got, 1e-2) > 0 {
+		t.Errorf("Exchange Energy in the report was %e; want %e", got, want)
+	}
+	if got := Energy(ExchangeTensor(), en.M.Buffer()); tests.EqualScalars(want, got, 1e-2) > 0 {
+		t.Errorf("Exchange Energy via the tensor was %e; want %e", got, want)
+	}
+}
+
+// TestMagnetoelasticEnergy tests equality between the magnetoelastic energy of a static strain calculated by mumax and via the self-interaction tensor.
+// The energies are calculated for the ground state.
+func TestMagnetoelasticEnergy(t *testing.T) {
+
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		Setup(s + `
+			B1 = -8.8e6
+			B2 = 4.4e6
+			exx = 1e-3
+			eyy = -5e-4
+			ezz = 2e-4
+			exy = 3e-4
+			exz = -1e-4
+			eyz = 2e-4
+		`)
+
+		en.Relax()
+
+		want := en.GetMagnetoelasticEnergy()
+		got := Energy(MagnetoelasticTensor(), en.M.Buffer())
+		if tests.EqualScalars(want, got, 1e-2) > 0 {
+			t.Errorf("%d: Magnetoelastic Energy was %e; want %e", test_idx, got, want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package mag
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+)
+
+// An EnergyTerm holds the energy of one interaction, in total and split by mumax region index.
+type EnergyTerm struct {
+	Total   float64
+	Regions map[int]float64
+}
+
+// An EnergyReport holds the energy of the magnetisation split into the separate interactions.
+type EnergyReport struct {
+	Demag, Exchange, Anisotropy, Zeeman EnergyTerm
+}
+
+// Total returns the sum of the energies of all of the interactions.
+func (er EnergyReport) Total() float64 {
+	return er.Demag.Total + er.Exchange.Total + er.Anisotropy.Total + er.Zeeman.Total
+}
+
+// String returns a table of the energies with a row for each region.
+func (er EnergyReport) String() string {
+
+	terms := []EnergyTerm{er.Demag, er.Exchange, er.Anisotropy, er.Zeeman}
+
+	var regions []int
+	for r := range er.Demag.Regions {
+		regions = append(regions, r)
+	}
+	sort.Ints(regions)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-8s %14s %14s %14s %14s\n", "region", "demag", "exchange", "anisotropy", "zeeman")
+	for _, r := range regions {
+		fmt.Fprintf(&b, "%-8d", r)
+		for _, term := range terms {
+			fmt.Fprintf(&b, " %14e", term.Regions[r])
+		}
+		fmt.Fprint(&b, "\n")
+	}
+	fmt.Fprintf(&b, "%-8s", "total")
+	for _, term := range terms {
+		fmt.Fprintf(&b, " %14e", term.Total)
+	}
+	fmt.Fprint(&b, "\n")
+
+	return b.String()
+}
+
+// Energies returns the demagnetising, exchange, uniaxial anisotropy and Zeeman energies of the magnetisation stored in en.M,
+// each split by mumax region index.
+// Unlike Energy it does not build the self-interaction tensors: the local terms are evaluated cell by cell in O(N),
+// and the demagnetising term from the mumax demagnetising field.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func Energies() EnergyReport {
+
+	mesh := en.Mesh()
+	size := mesh.Size()
+	Nx := size[0]
+	Ny := size[1]
+	Nz := size[2]
+
+	cellsize := mesh.CellSize()
+	volume := cellsize[0] * cellsize[1] * cellsize[2]
+
+	m := en.M.Buffer().HostCopy().Vectors()
+
+	msatGPU, rM := en.Msat.Slice()
+	ms := msatGPU.HostCopy().Scalars()
+	if rM {
+		cuda.Recycle(msatGPU)
+	}
+
+	Ku1GPU, rM := en.Ku1.Slice()
+	Ku1 := Ku1GPU.HostCopy().Scalars()
+	if rM {
+		cuda.Recycle(Ku1GPU)
+	}
+
+	AnisUGPU, rM := en.AnisU.Slice()
+	AnisU := AnisUGPU.HostCopy().Vectors()
+	if rM {
+		cuda.Recycle(AnisUGPU)
+	}
+
+	B_extGPU, rM := en.B_ext.Slice()
+	B_ext := B_extGPU.HostCopy().Vectors()
+	if rM {
+		cuda.Recycle(B_extGPU)
+	}
+
+	demagGPU := cuda.NewSlice(3, size)
+	en.SetDemagField(demagGPU)
+	B_demag := demagGPU.HostCopy().Vectors()
+	demagGPU.Free()
+
+	regions := RegionIndices()
+
+	report := EnergyReport{
+		Demag:      EnergyTerm{Regions: make(map[int]float64)},
+		Exchange:   EnergyTerm{Regions: make(map[int]float64)},
+		Anisotropy: EnergyTerm{Regions: make(map[int]float64)},
+		Zeeman:     EnergyTerm{Regions: make(map[int]float64)},
+	}
+
+	add := func(term *EnergyTerm, region int, E float64) {
+		term.Total += E
+		term.Regions[region] += E
+	}
+
+	for k := 0; k < Nz; k++ {
+		for j := 0; j < Ny; j++ {
+			for i := 0; i < Nx; i++ {
+
+				r := regions[k][j][i]
+
+				var mDemag, mExt, mAnisU float64
+				for c := 0; c < 3; c++ {
+					mDemag += float64(m[c][k][j][i] * B_demag[c][k][j][i])
+					mExt += float64(m[c][k][j][i] * B_ext[c][k][j][i])
+					mAnisU += float64(m[c][k][j][i] * AnisU[c][k][j][i])
+				}
+
+				add(&report.Demag, r, -.5*float64(ms[k][j][i])*mDemag*volume)
+				add(&report.Zeeman, r, -float64(ms[k][j][i])*mExt*volume)
+				add(&report.Anisotropy, r, -float64(Ku1[k][j][i])*mAnisU*mAnisU*volume)
+				add(&report.Exchange, r, exchangeEnergyDensity(m, mesh, [3]int{i, j, k})*volume)
+			}
+		}
+	}
+
+	return report
+}
+
+// exchangeEnergyDensity returns .5 * Σ_r' m_r t_rr' m_r' for the exchange tensor t, evaluated from the nearest neighbours of r directly.
+// Neighbours across a periodic boundary of the mesh are wrapped, as in ExchangeTensor.
+func exchangeEnergyDensity(m [3][][][]float32, mesh *data.Mesh, r [3]int) float64 {
+
+	size := mesh.Size()
+	pbc := mesh.PBC()
+	cellsize := mesh.CellSize()
+	i, j, k := r[0], r[1], r[2]
+
+	mm := 0.
+	for c := 0; c < 3; c++ {
+		mm += float64(m[c][k][j][i] * m[c][k][j][i])
+	}
+
+	E := 0.
+	for n := 0; n < 6; n++ {
+		d := n / 2
+		r_ := r
+		r_[d] += 2*(n%2) - 1
+		if r_[d] < 0 || r_[d] >= size[d] {
+			if pbc[d] == 0 {
+				continue
+			}
+			r_[d] = (r_[d] + size[d]) % size[d]
+		}
+		i_, j_, k_ := r_[0], r_[1], r_[2]
+
+		f := -2. * (1 / (cellsize[d] * cellsize[d])) * float64(en.ExchangeAtCell(i, j, k, i_, j_, k_))
+
+		mm_ := 0.
+		for c := 0; c < 3; c++ {
+			mm_ += float64(m[c][k][j][i] * m[c][k_][j_][i_])
+		}
+
+		E += f * (mm_ - mm)
+	}
+
+	return .5 * E
+}
@@ -56,7 +56,10 @@ func SIField(t Tensor, mSl *data.Slice) *data.Slice {
 		mSl = mSl.HostCopy()
 	}
 
-	v := t.TSP(mSl)
+	v, err := t.TSP(mSl)
+	if err != nil {
+		panic(err)
+	}
 
 	msat, rM := en.Msat.Slice()
 	if rM {
@@ -93,7 +96,10 @@ func SIFieldComplex(t Tensor, mSl CSlice) CSlice {
 		mSl = mSl.HostCopy()
 	}
 
-	v := t.TCSP(mSl)
+	v, err := t.TCSP(mSl)
+	if err != nil {
+		panic(err)
+	}
 
 	msat, rM := en.Msat.Slice()
 	if rM {
@@ -0,0 +1,139 @@
+package mag
+
+import (
+	"math"
+)
+
+// Gauges for the transverse axes of the local frame. The rotation taking the ground state direction m to z is only
+// determined up to a further rotation about z, and the gauge fixes this freedom.
+// RODRIGUES_GAUGE uses the smallest rotation taking m to z, so a ground state along z gives transverse axes x and y.
+// It is only discontinuous at m = -z, where a rotation of π about x is used.
+// REFERENCE_GAUGE takes the first transverse axis along the projection of FrameReference onto the plane perpendicular to m.
+// When FrameReference is (anti)parallel to m, the coordinate axis least aligned with m is used instead.
+const (
+	RODRIGUES_GAUGE = 0
+	REFERENCE_GAUGE = 1
+)
+
+var (
+	FrameGauge     = RODRIGUES_GAUGE
+	FrameReference = [3]float64{1, 0, 0}
+)
+
+// LocalFrame returns the rotation matrix R satisfying R m = (0, 0, 1) for the direction m, with transverse axes chosen according to FrameGauge.
+// The rows of R are the two transverse axes and m itself, and form a right handed set.
+// It has no singular directions, and for m = 0 (outside the geometry) it returns the identity.
+func LocalFrame(m [3]float64) [3][3]float64 {
+
+	norm := math.Sqrt(m[0]*m[0] + m[1]*m[1] + m[2]*m[2])
+	if norm == 0 {
+		return [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+	}
+	for c := 0; c < 3; c++ {
+		m[c] /= norm
+	}
+
+	switch FrameGauge {
+	default:
+		panic("frame gauge not known")
+	case RODRIGUES_GAUGE:
+		return rodriguesFrame(m)
+	case REFERENCE_GAUGE:
+		return referenceFrame(m, FrameReference)
+	}
+}
+
+// rodriguesFrame returns the smallest rotation taking the unit vector m to z.
+// With v = m × z and c = m.z, R = I + [v]x + [v]x^2 / (1 + c).
+// As |v|^2 = (1 - c)(1 + c), the last factor is evaluated as (1 - c) / |v|^2 for c < 0 to avoid cancellation near m = -z.
+func rodriguesFrame(m [3]float64) [3][3]float64 {
+
+	c := m[2]
+	s2 := m[0]*m[0] + m[1]*m[1]
+
+	if s2 == 0 {
+		if c > 0 {
+			return [3][3]float64{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+		}
+		return [3][3]float64{{1, 0, 0}, {0, -1, 0}, {0, 0, -1}}
+	}
+
+	var factor float64
+	if c >= 0 {
+		factor = 1 / (1 + c)
+	} else {
+		factor = (1 - c) / s2
+	}
+
+	v := [3]float64{m[1], -m[0], 0}
+	vx := [3][3]float64{
+		{0, -v[2], v[1]},
+		{v[2], 0, -v[0]},
+		{-v[1], v[0], 0},
+	}
+
+	var R [3][3]float64
+	for p := 0; p < 3; p++ {
+		for q := 0; q < 3; q++ {
+			vx2 := 0.
+			for r := 0; r < 3; r++ {
+				vx2 += vx[p][r] * vx[r][q]
+			}
+			R[p][q] = vx[p][q] + factor*vx2
+		}
+		R[p][p] += 1
+	}
+
+	return R
+}
+
+// referenceFrame returns the rotation taking the unit vector m to z whose first row is the normalised projection of ref
+// onto the plane perpendicular to m.
+func referenceFrame(m, ref [3]float64) [3][3]float64 {
+
+	e1, ok := perpendicular(m, ref)
+	if !ok {
+		// fall back on the coordinate axis least aligned with m, which is never within 1/sqrt(3) of parallel.
+		var axis [3]float64
+		c := 0
+		for c_ := 1; c_ < 3; c_++ {
+			if math.Abs(m[c_]) < math.Abs(m[c]) {
+				c = c_
+			}
+		}
+		axis[c] = 1
+		e1, _ = perpendicular(m, axis)
+	}
+
+	e2 := [3]float64{
+		m[1]*e1[2] - m[2]*e1[1],
+		m[2]*e1[0] - m[0]*e1[2],
+		m[0]*e1[1] - m[1]*e1[0],
+	}
+
+	return [3][3]float64{e1, e2, m}
+}
+
+// perpendicular returns the normalised component of ref perpendicular to the unit vector m.
+// It returns false if this component is too small to define a direction.
+func perpendicular(m, ref [3]float64) ([3]float64, bool) {
+
+	dot := m[0]*ref[0] + m[1]*ref[1] + m[2]*ref[2]
+
+	var e [3]float64
+	norm := 0.
+	for c := 0; c < 3; c++ {
+		e[c] = ref[c] - dot*m[c]
+		norm += e[c] * e[c]
+	}
+	norm = math.Sqrt(norm)
+
+	if norm < 1e-6 {
+		return e, false
+	}
+
+	for c := 0; c < 3; c++ {
+		e[c] /= norm
+	}
+	return e, true
+}
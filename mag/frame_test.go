@@ -0,0 +1,67 @@
+package mag
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestLocalFrame checks that the local frames are proper rotations taking m to z for every gauge,
+// including for directions (anti)parallel to z and to the gauge reference, where the spherical angles are singular.
+func TestLocalFrame(t *testing.T) {
+
+	seed := 0
+	rng := rand.New(rand.NewSource(int64(seed)))
+
+	directions := [][3]float64{
+		{0, 0, 1}, {0, 0, -1}, {1, 0, 0}, {-1, 0, 0}, {0, 1e-9, -1},
+	}
+	numTests := 100
+	for i := 0; i < numTests; i++ {
+		directions = append(directions, tests.Random3Float(rng))
+	}
+
+	defer func(gauge int) { FrameGauge = gauge }(FrameGauge)
+
+	for _, gauge := range []int{RODRIGUES_GAUGE, REFERENCE_GAUGE} {
+		FrameGauge = gauge
+		for idx, m := range directions {
+			if err := frameError(LocalFrame(m), m); err > 1e-9 {
+				t.Errorf("gauge %d, direction %d: frame error %e", gauge, idx, err)
+			}
+		}
+	}
+}
+
+// frameError returns the largest deviation of R from a proper rotation satisfying R m = z.
+func frameError(R [3][3]float64, m [3]float64) float64 {
+
+	err := 0.
+	for p := 0; p < 3; p++ {
+		Rm := 0.
+		for q := 0; q < 3; q++ {
+			Rm += R[p][q] * m[q]
+
+			RRT := 0.
+			for r := 0; r < 3; r++ {
+				RRT += R[p][r] * R[q][r]
+			}
+			if p == q {
+				RRT -= 1
+			}
+			err = math.Max(err, math.Abs(RRT))
+		}
+		if p == 2 {
+			Rm -= 1
+		}
+		err = math.Max(err, math.Abs(Rm))
+	}
+
+	det := R[0][0]*(R[1][1]*R[2][2]-R[1][2]*R[2][1]) -
+		R[0][1]*(R[1][0]*R[2][2]-R[1][2]*R[2][0]) +
+		R[0][2]*(R[1][0]*R[2][1]-R[1][1]*R[2][0])
+
+	return math.Max(err, math.Abs(det-1))
+}
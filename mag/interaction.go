@@ -0,0 +1,92 @@
+package mag
+
+import (
+	"github.com/mumax/3/data"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// An Interaction is a term in the energy of the magnetisation. Each registered Interaction contributes to the
+// self-interaction tensor, the self-interaction field, and the ground state field, so a new term only needs to be registered.
+type Interaction interface {
+
+	// Tensor returns the tensor of the interaction linearised about the ground state stored in en.M,
+	// with energy .5 * cellvolume * Σ_rr' s_r t_rr' s_r' for a deviation s. For an interaction which is quadratic in m,
+	// this is its self-interaction tensor. An interaction which is linear in m may return the zero value Tensor{}.
+	Tensor() Tensor
+
+	// AddField adds the linearised field -(1/Ms) Σ_r' t_rr' s_r' of the interaction for a real magnetisation s to dst.
+	// Note that this assumes that the inputs live on the GPU.
+	AddField(dst, s *data.Slice)
+
+	// AddGroundStateField adds the field of the interaction for the ground state stored in en.M to dst.
+	// Note that this assumes that dst lives on the GPU.
+	AddGroundStateField(dst *data.Slice)
+}
+
+type registeredInteraction struct {
+	name        string
+	interaction Interaction
+}
+
+var (
+	interactions = []registeredInteraction{
+		{"demag", DemagInteraction{}},
+		{"exchange", ExchangeInteraction{}},
+		{"anisotropy", AnisotropyInteraction{}},
+		{"zeeman", ZeemanInteraction{}},
+		{"magnetoelastic", MagnetoelasticInteraction{}},
+		{"interlayer", InterlayerInteraction{}},
+		{"surface anisotropy", SurfaceAnisotropyInteraction{}},
+	}
+)
+
+// Register adds an Interaction to the system under name. If an interaction is already registered under name, it is replaced.
+// To checkpoint a solve, an Interaction other than those of this package must be a fmt.Stringer, whose String gives the values of
+// all of its parameters, as the checkpoint is matched to the system by a hash of them.
+func Register(name string, i Interaction) {
+	for r := range interactions {
+		if interactions[r].name == name {
+			interactions[r].interaction = i
+			return
+		}
+	}
+	interactions = append(interactions, registeredInteraction{name, i})
+}
+
+// Unregister removes the Interaction registered under name from the system. It does nothing if there is none.
+func Unregister(name string) {
+	for r := range interactions {
+		if interactions[r].name == name {
+			interactions = append(interactions[:r], interactions[r+1:]...)
+			return
+		}
+	}
+}
+
+// Interactions returns the names of the registered interactions, and the interactions, in the order they were registered.
+func Interactions() ([]string, []Interaction) {
+	names := make([]string, len(interactions))
+	is := make([]Interaction, len(interactions))
+	for r, ri := range interactions {
+		names[r] = ri.name
+		is[r] = ri.interaction
+	}
+	return names, is
+}
+
+// AddSIField adds the self-interaction field of all the registered interactions for a real magnetisation s to dst.
+// Note that this assumes that the inputs live on the GPU.
+func AddSIField(dst, s *data.Slice) {
+	for _, ri := range interactions {
+		ri.interaction.AddField(dst, s)
+	}
+}
+
+// AddGroundStateField adds the field of all the registered interactions for the ground state stored in en.M to dst.
+// Note that this assumes that dst lives on the GPU.
+func AddGroundStateField(dst *data.Slice) {
+	for _, ri := range interactions {
+		ri.interaction.AddGroundStateField(dst)
+	}
+}
@@ -0,0 +1,46 @@
+package mag
+
+import (
+	"math/rand"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestRegister checks that registering a second copy of the uniaxial anisotropy interaction gives the same
+// linear Hamiltonian as doubling the anisotropy constant.
+func TestRegister(t *testing.T) {
+
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		seed := 0
+		rng := rand.New(rand.NewSource(int64(seed)))
+
+		Setup(s)
+		en.Relax()
+
+		Register("second anisotropy", AnisotropyInteraction{})
+		registered := LinearHamiltonianTensor()
+		Unregister("second anisotropy")
+
+		en.Ku1.Set(2 * en.Ku1.GetRegion(0))
+		doubled := LinearHamiltonianTensor()
+
+		rnd := tests.RandomSlice(3, en.MeshSize(), rng)
+		fieldDoubled, errD := doubled.TSP(rnd)
+		fieldRegistered, errR := registered.TSP(rnd)
+		if errD != nil || errR != nil {
+			t.Fatalf("%d: %v %v", test_idx, errD, errR)
+		}
+		err := tests.EqualSlices(fieldDoubled, fieldRegistered, 1e-4)
+		if err > 0 {
+			t.Errorf("%d: Hamiltonians are not equal: %d%% error", test_idx, 100*err/(3*rnd.Len()))
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// DemagInteraction is the demagnetising interaction.
+type DemagInteraction struct{}
+
+func (DemagInteraction) Tensor() Tensor {
+	return DemagTensor()
+}
+
+func (DemagInteraction) AddField(dst, s *data.Slice) {
+	B := cuda.Buffer(3, dst.Size())
+	defer cuda.Recycle(B)
+	WithMagnetisation(s, func() { en.SetDemagField(B) })
+	cuda.Madd2(dst, dst, B, 1, 1)
+}
+
+func (DemagInteraction) AddGroundStateField(dst *data.Slice) {
+	B := cuda.Buffer(3, dst.Size())
+	defer cuda.Recycle(B)
+	en.SetDemagField(B)
+	cuda.Madd2(dst, dst, B, 1, 1)
+}
+
+// ExchangeInteraction is the exchange interaction.
+type ExchangeInteraction struct{}
+
+func (ExchangeInteraction) Tensor() Tensor {
+	return ExchangeTensor()
+}
+
+func (ExchangeInteraction) AddField(dst, s *data.Slice) {
+	WithMagnetisation(s, func() { en.AddExchangeField(dst) })
+}
+
+func (ExchangeInteraction) AddGroundStateField(dst *data.Slice) {
+	en.AddExchangeField(dst)
+}
+
+// AnisotropyInteraction is the uniaxial anisotropy interaction.
+type AnisotropyInteraction struct{}
+
+func (AnisotropyInteraction) Tensor() Tensor {
+	return UniAnisTensor()
+}
+
+func (AnisotropyInteraction) AddField(dst, s *data.Slice) {
+	WithMagnetisation(s, func() { en.AddAnisotropyField(dst) })
+}
+
+func (AnisotropyInteraction) AddGroundStateField(dst *data.Slice) {
+	en.AddAnisotropyField(dst)
+}
+
+// ZeemanInteraction is the interaction with the external field en.B_ext.
+// It is linear in m, so only contributes to the ground state field.
+type ZeemanInteraction struct{}
+
+func (ZeemanInteraction) Tensor() Tensor {
+	return Tensor{}
+}
+
+func (ZeemanInteraction) AddField(dst, s *data.Slice) {}
+
+func (ZeemanInteraction) AddGroundStateField(dst *data.Slice) {
+	en.B_ext.AddTo(dst)
+}
+
+// MagnetoelasticInteraction is the magnetoelastic interaction with the static strain set in mumax.
+type MagnetoelasticInteraction struct{}
+
+func (MagnetoelasticInteraction) Tensor() Tensor {
+	return MagnetoelasticTensor()
+}
+
+func (MagnetoelasticInteraction) AddField(dst, s *data.Slice) {
+	WithMagnetisation(s, func() { en.AddMagnetoelasticField(dst) })
+}
+
+func (MagnetoelasticInteraction) AddGroundStateField(dst *data.Slice) {
+	en.AddMagnetoelasticField(dst)
+}
+
+// InterlayerInteraction is the interaction of the InterlayerCouplings.
+// Its linearised field is applied on the GPU, as set up by LineariseInterlayer.
+type InterlayerInteraction struct{}
+
+func (InterlayerInteraction) Tensor() Tensor {
+	if len(InterlayerCouplings) == 0 {
+		return Tensor{}
+	}
+	return InterlayerTensor()
+}
+
+func (InterlayerInteraction) AddField(dst, s *data.Slice) {
+	if len(InterlayerCouplings) == 0 {
+		return
+	}
+	if interlayerField == nil {
+		LineariseInterlayer()
+	}
+	interlayerField.AddTo(dst, s)
+}
+
+func (InterlayerInteraction) AddGroundStateField(dst *data.Slice) {
+	if len(InterlayerCouplings) == 0 {
+		return
+	}
+	addHostField(dst, InterlayerField(en.M.Buffer().HostCopy()))
+}
+
+// SurfaceAnisotropyInteraction is the interaction of the SurfaceAnisotropies.
+// These are local to the surface cells, so its fields are calculated on the CPU.
+type SurfaceAnisotropyInteraction struct{}
+
+func (SurfaceAnisotropyInteraction) Tensor() Tensor {
+	if len(SurfaceAnisotropies) == 0 {
+		return Tensor{}
+	}
+	return SurfaceAnisotropyTensor()
+}
+
+func (SurfaceAnisotropyInteraction) AddField(dst, s *data.Slice) {
+	if len(SurfaceAnisotropies) == 0 {
+		return
+	}
+	B := data.NewSlice(3, s.Size())
+	AddSurfaceAnisotropyField(B, s.HostCopy())
+	addHostField(dst, B)
+}
+
+func (SurfaceAnisotropyInteraction) AddGroundStateField(dst *data.Slice) {
+	SurfaceAnisotropyInteraction{}.AddField(dst, en.M.Buffer())
+}
+
+// addHostField adds B, which lives on the CPU, to dst, which lives on the GPU.
+func addHostField(dst, B *data.Slice) {
+	BGPU := cuda.Buffer(3, dst.Size())
+	defer cuda.Recycle(BGPU)
+	data.Copy(BGPU, B)
+	cuda.Madd2(dst, dst, BGPU, 1, 1)
+}
@@ -0,0 +1,241 @@
+package mag
+
+import (
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// An InterlayerCoupling couples the magnetisation of two layers separated by a spacer, as the RKKY coupling does in
+// synthetic antiferromagnets and spin valves. The energy per unit area of the interface is -J1 m1.m2 - J2 (m1.m2)²,
+// and each cell of one layer is coupled to the cell of the other layer in the same column.
+// Cells with zero saturation magnetisation are not coupled.
+type InterlayerCoupling struct {
+	J1, J2 float64  // The bilinear and biquadratic coupling constants (J/m2).
+	pairs  [][2]int // The coupled cells, by Tensor.Idx.
+}
+
+var (
+	// InterlayerCouplings are included in the linear Hamiltonian and the self-interaction fields.
+	// mumax does not know about them, so to relax the ground state with them, add the field term InterlayerQuantity{} to mumax.
+	InterlayerCouplings []InterlayerCoupling
+)
+
+// NewPlaneCoupling returns the coupling between the z-planes with indices k1 and k2.
+func NewPlaneCoupling(k1, k2 int, J1, J2 float64) InterlayerCoupling {
+
+	size := en.MeshSize()
+	ic := InterlayerCoupling{J1: J1, J2: J2}
+
+	for j := 0; j < size[1]; j++ {
+		for i := 0; i < size[0]; i++ {
+			ic.pairs = append(ic.pairs, [2]int{
+				size[0]*(size[1]*k1+j) + i,
+				size[0]*(size[1]*k2+j) + i,
+			})
+		}
+	}
+
+	return ic
+}
+
+// NewRegionCoupling returns the coupling between the regions with indices r1 and r2.
+// In each column the top cell of the lower region is coupled to the bottom cell of the upper region.
+func NewRegionCoupling(r1, r2 int, J1, J2 float64) InterlayerCoupling {
+
+	size := en.MeshSize()
+	regions := RegionIndices()
+	ic := InterlayerCoupling{J1: J1, J2: J2}
+
+	for j := 0; j < size[1]; j++ {
+		for i := 0; i < size[0]; i++ {
+
+			bottom := [2]int{-1, -1}
+			top := [2]int{-1, -1}
+			for k := 0; k < size[2]; k++ {
+				for l, r := range [2]int{r1, r2} {
+					if regions[k][j][i] != r {
+						continue
+					}
+					if bottom[l] == -1 {
+						bottom[l] = k
+					}
+					top[l] = k
+				}
+			}
+
+			if bottom[0] == -1 || bottom[1] == -1 {
+				continue
+			}
+
+			var k1, k2 int
+			switch {
+			case top[0] < bottom[1]:
+				k1, k2 = top[0], bottom[1]
+			case top[1] < bottom[0]:
+				k1, k2 = bottom[0], top[1]
+			default:
+				continue // the regions are interleaved in this column.
+			}
+
+			ic.pairs = append(ic.pairs, [2]int{
+				size[0]*(size[1]*k1+j) + i,
+				size[0]*(size[1]*k2+j) + i,
+			})
+		}
+	}
+
+	return ic
+}
+
+// InterlayerTensor returns the tensor of the InterlayerCouplings, linearised about the ground state stored in en.M.
+// For the bilinear coupling it is a self-interaction tensor. The biquadratic coupling is not quadratic in m,
+// so the ground state field it gives is not -(1/Ms) Σ_r' t_rr' m_r', and is instead given by InterlayerField.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func InterlayerTensor() Tensor {
+
+	t := ZeroTensor(3, en.MeshSize())
+	n := t.To4D()
+
+	m := en.M.Buffer().HostCopy()
+	forEachCoupledPair(m, func(a, b int, ma, mb [3]float64, J1, J2 float64) {
+
+		mamb := dot3(ma, mb)
+		for p := 0; p < 3; p++ {
+			for q := 0; q < 3; q++ {
+				n[p][q][a][a] += -2 * J2 * mb[p] * mb[q]
+				n[p][q][b][b] += -2 * J2 * ma[p] * ma[q]
+				n[p][q][a][b] += -2 * J2 * mb[p] * ma[q]
+				n[p][q][b][a] += -2 * J2 * ma[p] * mb[q]
+			}
+			n[p][p][a][b] += -J1 - 2*J2*mamb
+			n[p][p][b][a] += -J1 - 2*J2*mamb
+		}
+	})
+
+	return t
+}
+
+// InterlayerField returns the field of the InterlayerCouplings for the magnetisation mSl, which must live on the CPU.
+// The returned slice lives on the CPU.
+func InterlayerField(mSl *data.Slice) *data.Slice {
+
+	B := data.NewSlice(3, mSl.Size())
+	BArr := B.Host()
+	ms := HostSlice(en.Msat).Host()[0]
+
+	forEachCoupledPair(mSl, func(a, b int, ma, mb [3]float64, J1, J2 float64) {
+		f := J1 + 2*J2*dot3(ma, mb)
+		for c := 0; c < 3; c++ {
+			BArr[c][a] += float32(f * mb[c] / float64(ms[a]))
+			BArr[c][b] += float32(f * ma[c] / float64(ms[b]))
+		}
+	})
+
+	return B
+}
+
+// AddInterlayerLinearField adds the linearised field -(1/Ms) Σ_r' t_rr' s_r' of the InterlayerCouplings, for the tensor t
+// returned by InterlayerTensor, to dst. Both dst and s must live on the CPU.
+func AddInterlayerLinearField(dst, s *data.Slice) {
+
+	dstArr := dst.Host()
+	sArr := s.Host()
+	ms := HostSlice(en.Msat).Host()[0]
+
+	m := en.M.Buffer().HostCopy()
+	forEachCoupledPair(m, func(a, b int, ma, mb [3]float64, J1, J2 float64) {
+
+		sa := [3]float64{float64(sArr[0][a]), float64(sArr[1][a]), float64(sArr[2][a])}
+		sb := [3]float64{float64(sArr[0][b]), float64(sArr[1][b]), float64(sArr[2][b])}
+
+		mamb := dot3(ma, mb)
+		f := 2 * J2 * (dot3(sa, mb) + dot3(ma, sb))
+		for c := 0; c < 3; c++ {
+			dstArr[c][a] += float32(((J1+2*J2*mamb)*sb[c] + f*mb[c]) / float64(ms[a]))
+			dstArr[c][b] += float32(((J1+2*J2*mamb)*sa[c] + f*ma[c]) / float64(ms[b]))
+		}
+	})
+}
+
+// the linearised field of the InterlayerCouplings on the GPU, set by LineariseInterlayer.
+var interlayerField *stencil
+
+// LineariseInterlayer builds the linearised field of the InterlayerCouplings about the ground state stored in en.M,
+// as AddInterlayerLinearField gives it, and keeps it on the GPU, where InterlayerInteraction.AddField applies it.
+// It is called once per solve by field.NewLinearEvolution, and should be called again if the ground state, the saturation
+// magnetisation or the couplings are changed without creating a new LinearEvolution.
+func LineariseInterlayer() {
+
+	if interlayerField != nil {
+		interlayerField.Free()
+		interlayerField = nil
+	}
+	if len(InterlayerCouplings) == 0 {
+		return
+	}
+
+	st := newStencil(3, 3, en.MeshSize())
+	ms := HostSlice(en.Msat).Host()[0]
+
+	m := en.M.Buffer().HostCopy()
+	forEachCoupledPair(m, func(a, b int, ma, mb [3]float64, J1, J2 float64) {
+
+		mamb := dot3(ma, mb)
+		for _, pair := range [2][2]int{{a, b}, {b, a}} {
+			this, other := pair[0], pair[1]
+			mThis, mOther := ma, mb
+			if this == b {
+				mThis, mOther = mb, ma
+			}
+			f := 1 / float64(ms[this])
+			for c := 0; c < 3; c++ {
+				st.add(c, c, this, other, f*(J1+2*J2*mamb))
+				for q := 0; q < 3; q++ {
+					st.add(c, q, this, other, f*2*J2*mOther[c]*mThis[q])
+					st.add(c, q, this, this, f*2*J2*mOther[c]*mOther[q])
+				}
+			}
+		}
+	})
+
+	interlayerField = st
+}
+
+// forEachCoupledPair calls fn for each pair of coupled cells a and b of each of the InterlayerCouplings, with the magnetisation mSl
+// at the two cells, and the coupling constants divided by the cell thickness, which make them into energy densities.
+func forEachCoupledPair(mSl *data.Slice, fn func(a, b int, ma, mb [3]float64, J1, J2 float64)) {
+
+	if len(InterlayerCouplings) == 0 {
+		return
+	}
+
+	m := mSl.Host()
+	ms := HostSlice(en.Msat).Host()[0]
+	dz := en.Mesh().CellSize()[2]
+
+	for _, ic := range InterlayerCouplings {
+		for _, pair := range ic.pairs {
+			a, b := pair[0], pair[1]
+			if ms[a] == 0 || ms[b] == 0 {
+				continue
+			}
+			ma := [3]float64{float64(m[0][a]), float64(m[1][a]), float64(m[2][a])}
+			mb := [3]float64{float64(m[0][b]), float64(m[1][b]), float64(m[2][b])}
+			fn(a, b, ma, mb, ic.J1/dz, ic.J2/dz)
+		}
+	}
+}
+
+// InterlayerQuantity is a mumax quantity evaluating to the field of the InterlayerCouplings for the magnetisation en.M.
+// Adding it to the mumax effective field with en.AddFieldTerm(InterlayerQuantity{}) includes the couplings when relaxing the ground state.
+type InterlayerQuantity struct{}
+
+func (InterlayerQuantity) NComp() int {
+	return 3
+}
+
+func (InterlayerQuantity) EvalTo(dst *data.Slice) {
+	data.Copy(dst, InterlayerField(en.M.Buffer().HostCopy()))
+}
@@ -0,0 +1,73 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	en "github.com/mumax/3/engine"
+)
+
+// LocalHamiltonian returns the 3x3 diagonal blocks of the linear Hamiltonian tensor at each cell, indexed as Tensor.Idx,
+// from the local terms only: the self term of the exchange interaction, the uniaxial anisotropy and the ground state field term.
+// The coupling of the cell to the others, and the self term of the demagnetising interaction, are left out.
+// It does not build the full tensor, so it is suited to preconditioners for large systems.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func LocalHamiltonian() [][3][3]float64 {
+
+	size := en.MeshSize()
+	Nx := size[0]
+	Ny := size[1]
+	Nz := size[2]
+	cellsize := en.Mesh().CellSize()
+
+	m := en.M.Buffer().HostCopy().Vectors()
+	ms := HostSlice(en.Msat).Scalars()
+	Ku1 := HostSlice(en.Ku1).Scalars()
+	AnisU := HostSlice(en.AnisU).Vectors()
+
+	B0GPU := cuda.NewSlice(3, size)
+	defer B0GPU.Free()
+	cuda.Zero(B0GPU)
+	AddGroundStateField(B0GPU)
+	B0 := B0GPU.HostCopy().Vectors()
+
+	blocks := make([][3][3]float64, Nx*Ny*Nz)
+
+	for k := 0; k < Nz; k++ {
+		for j := 0; j < Ny; j++ {
+			for i := 0; i < Nx; i++ {
+
+				idx := Nx*(Ny*k+j) + i
+				var b [3][3]float64
+
+				// the exchange self term, from the neighbours within the mesh.
+				exchange := 0.
+				for axis := 0; axis < 3; axis++ {
+					for _, step := range [2]int{-1, 1} {
+						n := [3]int{i, j, k}
+						n[axis] += step
+						if n[axis] < 0 || n[axis] >= size[axis] {
+							continue
+						}
+						d := cellsize[axis]
+						exchange += 2. / (d * d) * float64(en.ExchangeAtCell(i, j, k, n[0], n[1], n[2]))
+					}
+				}
+
+				gsTerm := 0.
+				for c := 0; c < 3; c++ {
+					gsTerm += float64(B0[c][k][j][i] * m[c][k][j][i])
+				}
+
+				for c := 0; c < 3; c++ {
+					for c_ := 0; c_ < 3; c_++ {
+						b[c][c_] = float64(-2 * Ku1[k][j][i] * AnisU[c][k][j][i] * AnisU[c_][k][j][i])
+					}
+					b[c][c] += exchange + gsTerm*float64(ms[k][j][i])
+				}
+
+				blocks[idx] = b
+			}
+		}
+	}
+
+	return blocks
+}
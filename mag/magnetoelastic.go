@@ -0,0 +1,106 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// The components of a strain, as stored in the 6 component slices taken by MagnetoelasticDriveField.
+const (
+	STRAIN_XX = 0
+	STRAIN_YY = 1
+	STRAIN_ZZ = 2
+	STRAIN_XY = 3
+	STRAIN_XZ = 4
+	STRAIN_YZ = 5
+)
+
+// MagnetoelasticTensor returns the self-interaction tensor for the magnetoelastic interaction with the static strain set in mumax (exx, eyy, ...),
+// with energy density B1 (exx mx² + eyy my² + ezz mz²) + 2 B2 (exy mx my + exz mx mz + eyz my mz).
+// This is quadratic in m, and local, so its tensor at each cell is read off from the mumax magnetoelastic field of a uniform magnetisation along each axis.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func MagnetoelasticTensor() Tensor {
+
+	size := en.MeshSize()
+	t := ZeroTensor(3, size)
+	n := t.To4D()
+
+	ms := HostSlice(en.Msat).Host()[0]
+
+	probeCPU := data.NewSlice(3, size)
+	probe := cuda.NewSlice(3, size)
+	defer probe.Free()
+	B := cuda.NewSlice(3, size)
+	defer B.Free()
+
+	for c_ := 0; c_ < 3; c_++ {
+
+		probeArr := probeCPU.Host()
+		for c := 0; c < 3; c++ {
+			for idx := range probeArr[c] {
+				if c == c_ {
+					probeArr[c][idx] = 1
+				} else {
+					probeArr[c][idx] = 0
+				}
+			}
+		}
+		data.Copy(probe, probeCPU)
+
+		cuda.Zero(B)
+		WithMagnetisation(probe, func() { en.AddMagnetoelasticField(B) })
+		BArr := B.HostCopy().Host()
+
+		for c := 0; c < 3; c++ {
+			for idx := range BArr[c] {
+				n[c][c_][idx][idx] = -float64(ms[idx] * BArr[c][idx])
+			}
+		}
+	}
+
+	return t
+}
+
+// MagnetoelasticDriveField returns the field which a dynamic strain, with components (STRAIN_XX, ...) on the CPU, exerts
+// on the ground state stored in en.M through the magnetoelastic interaction with the mumax parameters B1 and B2.
+// To first order this drives the modes of the system; see ExcitationOverlaps in package solver.
+// The returned slice lives on the CPU.
+func MagnetoelasticDriveField(strain *data.Slice) *data.Slice {
+
+	if strain.NComp() != 6 {
+		panic("a strain has 6 components")
+	}
+
+	m := en.M.Buffer().HostCopy().Host()
+	ms := HostSlice(en.Msat).Host()[0]
+	B1 := HostSlice(en.B1).Host()[0]
+	B2 := HostSlice(en.B2).Host()[0]
+	ε := strain.Host()
+
+	h := data.NewSlice(3, strain.Size())
+	hArr := h.Host()
+
+	for idx := range ms {
+
+		if ms[idx] == 0 {
+			continue
+		}
+
+		S := [3][3]float32{
+			{B1[idx] * ε[STRAIN_XX][idx], B2[idx] * ε[STRAIN_XY][idx], B2[idx] * ε[STRAIN_XZ][idx]},
+			{B2[idx] * ε[STRAIN_XY][idx], B1[idx] * ε[STRAIN_YY][idx], B2[idx] * ε[STRAIN_YZ][idx]},
+			{B2[idx] * ε[STRAIN_XZ][idx], B2[idx] * ε[STRAIN_YZ][idx], B1[idx] * ε[STRAIN_ZZ][idx]},
+		}
+
+		for c := 0; c < 3; c++ {
+			for c_ := 0; c_ < 3; c_++ {
+				hArr[c][idx] += -2 * S[c][c_] * m[c_][idx] / ms[idx]
+			}
+		}
+	}
+
+	return h
+}
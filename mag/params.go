@@ -0,0 +1,21 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+)
+
+// A Slicer is satisfied by the mumax parameters and excitations, such as en.Msat and en.B_ext.
+type Slicer interface {
+	Slice() (*data.Slice, bool)
+}
+
+// HostSlice returns a copy on the CPU of the values at each position of a mumax parameter or excitation.
+func HostSlice(q Slicer) *data.Slice {
+	gpu, recycle := q.Slice()
+	host := gpu.HostCopy()
+	if recycle {
+		cuda.Recycle(gpu)
+	}
+	return host
+}
@@ -5,8 +5,6 @@ import (
 	en "github.com/mumax/3/engine"
 
 	. "github.com/will-henderson/mumax-vhf/data"
-
-	"math"
 )
 
 // R is stored in order z, y, x
@@ -15,10 +13,16 @@ type RotationToZ struct {
 }
 
 // InitRotation initialises the variable R, the 3D slice of pointwise rotation matrices used for rotating to z.
-// It satisfies R_r m_r := (0, 0, 1) where m_r is the (supposedly) ground state magnetisation
+// It satisfies R_r m_r := (0, 0, 1) where m_r is the (supposedly) ground state magnetisation,
+// with the transverse axes fixed by FrameGauge (see LocalFrame).
 func (rtz *RotationToZ) InitRotation() {
+	rtz.InitRotationFrom(en.M.Buffer().HostCopy())
+}
+
+// InitRotationFrom initialises R as InitRotation does, for the ground state mSl rather than en.M.
+// mSl must live on the CPU.
+func (rtz *RotationToZ) InitRotationFrom(mSl *data.Slice) {
 
-	mSl := en.M.Buffer().HostCopy()
 	m := mSl.Vectors() //order is Z, Y, X
 
 	rtz.R = make([][][][3][3]float64, mSl.Size()[2])
@@ -29,22 +33,11 @@ func (rtz *RotationToZ) InitRotation() {
 			rtz.R[k][j] = make([][3][3]float64, mSl.Size()[0])
 			for i := 0; i < mSl.Size()[0]; i++ {
 
-				mx := float64(m[0][k][j][i])
-				my := float64(m[1][k][j][i])
-				mz := float64(m[2][k][j][i])
-
-				Cth := mz
-				Sth := math.Sqrt(1 - mz*mz)
-				Cph := mx / Sth
-				Sph := my / Sth
-
-				//edge case where Sth == 0!!!
-
-				rtz.R[k][j][i] = [3][3]float64{
-					{Cth * Cph, Cth * Sph, -Sth},
-					{-Sph, Cph, 0},
-					{Sth * Cph, Sth * Sph, Cth},
-				}
+				rtz.R[k][j][i] = LocalFrame([3]float64{
+					float64(m[0][k][j][i]),
+					float64(m[1][k][j][i]),
+					float64(m[2][k][j][i]),
+				})
 			}
 		}
 	}
@@ -0,0 +1,28 @@
+package mag
+
+import (
+	en "github.com/mumax/3/engine"
+)
+
+// RegionIndices returns the mumax region index of each cell, in order z, y, x.
+// Note that it does not have any inputs. Rather it uses the regions defined by the global variables.
+func RegionIndices() [][][]int {
+
+	size := en.MeshSize()
+
+	// the regions are read once, from the region index of each cell held by the engine.
+	values := en.Regions.HostArray()
+
+	regions := make([][][]int, size[2])
+	for k := 0; k < size[2]; k++ {
+		regions[k] = make([][]int, size[1])
+		for j := 0; j < size[1]; j++ {
+			regions[k][j] = make([]int, size[0])
+			for i := 0; i < size[0]; i++ {
+				regions[k][j][i] = int(values[k][j][i])
+			}
+		}
+	}
+
+	return regions
+}
@@ -0,0 +1,127 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+)
+
+// A stencil is a sparse operator which couples each cell only to the cells at a few fixed offsets, such as its nearest neighbours.
+// Its elements are grouped by the offset between the cells they couple, so that it is applied on the GPU
+// with one shifted copy of the input for each offset. The elements are added on the CPU, and uploaded on the first call to AddTo.
+// It takes slices with nIn components to slices with nOut components.
+type stencil struct {
+	nOut, nIn int
+	size      [3]int
+
+	// the elements coupling each cell idx to the cell idx + delta, indexed [delta][p][q][idx], where p is the component of the result
+	// and q that of the input. The offsets are kept in deltas in the order in which they were added.
+	elements map[int][][][]float32
+	deltas   []int
+
+	// the elements on the GPU, indexed as elements. Elements which are zero at every cell are nil.
+	gpu [][][]*data.Slice
+}
+
+func newStencil(nOut, nIn int, size [3]int) *stencil {
+	return &stencil{nOut: nOut, nIn: nIn, size: size, elements: make(map[int][][][]float32)}
+}
+
+// add adds v to the element coupling component q of cell idx_ to component p of cell idx.
+func (st *stencil) add(p, q, idx, idx_ int, v float64) {
+
+	if v == 0 {
+		return
+	}
+	delta := idx_ - idx
+	e, ok := st.elements[delta]
+	if !ok {
+		n := st.size[0] * st.size[1] * st.size[2]
+		e = make([][][]float32, st.nOut)
+		for p := range e {
+			e[p] = make([][]float32, st.nIn)
+			for q := range e[p] {
+				e[p][q] = make([]float32, n)
+			}
+		}
+		st.elements[delta] = e
+		st.deltas = append(st.deltas, delta)
+	}
+	e[p][q][idx] += float32(v)
+}
+
+// AddTo adds the operation of the stencil on s to dst. Both slices must live on the GPU, dst with nOut components and s with nIn.
+func (st *stencil) AddTo(dst, s *data.Slice) {
+
+	if st.gpu == nil {
+		st.upload()
+	}
+
+	n := st.size[0] * st.size[1] * st.size[2]
+	shifted := cuda.Buffer(st.nIn, st.size)
+	defer cuda.Recycle(shifted)
+
+	for d, delta := range st.deltas {
+
+		// shifted[idx] = s[idx + delta], which is only used by the cells coupled at that offset.
+		src := s
+		if delta != 0 {
+			cuda.Zero(shifted)
+			if delta > 0 {
+				data.Copy(shifted.Slice(0, n-delta), s.Slice(delta, n))
+			} else {
+				data.Copy(shifted.Slice(-delta, n), s.Slice(0, n+delta))
+			}
+			src = shifted
+		}
+
+		for p := 0; p < st.nOut; p++ {
+			for q := 0; q < st.nIn; q++ {
+				if st.gpu[d][p][q] != nil {
+					cuda.AddMul1D(dst.Comp(p), st.gpu[d][p][q], src.Comp(q))
+				}
+			}
+		}
+	}
+}
+
+func (st *stencil) upload() {
+
+	st.gpu = make([][][]*data.Slice, len(st.deltas))
+	for d, delta := range st.deltas {
+		st.gpu[d] = make([][]*data.Slice, st.nOut)
+		for p := 0; p < st.nOut; p++ {
+			st.gpu[d][p] = make([]*data.Slice, st.nIn)
+			for q := 0; q < st.nIn; q++ {
+				e := st.elements[delta][p][q]
+				if allZero(e) {
+					continue
+				}
+				st.gpu[d][p][q] = cuda.NewSlice(1, st.size)
+				data.Copy(st.gpu[d][p][q], data.SliceFromArray([][]float32{e}, st.size))
+			}
+		}
+	}
+}
+
+// Free frees the elements on the GPU, if they have been uploaded. They are uploaded again if the stencil is used afterwards.
+func (st *stencil) Free() {
+	for _, e := range st.gpu {
+		for p := range e {
+			for _, s := range e[p] {
+				if s != nil {
+					s.Free()
+				}
+			}
+		}
+	}
+	st.gpu = nil
+}
+
+func allZero(v []float32) bool {
+	for _, x := range v {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,324 @@
+package mag
+
+import (
+	"math"
+
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/mag"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// The constants used by mumax in the spin-transfer torque prefactors which are not in its mag package.
+const (
+	hbar   = 1.05457173e-34
+	gamma0 = 1.7595e11 // the gyromagnetic ratio fixed in the mumax torque kernels, which is also the default GammaLL.
+)
+
+// LinearSTT holds the spin-transfer torques, linearised about the ground state, as a 3x3 block
+// coupling each cell to itself and to each of its nearest neighbours.
+// Applied to a deviation s, it gives the contribution γ δτ of the Zhang-Li and Slonczewski torques to ds/dt.
+// These terms are not Hermitian, so the eigenfrequencies of the system become complex.
+type LinearSTT struct {
+	size   [3]int
+	blocks [][]sttBlock // indexed by the cell, as Tensor.Idx.
+
+	// the blocks for the GPU, built by the first call to AddTo.
+	gpu *stencil
+}
+
+type sttBlock struct {
+	idx int
+	B   [3][3]float64
+}
+
+// NewLinearSTT returns the linearised spin-transfer torques for the current density en.J, about the ground state stored in en.M.
+// The Zhang-Li and Slonczewski torques are included unless they are disabled in mumax.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func NewLinearSTT() *LinearSTT {
+
+	size := en.MeshSize()
+	Nx := size[0]
+	Ny := size[1]
+	Nz := size[2]
+	cellsize := en.Mesh().CellSize()
+	pbc := en.Mesh().PBC()
+
+	m := en.M.Buffer().HostCopy().Vectors()
+	ms := HostSlice(en.Msat).Scalars()
+	alpha := HostSlice(en.Alpha).Scalars()
+	xi := HostSlice(en.Xi).Scalars()
+	pol := HostSlice(en.Pol).Scalars()
+	lambda := HostSlice(en.Lambda).Scalars()
+	epsPrime := HostSlice(en.EpsilonPrime).Scalars()
+	flt := HostSlice(en.FreeLayerThickness).Scalars()
+	fixedP := HostSlice(en.FixedLayer).Vectors()
+	J := HostSlice(en.J).Vectors()
+
+	l := &LinearSTT{size: size, blocks: make([][]sttBlock, Nx*Ny*Nz)}
+	cellIdx := func(i, j, k int) int { return Nx*(Ny*k+j) + i }
+
+	for k := 0; k < Nz; k++ {
+		for j := 0; j < Ny; j++ {
+			for i := 0; i < Nx; i++ {
+
+				if ms[k][j][i] == 0 {
+					continue
+				}
+
+				idx := cellIdx(i, j, k)
+				m0 := [3]float64{float64(m[0][k][j][i]), float64(m[1][k][j][i]), float64(m[2][k][j][i])}
+				α := float64(alpha[k][j][i])
+				gilb := 1 / (1 + α*α)
+
+				if !en.DisableZhangLiTorque {
+
+					ξ := float64(xi[k][j][i])
+					b := mag.MuB * float64(pol[k][j][i]) / (2 * mag.Qe * gamma0 * float64(ms[k][j][i]) * (1 + ξ*ξ))
+
+					// the central difference stencil of b (J.∇), with neighbours wrapped around periodic boundaries,
+					// and otherwise clamped to the mesh, as in mumax.
+					type stencilPoint struct {
+						i, j, k int
+						w       float64
+					}
+					var points []stencilPoint
+					for c := 0; c < 3; c++ {
+						Jc := float64(J[c][k][j][i])
+						if Jc == 0 || size[c] == 1 {
+							continue
+						}
+						lo := [3]int{i, j, k}
+						hi := [3]int{i, j, k}
+						lo[c] = neighbour(lo[c]-1, size[c], pbc[c] != 0)
+						hi[c] = neighbour(hi[c]+1, size[c], pbc[c] != 0)
+						w := b * Jc / (2 * cellsize[c])
+						points = append(points, stencilPoint{hi[0], hi[1], hi[2], w}, stencilPoint{lo[0], lo[1], lo[2], -w})
+					}
+
+					var h0 [3]float64
+					for _, p := range points {
+						for c := 0; c < 3; c++ {
+							h0[c] += p.w * float64(m[c][p.k][p.j][p.i])
+						}
+					}
+
+					// τ = -gilb [(1 + ξα) m x (m x h) + (ξ - α) m x h], with h = b (J.∇) m, linear in m.
+					mx := crossMatrix(m0)
+					h0x := crossMatrix(h0)
+					mxh0x := crossMatrix(cross3(m0, h0))
+					mh := dot3(m0, h0)
+
+					// the terms from varying the explicit m, for fixed h0.
+					var self [3][3]float64
+					for p := 0; p < 3; p++ {
+						for q := 0; q < 3; q++ {
+							// s x (m x h) + m x (s x h) = -[m x h]x s + (m.h) s - h (m.s)
+							mxsxh := -mxh0x[p][q] - h0[p]*m0[q]
+							if p == q {
+								mxsxh += mh
+							}
+							self[p][q] = -gilb * ((1+ξ*α)*mxsxh - (ξ-α)*h0x[p][q])
+						}
+					}
+					l.add(idx, idx, self)
+
+					// the terms from varying h.
+					var K [3][3]float64
+					for p := 0; p < 3; p++ {
+						for q := 0; q < 3; q++ {
+							mxmx := 0.
+							for r := 0; r < 3; r++ {
+								mxmx += mx[p][r] * mx[r][q]
+							}
+							K[p][q] = -gilb * ((1+ξ*α)*mxmx + (ξ-α)*mx[p][q])
+						}
+					}
+					for _, p := range points {
+						l.add(idx, cellIdx(p.i, p.j, p.k), scale3(K, p.w))
+					}
+				}
+
+				Jz := float64(J[2][k][j][i])
+				if !en.DisableSlonczewskiTorque && Jz != 0 {
+
+					P := [3]float64{float64(fixedP[0][k][j][i]), float64(fixedP[1][k][j][i]), float64(fixedP[2][k][j][i])}
+					P = normalise(P)
+
+					thickness := float64(flt[k][j][i])
+					if thickness == 0 {
+						thickness = float64(Nz) * cellsize[2]
+					}
+
+					β := (hbar / mag.Qe) * Jz / (thickness * float64(ms[k][j][i]))
+					λ2 := float64(lambda[k][j][i] * lambda[k][j][i])
+					denominator := (λ2 + 1) + (λ2-1)*dot3(P, m0)
+					ε := float64(pol[k][j][i]) * λ2 / denominator
+					A := β * ε
+					B := β * float64(epsPrime[k][j][i])
+					dA := -A * (λ2 - 1) / denominator // δA = dA (P.s)
+
+					// τ = gilb [(A + αB) m x (P x m) + (B - αA) P x m], where A depends on m through P.m.
+					Pxm := cross3(P, m0)
+					mxPxm := cross3(m0, Pxm)
+					Pmx := dot3(P, m0)
+					Px := crossMatrix(P)
+					Pxmx := crossMatrix(Pxm)
+
+					var S [3][3]float64
+					for p := 0; p < 3; p++ {
+						for q := 0; q < 3; q++ {
+							// s x (P x m) + m x (P x s) = -[P x m]x s + P (m.s) - (m.P) s
+							mxPxs := -Pxmx[p][q] + P[p]*m0[q]
+							if p == q {
+								mxPxs -= Pmx
+							}
+							S[p][q] = gilb * (dA*(mxPxm[p]-α*Pxm[p])*P[q] + (A+α*B)*mxPxs + (B-α*A)*Px[p][q])
+						}
+					}
+					l.add(idx, idx, S)
+				}
+			}
+		}
+	}
+
+	// multiply by the dynamic factor.
+	for _, blocks := range l.blocks {
+		for b := range blocks {
+			blocks[b].B = scale3(blocks[b].B, en.GammaLL)
+		}
+	}
+
+	return l
+}
+
+func (l *LinearSTT) add(idx, idx_ int, B [3][3]float64) {
+	for b := range l.blocks[idx] {
+		if l.blocks[idx][b].idx == idx_ {
+			for p := 0; p < 3; p++ {
+				for q := 0; q < 3; q++ {
+					l.blocks[idx][b].B[p][q] += B[p][q]
+				}
+			}
+			return
+		}
+	}
+	l.blocks[idx] = append(l.blocks[idx], sttBlock{idx: idx_, B: B})
+}
+
+// Tensor returns the tensor representation of the linearised spin-transfer torques, which can be added to the EigenProblemTensor.
+func (l *LinearSTT) Tensor() Tensor {
+
+	t := ZeroTensor(3, l.size)
+	n := t.To4D()
+
+	for idx, blocks := range l.blocks {
+		for _, b := range blocks {
+			for p := 0; p < 3; p++ {
+				for q := 0; q < 3; q++ {
+					n[p][q][idx][b.idx] += b.B[p][q]
+				}
+			}
+		}
+	}
+
+	return t
+}
+
+// Apply adds the linearised spin-transfer torque γ δτ(s) to dst. Both slices must live on the CPU.
+func (l *LinearSTT) Apply(dst, s *data.Slice) {
+
+	sArr := s.Host()
+	dstArr := dst.Host()
+
+	for idx, blocks := range l.blocks {
+		for _, b := range blocks {
+			for p := 0; p < 3; p++ {
+				for q := 0; q < 3; q++ {
+					dstArr[p][idx] += float32(b.B[p][q]) * sArr[q][b.idx]
+				}
+			}
+		}
+	}
+}
+
+// AddTo adds the linearised spin-transfer torque γ δτ(s) to dst. Both slices must live on the GPU.
+// The blocks are uploaded on the first call, and kept until Free is called.
+func (l *LinearSTT) AddTo(dst, s *data.Slice) {
+
+	if l.gpu == nil {
+		l.gpu = newStencil(3, 3, l.size)
+		for idx, blocks := range l.blocks {
+			for _, b := range blocks {
+				for p := 0; p < 3; p++ {
+					for q := 0; q < 3; q++ {
+						l.gpu.add(p, q, idx, b.idx, b.B[p][q])
+					}
+				}
+			}
+		}
+	}
+	l.gpu.AddTo(dst, s)
+}
+
+// Free frees the blocks on the GPU, if they have been uploaded.
+func (l *LinearSTT) Free() {
+	if l.gpu != nil {
+		l.gpu.Free()
+		l.gpu = nil
+	}
+}
+
+// neighbour returns the index i of a neighbour along a direction with n cells, wrapped around if the direction is periodic
+// and otherwise clamped to the range [0, n).
+func neighbour(i, n int, periodic bool) int {
+	if periodic {
+		return (i + n) % n
+	}
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
+
+func crossMatrix(v [3]float64) [3][3]float64 {
+	return [3][3]float64{
+		{0, -v[2], v[1]},
+		{v[2], 0, -v[0]},
+		{-v[1], v[0], 0},
+	}
+}
+
+func cross3(a, b [3]float64) [3]float64 {
+	return [3]float64{
+		a[1]*b[2] - a[2]*b[1],
+		a[2]*b[0] - a[0]*b[2],
+		a[0]*b[1] - a[1]*b[0],
+	}
+}
+
+func dot3(a, b [3]float64) float64 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}
+
+func scale3(B [3][3]float64, f float64) [3][3]float64 {
+	for p := 0; p < 3; p++ {
+		for q := 0; q < 3; q++ {
+			B[p][q] *= f
+		}
+	}
+	return B
+}
+
+func normalise(v [3]float64) [3]float64 {
+	norm := dot3(v, v)
+	if norm == 0 {
+		return v
+	}
+	norm = math.Sqrt(norm)
+	return [3]float64{v[0] / norm, v[1] / norm, v[2] / norm}
+}
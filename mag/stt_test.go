@@ -0,0 +1,97 @@
+package mag
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestLinearSTT checks the linearised spin-transfer torques against a central difference of the torques calculated by mumax,
+// about the ground state with both Zhang-Li and Slonczewski torques present.
+func TestLinearSTT(t *testing.T) {
+
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		seed := 0
+		rng := rand.New(rand.NewSource(int64(seed)))
+
+		Setup(s)
+		en.Relax()
+
+		en.J.Set(data.Vector{1e11, 5e10, 2e11})
+		en.Pol.Set(0.5)
+		en.Xi.Set(0.05)
+		en.Lambda.Set(2)
+		en.EpsilonPrime.Set(0.1)
+		en.FixedLayer.Set(data.Vector{1, 1, 0})
+
+		l := NewLinearSTT()
+
+		size := en.MeshSize()
+		m0 := en.M.Buffer().HostCopy()
+		rnd := tests.RandomSlice(3, size, rng)
+
+		ε := float32(1e-2)
+		torque := func(f float32) *data.Slice {
+			m := data.NewSlice(3, size)
+			mArr := m.Host()
+			m0Arr := m0.Host()
+			rndArr := rnd.Host()
+			for c := 0; c < 3; c++ {
+				for idx := range mArr[c] {
+					mArr[c][idx] = m0Arr[c][idx] + f*rndArr[c][idx]
+				}
+			}
+			data.Copy(en.M.Buffer(), m)
+
+			τ := cuda.NewSlice(3, size)
+			defer τ.Free()
+			en.AddSTTorque(τ)
+			return τ.HostCopy()
+		}
+
+		plus := torque(ε)
+		minus := torque(-ε)
+		data.Copy(en.M.Buffer(), m0)
+
+		want := data.NewSlice(3, size)
+		wantArr := want.Host()
+		plusArr := plus.Host()
+		minusArr := minus.Host()
+		for c := 0; c < 3; c++ {
+			for idx := range wantArr[c] {
+				wantArr[c][idx] = float32(en.GammaLL) * (plusArr[c][idx] - minusArr[c][idx]) / (2 * ε)
+			}
+		}
+
+		got := data.NewSlice(3, size)
+		l.Apply(got, rnd)
+
+		err := tests.EqualSlices(want, got, 1e-2)
+		if err > 0 {
+			t.Errorf("%d: Torques are not equal: %d%% error", test_idx, 100*err/(3*got.Len()))
+		}
+
+		rndGPU := cuda.NewSlice(3, size)
+		gotGPU := cuda.NewSlice(3, size)
+		data.Copy(rndGPU, rnd)
+		cuda.Zero(gotGPU)
+		l.AddTo(gotGPU, rndGPU)
+		err = tests.EqualSlices(got, gotGPU.HostCopy(), 1e-5)
+		if err > 0 {
+			t.Errorf("%d: Torques on the GPU are not equal to those on the CPU: %d%% error", test_idx, 100*err/(3*got.Len()))
+		}
+		rndGPU.Free()
+		gotGPU.Free()
+		l.Free()
+	}
+}
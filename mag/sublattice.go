@@ -0,0 +1,382 @@
+package mag
+
+import (
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// A Sublattice describes the second sublattice of a two-sublattice antiferromagnet or ferrimagnet.
+// The first sublattice is the mumax magnetisation en.M, with the mumax parameters.
+// The second occupies the same cells, with its own saturation magnetisation, gyromagnetic ratio and ground state.
+// It has the exchange stiffness and uniaxial anisotropy of the first, unless its own are given.
+// The sublattices are coupled by a homogeneous inter-sublattice exchange, with energy density -J m1.m2,
+// and through the demagnetising field of their total magnetisation.
+//
+// The tensors of a two-sublattice system have 6 components: 0-2 are those of the first sublattice and 3-5 those of the second.
+// Modes are returned in the same way, as CSlices with 6 components.
+type Sublattice struct {
+	M     *data.Slice // The ground state of the second sublattice, on the CPU. If nil it is antiparallel to en.M.
+	Msat  float64     // The saturation magnetisation of the second sublattice, in the cells where en.Msat is not zero.
+	Gamma float64     // The gyromagnetic ratio of the second sublattice. If zero en.GammaLL is used.
+	J     float64     // The inter-sublattice exchange constant (J/m3). It is negative for antiparallel coupling.
+
+	Aex   *data.Slice // The exchange stiffness of the second sublattice (J/m), with 1 component, on the CPU. If nil en.Aex is used.
+	Ku1   *data.Slice // The uniaxial anisotropy constant of the second sublattice (J/m3), with 1 component, on the CPU. If nil en.Ku1 is used.
+	AnisU *data.Slice // The uniaxial anisotropy axis of the second sublattice, with 3 components, on the CPU. If nil en.AnisU is used.
+}
+
+// GroundState returns the ground state of the second sublattice on the CPU.
+func (sl Sublattice) GroundState() *data.Slice {
+
+	if sl.M != nil {
+		return sl.M
+	}
+
+	m2 := en.M.Buffer().HostCopy()
+	m2Arr := m2.Host()
+	for c := range m2Arr {
+		for idx := range m2Arr[c] {
+			m2Arr[c][idx] = -m2Arr[c][idx]
+		}
+	}
+	return m2
+}
+
+// systems returns a System for each sublattice, holding its own parameters and ground state.
+// The first is the System set up in mumax, and the second shares its mesh, external field and damping.
+func (sl Sublattice) systems() [2]*System {
+
+	first := engineSystem()
+	second := *first
+
+	ms, _ := sl.saturations()
+	second.Msat = data.NewSlice(1, first.Size)
+	msArr := second.Msat.Host()[0]
+	for idx := range msArr {
+		msArr[idx] = float32(ms[1][idx])
+	}
+
+	second.M = sl.GroundState()
+	if sl.Gamma != 0 {
+		second.GammaLL = sl.Gamma
+	}
+	if sl.Aex != nil {
+		second.Exchange = data.NewSlice(3, first.Size)
+		second.SetAex(sl.Aex)
+	}
+	if sl.Ku1 != nil {
+		second.Ku1 = sl.Ku1
+	}
+	if sl.AnisU != nil {
+		second.AnisU = sl.AnisU
+	}
+
+	return [2]*System{first, &second}
+}
+
+// localElements calls add with each element of the local part of the 6 component linear Hamiltonian, without the ground state terms:
+// the exchange and uniaxial anisotropy of each sublattice, and the inter-sublattice exchange.
+// Component c of sublattice a at idx is coupled to component c_ of sublattice b at idx_.
+func (sl Sublattice) localElements(sys [2]*System, add func(a, c, b, c_, idx, idx_ int, v float64)) {
+
+	for a := 0; a < 2; a++ {
+		visit := func(c, c_, idx, idx_ int, v float64) { add(a, c, a, c_, idx, idx_, v) }
+		sys[a].exchangeElements(visit)
+		sys[a].uniAnisElements(visit)
+	}
+
+	ms := sys[0].Msat.Host()[0]
+	for idx := range ms {
+		if ms[idx] == 0 {
+			continue
+		}
+		for c := 0; c < 3; c++ {
+			add(0, c, 1, c, idx, idx, -sl.J)
+			add(1, c, 0, c, idx, idx, -sl.J)
+		}
+	}
+}
+
+// LinearHamiltonianTensor returns the 6 component tensor representation of the linear Hamiltonian of the two-sublattice system.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func (sl Sublattice) LinearHamiltonianTensor() Tensor {
+	return sl.linearHamiltonianTensor(sl.systems())
+}
+
+func (sl Sublattice) linearHamiltonianTensor(sys [2]*System) Tensor {
+
+	size := sys[0].Size
+	length := size[0] * size[1] * size[2]
+
+	m := [2][3][]float32{}
+	for a := 0; a < 2; a++ {
+		ma := sys[a].M.Host()
+		for c := 0; c < 3; c++ {
+			m[a][c] = ma[c]
+		}
+	}
+
+	ms, ratio := sl.saturations()
+	B_ext := sys[0].BExt.Host()
+
+	t := ZeroTensor(6, size)
+	n := t.To4D()
+
+	// the demagnetising tensor is proportional to the saturation magnetisations at both positions,
+	// so for the second sublattice it is rescaled by the ratio of these.
+	if sys[0].EnableDemag {
+		demag := sys[0].DemagTensor().To4D()
+		scale := func(a, idx int) float64 {
+			if a == 0 {
+				return 1
+			}
+			return ratio[idx]
+		}
+
+		for a := 0; a < 2; a++ {
+			for b := 0; b < 2; b++ {
+				for c := 0; c < 3; c++ {
+					for c_ := 0; c_ < 3; c_++ {
+						for idx := 0; idx < length; idx++ {
+							for idx_ := 0; idx_ < length; idx_++ {
+								n[3*a+c][3*b+c_][idx][idx_] = demag[c][c_][idx][idx_] * scale(a, idx) * scale(b, idx_)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	sl.localElements(sys, func(a, c, b, c_, idx, idx_ int, v float64) {
+		n[3*a+c][3*b+c_][idx][idx_] += v
+	})
+
+	// the ground state field terms, for each sublattice.
+	for a := 0; a < 2; a++ {
+		gsTerm := make([]float64, length)
+		for idx := 0; idx < length; idx++ {
+			zeeTerm := 0.
+			for c := 0; c < 3; c++ {
+				zeeTerm += float64(B_ext[c][idx] * m[a][c][idx])
+			}
+			gsTerm[idx] = zeeTerm * ms[a][idx]
+
+			for c := 0; c < 3; c++ {
+				for b := 0; b < 2; b++ {
+					for c_ := 0; c_ < 3; c_++ {
+						row := n[3*a+c][3*b+c_][idx]
+						for idx_ := 0; idx_ < length; idx_++ {
+							gsTerm[idx] -= float64(m[a][c][idx]*m[b][c_][idx_]) * row[idx_]
+						}
+					}
+				}
+			}
+		}
+
+		for idx := 0; idx < length; idx++ {
+			for c := 0; c < 3; c++ {
+				n[3*a+c][3*a+c][idx][idx] += gsTerm[idx]
+			}
+		}
+	}
+
+	return t
+}
+
+// EigenProblemTensor returns the 6 component tensor representation of the matrix (divided by i, so real)
+// which is diagonalised to find the eigenfrequencies and eigenmodes of the two-sublattice system.
+// Each sublattice precesses with its own gyromagnetic ratio and saturation magnetisation.
+// If Damping is set, both sublattices have the damping parameter en.Alpha.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func (sl Sublattice) EigenProblemTensor() Tensor {
+
+	sys := sl.systems()
+	h := sl.linearHamiltonianTensor(sys)
+	hn := h.To4D()
+
+	size := sys[0].Size
+	length := size[0] * size[1] * size[2]
+
+	m1 := sys[0].M.Host()
+	m2 := sys[1].M.Host()
+	m := [2][3][]float32{{m1[0], m1[1], m1[2]}, {m2[0], m2[1], m2[2]}}
+
+	ms, _ := sl.saturations()
+	γ := [2]float64{sys[0].GammaLL, sys[1].GammaLL}
+
+	alpha := sys[0].damping()
+	Nx := size[0]
+	Ny := size[1]
+
+	t := ZeroTensor(6, size)
+	n := t.To4D()
+
+	for a := 0; a < 2; a++ {
+		for idx := 0; idx < length; idx++ {
+
+			if ms[a][idx] == 0 {
+				continue
+			}
+
+			i := idx % Nx
+			j := (idx / Nx) % Ny
+			k := idx / (Nx * Ny)
+			α := float64(alpha[k][j][i])
+
+			mx := crossMatrix([3]float64{float64(m[a][0][idx]), float64(m[a][1][idx]), float64(m[a][2][idx])})
+
+			// the dynamic matrix, including the dynamic factor.
+			factor := γ[a] / (ms[a][idx] * (1 + α*α))
+			var D [3][3]float64
+			for p := 0; p < 3; p++ {
+				for q := 0; q < 3; q++ {
+					D[p][q] = mx[p][q]
+					for r := 0; r < 3; r++ {
+						D[p][q] += α * mx[p][r] * mx[r][q]
+					}
+					D[p][q] *= factor
+				}
+			}
+
+			for p := 0; p < 3; p++ {
+				for r := 0; r < 3; r++ {
+					if D[p][r] == 0 {
+						continue
+					}
+					for q := 0; q < 6; q++ {
+						src := hn[3*a+r][q][idx]
+						dst := n[3*a+p][q][idx]
+						for idx_ := 0; idx_ < length; idx_++ {
+							dst[idx_] += D[p][r] * src[idx_]
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return t
+}
+
+// saturations returns the saturation magnetisation of each sublattice at each position, in the order of Tensor.Idx,
+// and the ratio of that of the second to the first.
+func (sl Sublattice) saturations() (ms [2][]float64, ratio []float64) {
+
+	ms1 := HostSlice(en.Msat).Host()[0]
+	length := len(ms1)
+
+	ms = [2][]float64{make([]float64, length), make([]float64, length)}
+	ratio = make([]float64, length)
+
+	for idx := 0; idx < length; idx++ {
+		if ms1[idx] == 0 {
+			continue
+		}
+		ms[0][idx] = float64(ms1[idx])
+		ms[1][idx] = sl.Msat
+		ratio[idx] = sl.Msat / float64(ms1[idx])
+	}
+	return ms, ratio
+}
+
+// A SublatticeRotation holds a RotationToZ for the ground state of each sublattice.
+type SublatticeRotation [2]RotationToZ
+
+// NewSublatticeRotation returns the rotations to z of the ground states of en.M and of the second sublattice.
+func NewSublatticeRotation(sl Sublattice) SublatticeRotation {
+	var sr SublatticeRotation
+	sr[0].InitRotation()
+	sr[1].InitRotationFrom(sl.GroundState())
+	return sr
+}
+
+// TransverseTensor rotates each sublattice of the 6 component tensor t such that its ground state is along z,
+// and returns the 4 component tensor with the z components removed: x1, y1, x2, y2.
+func (sr SublatticeRotation) TransverseTensor(t Tensor) Tensor {
+
+	size := t.Size
+	length := t.Length()
+	Nx := size[0]
+	Ny := size[1]
+
+	frame := func(a, idx int) [3][3]float64 {
+		return sr[a].R[idx/(Nx*Ny)][(idx/Nx)%Ny][idx%Nx]
+	}
+
+	n := t.To4D()
+
+	result := ZeroTensor(4, size)
+	rn := result.To4D()
+
+	for a := 0; a < 2; a++ {
+		for b := 0; b < 2; b++ {
+			for idx := 0; idx < length; idx++ {
+				R := frame(a, idx)
+				for idx_ := 0; idx_ < length; idx_++ {
+					R_ := frame(b, idx_)
+
+					// R_r t_rr' R_r'.T, keeping only the transverse rows and columns.
+					for p := 0; p < 2; p++ {
+						for q := 0; q < 2; q++ {
+							val := 0.
+							for r := 0; r < 3; r++ {
+								if R[p][r] == 0 {
+									continue
+								}
+								for s := 0; s < 3; s++ {
+									val += R[p][r] * n[3*a+r][3*b+s][idx][idx_] * R_[q][s]
+								}
+							}
+							rn[2*a+p][2*b+q][idx][idx_] = val
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// DerotateMode rotates a 4 component mode, transverse to the ground state of each sublattice, back to the original basis.
+// It returns a 6 component mode. It assumes the input CSlice lives on the CPU.
+func (sr SublatticeRotation) DerotateMode(mode CSlice) CSlice {
+
+	derotated := NewCSliceCPU(6, mode.Size())
+	for a := 0; a < 2; a++ {
+		part, err := CSliceFromParts(
+			data.NewSlice(2, mode.Size()),
+			data.NewSlice(2, mode.Size()),
+		)
+		if err != nil {
+			panic(err)
+		}
+		for c := 0; c < 2; c++ {
+			data.Copy(part.Real().Comp(c), mode.Real().Comp(2*a+c))
+			data.Copy(part.Imag().Comp(c), mode.Imag().Comp(2*a+c))
+		}
+
+		dr := sr[a].DerotateMode(part)
+		for c := 0; c < 3; c++ {
+			data.Copy(derotated.Real().Comp(3*a+c), dr.Real().Comp(c))
+			data.Copy(derotated.Imag().Comp(3*a+c), dr.Imag().Comp(c))
+		}
+	}
+
+	return derotated
+}
+
+// SublatticeDeviation returns the 3 component deviation of sublattice a (0 or 1) from a 6 component mode.
+// It assumes the input CSlice lives on the CPU.
+func SublatticeDeviation(mode CSlice, a int) CSlice {
+
+	deviation := NewCSliceCPU(3, mode.Size())
+	for c := 0; c < 3; c++ {
+		data.Copy(deviation.Real().Comp(c), mode.Real().Comp(3*a+c))
+		data.Copy(deviation.Imag().Comp(c), mode.Imag().Comp(3*a+c))
+	}
+	return deviation
+}
@@ -0,0 +1,180 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+)
+
+// A SublatticeOperator applies the linearised dynamics of a two-sublattice system on the GPU without building its tensor,
+// so the modes of large systems can be found by iterative solvers. It acts in the frames of a SublatticeRotation,
+// on 4 component slices holding the deviations transverse to the ground state of each sublattice: x1, y1, x2, y2,
+// as the tensor returned by SublatticeRotation.TransverseTensor does.
+type SublatticeOperator struct {
+	size [3]int
+
+	// the local part of the linear Hamiltonian, with the row of each sublattice divided by its saturation magnetisation,
+	// including the ground state terms.
+	hamiltonian *stencil
+
+	// when EnableDemag is set, these take the deviations to the total magnetisation in units of en.Msat,
+	// and the demagnetising field of that to minus its components in the frame of each sublattice. Otherwise they are nil.
+	toLab, fromLab *stencil
+
+	// the precession, with the gyromagnetic ratio of each sublattice and the damping if Damping is set.
+	dynamic *stencil
+}
+
+// NewSublatticeOperator returns the operator of the two-sublattice system with second sublattice sl, in the frames of rot,
+// which should be NewSublatticeRotation(sl). The GPU memory it holds is released by Free.
+func NewSublatticeOperator(sl Sublattice, rot SublatticeRotation) *SublatticeOperator {
+
+	sys := sl.systems()
+	size := sys[0].Size
+	length := size[0] * size[1] * size[2]
+	Nx := size[0]
+	Ny := size[1]
+
+	frame := func(a, idx int) [3][3]float64 {
+		return rot[a].R[idx/(Nx*Ny)][(idx/Nx)%Ny][idx%Nx]
+	}
+
+	ms, ratio := sl.saturations()
+	m := [2][][]float32{sys[0].M.Host(), sys[1].M.Host()}
+
+	op := &SublatticeOperator{
+		size:        size,
+		hamiltonian: newStencil(4, 4, size),
+		dynamic:     newStencil(4, 4, size),
+	}
+
+	// the local terms in the transverse frames, and their contribution to the ground state field, Σ_b,r' H_ab,rr' m_b,r'.
+	var h0 [2][3][]float64
+	for a := 0; a < 2; a++ {
+		for c := 0; c < 3; c++ {
+			h0[a][c] = make([]float64, length)
+		}
+	}
+	sl.localElements(sys, func(a, c, b, c_, idx, idx_ int, v float64) {
+		h0[a][c][idx] += v * float64(m[b][c_][idx_])
+		if ms[a][idx] == 0 {
+			return
+		}
+		R := frame(a, idx)
+		R_ := frame(b, idx_)
+		for p := 0; p < 2; p++ {
+			for q := 0; q < 2; q++ {
+				op.hamiltonian.add(2*a+p, 2*b+q, idx, idx_, R[p][c]*v*R_[q][c_]/ms[a][idx])
+			}
+		}
+	})
+
+	// the demagnetising field of the total magnetisation, which is the same for both sublattices.
+	var demag [][]float32
+	if sys[0].EnableDemag {
+		op.toLab = newStencil(3, 4, size)
+		op.fromLab = newStencil(4, 3, size)
+		total := data.NewSlice(3, size)
+		totalArr := total.Host()
+		for a := 0; a < 2; a++ {
+			for idx := 0; idx < length; idx++ {
+				if ms[a][idx] == 0 {
+					continue
+				}
+				scale := 1.
+				if a == 1 {
+					scale = ratio[idx]
+				}
+				R := frame(a, idx)
+				for c := 0; c < 3; c++ {
+					for p := 0; p < 2; p++ {
+						op.toLab.add(c, 2*a+p, idx, idx, scale*R[p][c])
+						op.fromLab.add(2*a+p, c, idx, idx, -R[p][c])
+					}
+					totalArr[c][idx] += float32(scale) * m[a][c][idx]
+				}
+			}
+		}
+
+		totalGPU := cuda.NewSlice(3, size)
+		B := cuda.NewSlice(3, size)
+		data.Copy(totalGPU, total)
+		cuda.Zero(B)
+		DemagInteraction{}.AddField(B, totalGPU)
+		demag = B.HostCopy().Host()
+		totalGPU.Free()
+		B.Free()
+	}
+
+	// the ground state terms, (B0.m) on the diagonal, with B0 = B_ext + B_demag - (1/Ms) Σ_b,r' H_ab,rr' m_b,r'.
+	B_ext := sys[0].BExt.Host()
+	for a := 0; a < 2; a++ {
+		for idx := 0; idx < length; idx++ {
+			if ms[a][idx] == 0 {
+				continue
+			}
+			gsTerm := 0.
+			for c := 0; c < 3; c++ {
+				b := float64(B_ext[c][idx]) - h0[a][c][idx]/ms[a][idx]
+				if demag != nil {
+					b += float64(demag[c][idx])
+				}
+				gsTerm += b * float64(m[a][c][idx])
+			}
+			for p := 0; p < 2; p++ {
+				op.hamiltonian.add(2*a+p, 2*a+p, idx, idx, gsTerm)
+			}
+		}
+	}
+
+	// in its own frame the ground state of each sublattice is z, so m x becomes a rotation by π/2 in the transverse plane,
+	// and the precession (γ / (1 + α²)) (m x + α m x m x) is this block.
+	alpha := sys[0].damping()
+	for a := 0; a < 2; a++ {
+		for idx := 0; idx < length; idx++ {
+			if ms[a][idx] == 0 {
+				continue
+			}
+			α := float64(alpha[idx/(Nx*Ny)][(idx/Nx)%Ny][idx%Nx])
+			f := sys[a].GammaLL / (1 + α*α)
+			op.dynamic.add(2*a, 2*a, idx, idx, -f*α)
+			op.dynamic.add(2*a, 2*a+1, idx, idx, -f)
+			op.dynamic.add(2*a+1, 2*a, idx, idx, f)
+			op.dynamic.add(2*a+1, 2*a+1, idx, idx, -f*α)
+		}
+	}
+
+	return op
+}
+
+// Operate sets dst to the operation on s, divided by i such that it is real. Both are 4 component slices on the GPU.
+func (op *SublatticeOperator) Operate(dst, s *data.Slice) {
+
+	f := cuda.Buffer(4, op.size)
+	defer cuda.Recycle(f)
+	cuda.Zero(f)
+	op.hamiltonian.AddTo(f, s)
+
+	if op.toLab != nil {
+		total := cuda.Buffer(3, op.size)
+		B := cuda.Buffer(3, op.size)
+		defer cuda.Recycle(total)
+		defer cuda.Recycle(B)
+		cuda.Zero(total)
+		cuda.Zero(B)
+		op.toLab.AddTo(total, s)
+		DemagInteraction{}.AddField(B, total)
+		op.fromLab.AddTo(f, B)
+	}
+
+	cuda.Zero(dst)
+	op.dynamic.AddTo(dst, f)
+}
+
+// Free frees the GPU memory held by the operator.
+func (op *SublatticeOperator) Free() {
+	for _, st := range []*stencil{op.hamiltonian, op.toLab, op.fromLab, op.dynamic} {
+		if st != nil {
+			st.Free()
+		}
+	}
+}
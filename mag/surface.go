@@ -0,0 +1,221 @@
+package mag
+
+import (
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// The faces of the magnet, used to select the cells of a BoundarySurface.
+const (
+	FACE_X_MIN = 0
+	FACE_X_MAX = 1
+	FACE_Y_MIN = 2
+	FACE_Y_MAX = 3
+	FACE_Z_MIN = 4
+	FACE_Z_MAX = 5
+)
+
+// A Surface is a set of cells at a surface of the magnet or at an interface between regions.
+type Surface struct {
+	cells []surfaceCell
+}
+
+type surfaceCell struct {
+	idx       int     // the index of the cell, by Tensor.Idx.
+	thickness float64 // the size of the cell normal to the surface.
+}
+
+// A SurfaceAnisotropy is a uniaxial anisotropy on the cells of a Surface, with energy per unit area -Ks (u.m)².
+// Ks is positive for an easy axis.
+type SurfaceAnisotropy struct {
+	Surface
+	Ks float64    // The surface anisotropy constant (J/m2).
+	U  [3]float64 // The anisotropy axis, which is normalised.
+}
+
+var (
+	// SurfaceAnisotropies are included in the self-interaction tensor and the self-interaction fields.
+	// mumax does not know about them, so to relax the ground state with them, add the field term SurfaceAnisotropyQuantity{} to mumax.
+	SurfaceAnisotropies []SurfaceAnisotropy
+
+	// PinnedSurfaces have their magnetisation fixed in the ground state direction (Dirichlet boundary conditions).
+	// Their rows and columns of the linear Hamiltonian and eigenproblem tensors, and of the linear evolution in package field, are zero,
+	// so they take no part in the modes, and RotatedToZ removes their degrees of freedom from the matrix it diagonalises.
+	PinnedSurfaces []Surface
+)
+
+// BoundarySurface returns the cells of the magnet at one of its faces (FACE_X_MIN, ...). These are the cells with non-zero
+// saturation magnetisation whose neighbour across that face is outside the mesh or has zero saturation magnetisation.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func BoundarySurface(face int) Surface {
+
+	size := en.MeshSize()
+	cellsize := en.Mesh().CellSize()
+	ms := HostSlice(en.Msat).Scalars()
+
+	axis := face / 2
+	step := 2*(face%2) - 1
+
+	var s Surface
+	for k := 0; k < size[2]; k++ {
+		for j := 0; j < size[1]; j++ {
+			for i := 0; i < size[0]; i++ {
+
+				if ms[k][j][i] == 0 {
+					continue
+				}
+
+				n := [3]int{i, j, k}
+				n[axis] += step
+				if n[axis] >= 0 && n[axis] < size[axis] && ms[n[2]][n[1]][n[0]] != 0 {
+					continue
+				}
+
+				s.cells = append(s.cells, surfaceCell{idx: size[0]*(size[1]*k+j) + i, thickness: cellsize[axis]})
+			}
+		}
+	}
+
+	return s
+}
+
+// InterfaceSurface returns the cells of region r1 which have a nearest neighbour in region r2.
+// Note that it does not have any inputs. Rather it uses the regions defined by the global variables.
+func InterfaceSurface(r1, r2 int) Surface {
+
+	size := en.MeshSize()
+	cellsize := en.Mesh().CellSize()
+	regions := RegionIndices()
+
+	var s Surface
+	for k := 0; k < size[2]; k++ {
+		for j := 0; j < size[1]; j++ {
+			for i := 0; i < size[0]; i++ {
+
+				if regions[k][j][i] != r1 {
+					continue
+				}
+
+			neighbours:
+				for axis := 0; axis < 3; axis++ {
+					for _, step := range [2]int{-1, 1} {
+						n := [3]int{i, j, k}
+						n[axis] += step
+						if n[axis] < 0 || n[axis] >= size[axis] || regions[n[2]][n[1]][n[0]] != r2 {
+							continue
+						}
+						s.cells = append(s.cells, surfaceCell{idx: size[0]*(size[1]*k+j) + i, thickness: cellsize[axis]})
+						break neighbours
+					}
+				}
+			}
+		}
+	}
+
+	return s
+}
+
+// SurfaceAnisotropyTensor returns the self-interaction tensor for the SurfaceAnisotropies.
+// Each is the uniaxial anisotropy tensor with Ku1 = Ks / thickness on the cells of its surface.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func SurfaceAnisotropyTensor() Tensor {
+
+	t := ZeroTensor(3, en.MeshSize())
+	n := t.To4D()
+
+	for _, sa := range SurfaceAnisotropies {
+		u := normalise(sa.U)
+		for _, cell := range sa.cells {
+			for c := 0; c < 3; c++ {
+				for c_ := 0; c_ < 3; c_++ {
+					n[c][c_][cell.idx][cell.idx] += -2 * sa.Ks / cell.thickness * u[c] * u[c_]
+				}
+			}
+		}
+	}
+
+	return t
+}
+
+// AddSurfaceAnisotropyField adds the field of the SurfaceAnisotropies for a magnetisation s to dst.
+// Both dst and s must live on the CPU.
+func AddSurfaceAnisotropyField(dst, s *data.Slice) {
+
+	if len(SurfaceAnisotropies) == 0 {
+		return
+	}
+
+	dstArr := dst.Host()
+	sArr := s.Host()
+	ms := HostSlice(en.Msat).Host()[0]
+
+	for _, sa := range SurfaceAnisotropies {
+		u := normalise(sa.U)
+		for _, cell := range sa.cells {
+			if ms[cell.idx] == 0 {
+				continue
+			}
+			us := 0.
+			for c := 0; c < 3; c++ {
+				us += u[c] * float64(sArr[c][cell.idx])
+			}
+			for c := 0; c < 3; c++ {
+				dstArr[c][cell.idx] += float32(2 * sa.Ks / cell.thickness * us * u[c] / float64(ms[cell.idx]))
+			}
+		}
+	}
+}
+
+// PinnedCells returns whether each cell, by Tensor.Idx, is in one of the PinnedSurfaces.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func PinnedCells() []bool {
+
+	size := en.MeshSize()
+	pinned := make([]bool, size[0]*size[1]*size[2])
+	for _, s := range PinnedSurfaces {
+		for _, cell := range s.cells {
+			pinned[cell.idx] = true
+		}
+	}
+	return pinned
+}
+
+// pinTensor sets the rows and columns of t belonging to the cells of the PinnedSurfaces to zero.
+func pinTensor(t Tensor) {
+
+	if len(PinnedSurfaces) == 0 {
+		return
+	}
+
+	n := t.To4D()
+	for idx, pinned := range PinnedCells() {
+		if !pinned {
+			continue
+		}
+		for c := 0; c < t.NComp; c++ {
+			for c_ := 0; c_ < t.NComp; c_++ {
+				row := n[c][c_][idx]
+				for idx_ := range row {
+					row[idx_] = 0
+					n[c][c_][idx_][idx] = 0
+				}
+			}
+		}
+	}
+}
+
+// SurfaceAnisotropyQuantity is a mumax quantity evaluating to the field of the SurfaceAnisotropies for the magnetisation en.M.
+// Adding it to the mumax effective field with en.AddFieldTerm(SurfaceAnisotropyQuantity{}) includes them when relaxing the ground state.
+type SurfaceAnisotropyQuantity struct{}
+
+func (SurfaceAnisotropyQuantity) NComp() int {
+	return 3
+}
+
+func (SurfaceAnisotropyQuantity) EvalTo(dst *data.Slice) {
+	B := data.NewSlice(3, dst.Size())
+	AddSurfaceAnisotropyField(B, en.M.Buffer().HostCopy())
+	data.Copy(dst, B)
+}
@@ -0,0 +1,330 @@
+package mag
+
+import (
+	"fmt"
+
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+var (
+	GAMMA_LL       = gamma0 // the gyromagnetic ratio of a new System in rad/Ts, the default of mumax.
+	DEMAG_ACCURACY = 6.     // the accuracy of the demagnetising kernel of a new System, the default of mumax.
+)
+
+// A System describes a magnet and its ground state independently of the mumax engine, so that several systems can be analysed in one process,
+// and the tensors built without running a mumax script. It is built from the current state of the engine by EngineSystem,
+// or in Go by NewSystem. All the slices live on the CPU, and have the size of the mesh.
+// The interactions of a System are the demagnetising, exchange, uniaxial anisotropy and Zeeman interactions.
+// It does not model the other interactions which may be registered with Register, the spin-transfer torques, pinning,
+// or a second sublattice, which is described by a Sublattice instead.
+type System struct {
+	Size     [3]int
+	PBC      [3]int
+	CellSize [3]float64
+
+	Msat  *data.Slice // the saturation magnetisation in A/m, with 1 component.
+	Alpha *data.Slice // the Gilbert damping, with 1 component, which is used if Damping is set.
+	Ku1   *data.Slice // the first order uniaxial anisotropy constant in J/m³, with 1 component.
+	AnisU *data.Slice // the uniaxial anisotropy axis, with 3 components.
+	BExt  *data.Slice // the external field in T, with 3 components.
+	M     *data.Slice // the ground state magnetisation, with 3 components of unit length.
+
+	// Exchange holds the exchange stiffness in J/m between each cell and its neighbour in the +x, +y and +z directions, as its 3 components.
+	// The components for the last cell along each direction couple it to the first, and are used only if the direction is periodic.
+	// It is set from the stiffness of each cell by SetAex.
+	Exchange *data.Slice
+
+	GammaLL       float64
+	EnableDemag   bool
+	DemagAccuracy float64
+	Damping       bool // whether the Gilbert damping is included in the dynamics, as the package variable Damping does for the engine.
+}
+
+// NewSystem returns a System with the given mesh, without periodic boundaries, in which the parameters are zero and the
+// ground state is along z. The parameters are then set by filling the slices, for which Uniform may be used.
+func NewSystem(size [3]int, cellsize [3]float64) *System {
+	return &System{
+		Size:          size,
+		CellSize:      cellsize,
+		Msat:          data.NewSlice(1, size),
+		Alpha:         data.NewSlice(1, size),
+		Ku1:           data.NewSlice(1, size),
+		AnisU:         data.NewSlice(3, size),
+		BExt:          data.NewSlice(3, size),
+		M:             Uniform(size, 0, 0, 1),
+		Exchange:      data.NewSlice(3, size),
+		GammaLL:       GAMMA_LL,
+		EnableDemag:   true,
+		DemagAccuracy: DEMAG_ACCURACY,
+	}
+}
+
+// EngineSystem returns the System set up in mumax, with the ground state stored in en.M.
+// The exchange stiffness between cells is that used by mumax, including the scaling between regions and across periodic boundaries.
+// The demagnetising, exchange, anisotropy and Zeeman terms are included only while their interactions are registered.
+// It returns an *OptionError if mumax is set up with terms which a System does not model: InterlayerCouplings, SurfaceAnisotropies,
+// PinnedSurfaces, SpinTransfer, a magnetoelastic coupling, or an Interaction registered other than those of this package.
+func EngineSystem() (s *System, err error) {
+
+	defer func() { Catch(recover(), &err) }()
+
+	if err := engineSystemModelled(); err != nil {
+		return nil, err
+	}
+	s = engineSystem()
+
+	names, _ := Interactions()
+	registered := make(map[string]bool)
+	for _, name := range names {
+		registered[name] = true
+	}
+	if !registered["demag"] {
+		s.EnableDemag = false
+	}
+	if !registered["exchange"] {
+		s.Exchange = data.NewSlice(3, s.Size)
+	}
+	if !registered["anisotropy"] {
+		s.Ku1 = data.NewSlice(1, s.Size)
+	}
+	if !registered["zeeman"] {
+		s.BExt = data.NewSlice(3, s.Size)
+	}
+	return s, nil
+}
+
+// engineSystemModelled returns an *OptionError for the first term set up in mumax which a System does not model.
+func engineSystemModelled() error {
+	if unmodelled := SystemUnmodelled(); len(unmodelled) > 0 {
+		return &OptionError{Option: unmodelled[0].Name, Reason: "it is not modelled by a System"}
+	}
+	return nil
+}
+
+// SystemUnmodelled returns the terms set up in mumax which a System does not model, and which the solvers built on the same
+// demagnetising, exchange, uniaxial anisotropy and Zeeman terms, such as the sublattice eigenproblem, would silently drop:
+// InterlayerCouplings, SurfaceAnisotropies, PinnedSurfaces, SpinTransfer, a magnetoelastic coupling,
+// and any Interaction registered other than those of this package.
+func SystemUnmodelled() UnsupportedFeatures {
+
+	var features UnsupportedFeatures
+	if len(InterlayerCouplings) > 0 {
+		features = append(features, UnsupportedFeature{Name: "InterlayerCouplings", Description: "interlayer coupling"})
+	}
+	if len(SurfaceAnisotropies) > 0 {
+		features = append(features, UnsupportedFeature{Name: "SurfaceAnisotropies", Description: "surface anisotropy"})
+	}
+	if len(PinnedSurfaces) > 0 {
+		features = append(features, UnsupportedFeature{Name: "PinnedSurfaces", Description: "pinning"})
+	}
+	if SpinTransfer {
+		features = append(features, UnsupportedFeature{Name: "SpinTransfer", Description: "spin-transfer torque"})
+	}
+
+	names, is := Interactions()
+	for r, i := range is {
+		switch i.(type) {
+		case DemagInteraction, ExchangeInteraction, AnisotropyInteraction, ZeemanInteraction,
+			InterlayerInteraction, SurfaceAnisotropyInteraction:
+		case MagnetoelasticInteraction:
+			regions := RegionIndices()
+			for _, p := range []parameterCheck{{"B1", en.B1, ""}, {"B2", en.B2, ""}} {
+				if set := regionsSet(p.param, regions); len(set) > 0 {
+					features = append(features, UnsupportedFeature{Name: p.name, Description: "magnetoelastic coupling", Regions: set})
+				}
+			}
+		default:
+			features = append(features, UnsupportedFeature{Name: fmt.Sprintf("the interaction %q", names[r]), Description: "a registered interaction"})
+		}
+	}
+	return features
+}
+
+// engineSystem returns the System set up in mumax, with all of its terms, as used for the tensors of each interaction.
+func engineSystem() *System {
+
+	mesh := en.Mesh()
+	size := mesh.Size()
+
+	s := &System{
+		Size:          size,
+		PBC:           mesh.PBC(),
+		CellSize:      mesh.CellSize(),
+		Msat:          HostSlice(en.Msat),
+		Alpha:         HostSlice(en.Alpha),
+		Ku1:           HostSlice(en.Ku1),
+		AnisU:         HostSlice(en.AnisU),
+		BExt:          HostSlice(en.B_ext),
+		M:             en.M.Buffer().HostCopy(),
+		Exchange:      EngineExchange(),
+		GammaLL:       en.GammaLL,
+		EnableDemag:   en.EnableDemag,
+		DemagAccuracy: en.DemagAccuracy,
+		Damping:       Damping,
+	}
+	return s
+}
+
+// EngineExchange returns the exchange stiffness between neighbouring cells set up in mumax, as held by System.Exchange.
+func EngineExchange() *data.Slice {
+
+	mesh := en.Mesh()
+	s := &System{Size: mesh.Size(), PBC: mesh.PBC()}
+	exchange := data.NewSlice(3, s.Size)
+
+	links := exchange.Vectors()
+	s.eachLink(func(d int, r, r_ [3]int) {
+		links[d][r[2]][r[1]][r[0]] = en.ExchangeAtCell(r[0], r[1], r[2], r_[0], r_[1], r_[2])
+	})
+	return exchange
+}
+
+// eachLink calls f with each cell r and its neighbour r_ in the +d direction, including the first cell as the neighbour
+// of the last if the direction is periodic.
+func (s *System) eachLink(f func(d int, r, r_ [3]int)) {
+	for k := 0; k < s.Size[2]; k++ {
+		for j := 0; j < s.Size[1]; j++ {
+			for i := 0; i < s.Size[0]; i++ {
+				for d := 0; d < 3; d++ {
+					r := [3]int{i, j, k}
+					r_ := r
+					r_[d]++
+					if r_[d] == s.Size[d] {
+						if s.PBC[d] == 0 {
+							continue
+						}
+						r_[d] = 0
+					}
+					f(d, r, r_)
+				}
+			}
+		}
+	}
+}
+
+// Uniform returns a slice on the CPU of the given size, with a component for each value, which are the same at each position.
+func Uniform(size [3]int, values ...float32) *data.Slice {
+	s := data.NewSlice(len(values), size)
+	for c, v := range values {
+		comp := s.Host()[c]
+		for n := range comp {
+			comp[n] = v
+		}
+	}
+	return s
+}
+
+// SetAex sets the exchange stiffness between neighbouring cells from the stiffness aex of each cell, a slice with 1 component on the CPU.
+// As in mumax, it is the harmonic mean of the stiffnesses of the two cells, and zero if either is zero.
+// The cells across a periodic boundary are neighbours, so PBC should be set first.
+func (s *System) SetAex(aex *data.Slice) {
+
+	a := aex.Scalars()
+	links := s.Exchange.Vectors()
+
+	harmonic := func(a1, a2 float32) float32 {
+		if a1 == 0 || a2 == 0 {
+			return 0
+		}
+		return 2 * a1 * a2 / (a1 + a2)
+	}
+
+	s.eachLink(func(d int, r, r_ [3]int) {
+		links[d][r[2]][r[1]][r[0]] = harmonic(a[r[2]][r[1]][r[0]], a[r_[2]][r_[1]][r_[0]])
+	})
+}
+
+// Check returns a *SizeError if one of the slices of the System does not have the size of the mesh or the right number of components.
+func (s *System) Check() error {
+
+	slices := []struct {
+		name  string
+		slice *data.Slice
+		nComp int
+	}{
+		{"Msat", s.Msat, 1},
+		{"Alpha", s.Alpha, 1},
+		{"Ku1", s.Ku1, 1},
+		{"AnisU", s.AnisU, 3},
+		{"BExt", s.BExt, 3},
+		{"M", s.M, 3},
+		{"Exchange", s.Exchange, 3},
+	}
+	for _, sl := range slices {
+		if sl.slice == nil {
+			return &SizeError{Op: "System." + sl.name, WantNComp: sl.nComp, WantSize: s.Size}
+		}
+		if sl.slice.NComp() != sl.nComp || sl.slice.Size() != s.Size {
+			return &SizeError{Op: "System." + sl.name, NComp: sl.slice.NComp(), WantNComp: sl.nComp, Size: sl.slice.Size(), WantSize: s.Size}
+		}
+	}
+	return nil
+}
+
+// check panics with the error of Check, for the tensor builders.
+func (s *System) check() {
+	if err := s.Check(); err != nil {
+		panic(err)
+	}
+}
+
+// SelfInteractionTensor returns the self-interaction tensor of the System, with contributions from the demagnetising interaction
+// if EnableDemag is set, the exchange interaction and the uniaxial anisotropy.
+func (s *System) SelfInteractionTensor() Tensor {
+	tensors := []Tensor{s.ExchangeTensor(), s.UniAnisTensor()}
+	if s.EnableDemag {
+		tensors = append(tensors, s.DemagTensor())
+	}
+	return AddTensors(tensors...)
+}
+
+// LinearHamiltonianTensor returns the tensor representation of the linear Hamiltonian of the System.
+// The ground state field is found from the self-interaction tensor and the external field.
+func (s *System) LinearHamiltonianTensor() Tensor {
+
+	ret := s.SelfInteractionTensor()
+
+	// the ground state field B0 = -(1/Ms) Σ_r' t_rr' m_r' + B_ext.
+	tm, err := ret.TSP(s.M)
+	if err != nil {
+		panic(err)
+	}
+	h := tm.Vectors()
+	ms := s.Msat.Scalars()
+	bExt := s.BExt.Vectors()
+
+	B0 := data.NewSlice(3, s.Size)
+	b := B0.Vectors()
+	for c := 0; c < 3; c++ {
+		for k := 0; k < s.Size[2]; k++ {
+			for j := 0; j < s.Size[1]; j++ {
+				for i := 0; i < s.Size[0]; i++ {
+					b[c][k][j][i] = bExt[c][k][j][i]
+					if ms[k][j][i] != 0 {
+						b[c][k][j][i] -= h[c][k][j][i] / ms[k][j][i]
+					}
+				}
+			}
+		}
+	}
+
+	addGroundStateTerm(ret, s.M.Vectors(), b, ms)
+	return ret
+}
+
+// EigenProblemTensor returns the tensor representation of the matrix (divided by i, so real)
+// which is diagonalised to find the eigenfrequencies and eigenmodes of the System.
+func (s *System) EigenProblemTensor() Tensor {
+	return s.DynamicOperate(s.LinearHamiltonianTensor())
+}
+
+// damping returns the damping parameter at each position (order z, y, x) if Damping is set, and zero otherwise.
+func (s *System) damping() [][][]float32 {
+	if !s.Damping {
+		return data.NewSlice(1, s.Size).Scalars()
+	}
+	return s.Alpha.Scalars()
+}
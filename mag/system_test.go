@@ -0,0 +1,108 @@
+package mag
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestSystem checks that a System built in Go gives the same eigenproblem tensor as the same system set up in mumax,
+// both through EngineSystem and through the registered interactions, and that it does not change when mumax does.
+// The mesh is periodic along x, so the exchange across the boundary is checked too.
+// EngineSystem must refuse the spin-transfer torques, which a System does not model.
+func TestSystem(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Setup(`
+		SetGridSize(3, 2, 2)
+		SetCellSize(2e-9, 3e-9, 2e-9)
+		SetPBC(1, 0, 0)
+		Msat = 8e5
+		Aex = 1.3e-11
+		Ku1 = 5e4
+		AnisU = vector(0, 0, 1)
+		B_ext = vector(0, 0, 0.1)
+	`)
+	en.M.Set(en.Uniform(0, 0, 1))
+
+	size := [3]int{3, 2, 2}
+	sys := NewSystem(size, [3]float64{2e-9, 3e-9, 2e-9})
+	sys.PBC = [3]int{1, 0, 0}
+	sys.Msat = Uniform(size, 8e5)
+	sys.SetAex(Uniform(size, 1.3e-11))
+	sys.Ku1 = Uniform(size, 5e4)
+	sys.AnisU = Uniform(size, 0, 0, 1)
+	sys.BExt = Uniform(size, 0, 0, 0.1)
+
+	rng := rand.New(rand.NewSource(0))
+	rnd := tests.RandomSlice(3, size, rng)
+
+	engineSys, err := EngineSystem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, errB := sys.EigenProblemTensor().TSP(rnd)
+	engine, errE := engineSys.EigenProblemTensor().TSP(rnd)
+	registered, errR := EigenProblemTensor().TSP(rnd)
+	if errB != nil || errE != nil || errR != nil {
+		t.Fatalf("%v %v %v", errB, errE, errR)
+	}
+	if err := tests.EqualSlices(built, engine, 1e-3); err > 0 {
+		t.Errorf("the System built in Go differs from EngineSystem: %d%% error", 100*err/(3*rnd.Len()))
+	}
+	if err := tests.EqualSlices(built, registered, 1e-3); err > 0 {
+		t.Errorf("the System built in Go differs from the registered interactions: %d%% error", 100*err/(3*rnd.Len()))
+	}
+
+	en.Msat.Set(4e5)
+	after, err := sys.EigenProblemTensor().TSP(rnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tests.EqualSlices(built, after, 1e-6); err > 0 {
+		t.Errorf("the System changed with mumax: %d%% error", 100*err/(3*rnd.Len()))
+	}
+
+	sys.Ku1 = Uniform([3]int{2, 2, 2}, 5e4)
+	var sizeErr *SizeError
+	if err := sys.Check(); !errors.As(err, &sizeErr) {
+		t.Errorf("Check of a System with a slice of the wrong size returned %v", err)
+	}
+
+	SpinTransfer = true
+	_, err = EngineSystem()
+	SpinTransfer = false
+	var optionErr *OptionError
+	if !errors.As(err, &optionErr) {
+		t.Errorf("EngineSystem with SpinTransfer returned %v", err)
+	}
+}
+
+// TestDynamicOperateRotated checks that the dynamics of a rotated tensor with only the transverse components, as returned by Tensor.XY,
+// are found for both its columns.
+func TestDynamicOperateRotated(t *testing.T) {
+
+	size := [3]int{1, 1, 1}
+	sys := NewSystem(size, [3]float64{1e-9, 1e-9, 1e-9})
+	sys.Msat = Uniform(size, 1)
+
+	h := ZeroTensor(2, size)
+	h.SetIdx(0, 0, 0, 0, 0, 0, 0, 0, 1)
+	h.SetIdx(1, 1, 0, 0, 0, 0, 0, 0, 2)
+
+	d := sys.DynamicOperateRotated(h)
+	want := [2][2]float64{{0, -2 * sys.GammaLL}, {sys.GammaLL, 0}}
+	for c := 0; c < 2; c++ {
+		for c_ := 0; c_ < 2; c_++ {
+			if got := d.GetIdx(c, c_, 0, 0, 0, 0, 0, 0); got != want[c][c_] {
+				t.Errorf("element (%d, %d) is %g; want %g", c, c_, got, want[c][c_])
+			}
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package mag
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/mag"
+)
+
+// A SystemOperator applies the linearised dynamics of a System on the GPU without building its tensor,
+// so the modes of large systems can be found by iterative solvers. It acts in the frames of a RotationToZ of the ground state of the System,
+// on 2 component slices holding the deviations transverse to it, as the tensor returned by Tensor.XY of the rotated eigenproblem tensor does.
+// It does not use the mumax engine, except for the GPU, so several can exist at once.
+type SystemOperator struct {
+	size [3]int
+
+	// the exchange and uniaxial anisotropy, with each row divided by the saturation magnetisation, including the ground state terms.
+	hamiltonian *stencil
+
+	// when EnableDemag is set, these take the deviations to the lab frame, and the demagnetising field of the System
+	// to minus its transverse components. Otherwise they are nil.
+	toLab, fromLab *stencil
+	demag          *cuda.DemagConvolution
+	msat           *data.Slice
+
+	// the precession, with the damping if Damping is set.
+	dynamic *stencil
+}
+
+// NewSystemOperator returns the operator of the System s, in the frames of rot, which should be initialised from s.M.
+// It panics with a *SizeError if the slices of s do not match its mesh (see System.Check). The GPU memory it holds is released by Free.
+func NewSystemOperator(s *System, rot *RotationToZ) *SystemOperator {
+
+	s.check()
+	size := s.Size
+	length := size[0] * size[1] * size[2]
+	Nx := size[0]
+	Ny := size[1]
+
+	frame := func(idx int) [3][3]float64 {
+		return rot.R[idx/(Nx*Ny)][(idx/Nx)%Ny][idx%Nx]
+	}
+
+	ms := s.Msat.Host()[0]
+	m := s.M.Host()
+
+	op := &SystemOperator{
+		size:        size,
+		hamiltonian: newStencil(2, 2, size),
+		dynamic:     newStencil(2, 2, size),
+	}
+
+	// the local terms in the transverse frame, and their contribution to the ground state field, Σ_r' H_rr' m_r'.
+	var h0 [3][]float64
+	for c := range h0 {
+		h0[c] = make([]float64, length)
+	}
+	local := func(c, c_, idx, idx_ int, v float64) {
+		h0[c][idx] += v * float64(m[c_][idx_])
+		if ms[idx] == 0 {
+			return
+		}
+		R := frame(idx)
+		R_ := frame(idx_)
+		for p := 0; p < 2; p++ {
+			for q := 0; q < 2; q++ {
+				op.hamiltonian.add(p, q, idx, idx_, R[p][c]*v*R_[q][c_]/float64(ms[idx]))
+			}
+		}
+	}
+	s.exchangeElements(local)
+	s.uniAnisElements(local)
+
+	var demag [][]float32
+	if s.EnableDemag {
+		kernel := mag.DemagKernel(size, s.PBC, s.CellSize, s.DemagAccuracy, *en.Flag_cachedir)
+		op.demag = cuda.NewDemag(size, s.PBC, kernel, false)
+		op.msat = cuda.NewSlice(1, size)
+		data.Copy(op.msat, s.Msat)
+
+		op.toLab = newStencil(3, 2, size)
+		op.fromLab = newStencil(2, 3, size)
+		for idx := 0; idx < length; idx++ {
+			if ms[idx] == 0 {
+				continue
+			}
+			R := frame(idx)
+			for c := 0; c < 3; c++ {
+				for p := 0; p < 2; p++ {
+					op.toLab.add(c, p, idx, idx, R[p][c])
+					op.fromLab.add(p, c, idx, idx, -R[p][c])
+				}
+			}
+		}
+
+		mGPU := cuda.NewSlice(3, size)
+		B := cuda.NewSlice(3, size)
+		data.Copy(mGPU, s.M)
+		op.demag.Exec(B, mGPU, nil, cuda.ToMSlice(op.msat))
+		demag = B.HostCopy().Host()
+		mGPU.Free()
+		B.Free()
+	}
+
+	// the ground state terms, (B0.m) on the diagonal, with B0 = B_ext + B_demag - (1/Ms) Σ_r' H_rr' m_r'.
+	B_ext := s.BExt.Host()
+	for idx := 0; idx < length; idx++ {
+		if ms[idx] == 0 {
+			continue
+		}
+		gsTerm := 0.
+		for c := 0; c < 3; c++ {
+			b := float64(B_ext[c][idx]) - h0[c][idx]/float64(ms[idx])
+			if demag != nil {
+				b += float64(demag[c][idx])
+			}
+			gsTerm += b * float64(m[c][idx])
+		}
+		for p := 0; p < 2; p++ {
+			op.hamiltonian.add(p, p, idx, idx, gsTerm)
+		}
+	}
+
+	// in its own frame the ground state is z, so the precession (γ / (1 + α²)) (m x + α m x m x) is this block, as in DynamicOperateRotated.
+	alpha := s.damping()
+	for idx := 0; idx < length; idx++ {
+		if ms[idx] == 0 {
+			continue
+		}
+		α := float64(alpha[idx/(Nx*Ny)][(idx/Nx)%Ny][idx%Nx])
+		f := s.GammaLL / (1 + α*α)
+		op.dynamic.add(0, 0, idx, idx, -f*α)
+		op.dynamic.add(0, 1, idx, idx, -f)
+		op.dynamic.add(1, 0, idx, idx, f)
+		op.dynamic.add(1, 1, idx, idx, -f*α)
+	}
+
+	return op
+}
+
+// Operate sets dst to the operation on s, divided by i such that it is real. Both are 2 component slices on the GPU.
+func (op *SystemOperator) Operate(dst, s *data.Slice) {
+
+	f := cuda.Buffer(2, op.size)
+	defer cuda.Recycle(f)
+	cuda.Zero(f)
+	op.hamiltonian.AddTo(f, s)
+
+	if op.demag != nil {
+		lab := cuda.Buffer(3, op.size)
+		B := cuda.Buffer(3, op.size)
+		defer cuda.Recycle(lab)
+		defer cuda.Recycle(B)
+		cuda.Zero(lab)
+		op.toLab.AddTo(lab, s)
+		op.demag.Exec(B, lab, nil, cuda.ToMSlice(op.msat))
+		op.fromLab.AddTo(f, B)
+	}
+
+	cuda.Zero(dst)
+	op.dynamic.AddTo(dst, f)
+}
+
+// Free frees the GPU memory held by the operator.
+func (op *SystemOperator) Free() {
+	for _, st := range []*stencil{op.hamiltonian, op.toLab, op.fromLab, op.dynamic} {
+		if st != nil {
+			st.Free()
+		}
+	}
+	if op.demag != nil {
+		op.demag.Free()
+		op.msat.Free()
+	}
+}
@@ -1,5 +1,7 @@
-// Package mag calculates self-interaction tensors corresponding to exchange, demagnetising and uniaxial anisotropy interactions.
+// Package mag calculates self-interaction tensors corresponding to exchange, demagnetising and uniaxial anisotropy interactions,
+// and to any other registered Interaction.
 // Additionally it calculates the linear Hamiltonian tensor that results from these.
+// The functions use the system set up in mumax, while the methods of System build the same tensors for a system described in Go.
 package mag
 
 import (
@@ -9,98 +11,104 @@ import (
 	. "github.com/will-henderson/mumax-vhf/data"
 )
 
-// SelfInteractionTensor returns the self-interaction tensor with contibutions from the Demagnetising, Exchange, and Uniaxial Anisotropy interactions.
+// SelfInteractionTensor returns the self-interaction tensor with contibutions from all the registered interactions (see Register).
+// By default these are the Demagnetising, Exchange, and Uniaxial Anisotropy interactions, any SurfaceAnisotropies and InterlayerCouplings,
+// and the magnetoelastic interaction with the static strain set in mumax.
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 func SelfInteractionTensor() Tensor {
-	return AddTensors(DemagTensor(), ExchangeTensor(), UniAnisTensor())
+
+	var tensors []Tensor
+	for _, ri := range interactions {
+		if t := ri.interaction.Tensor(); t.NComp != 0 {
+			tensors = append(tensors, t)
+		}
+	}
+
+	if len(tensors) == 0 {
+		return ZeroTensor(3, en.MeshSize())
+	}
+	return AddTensors(tensors...)
 }
 
 // LinearHamiltonianTensor returns the tensor representation of the linear Hamiltonian of the system.
+// The cells of the PinnedSurfaces have no degrees of freedom, so their rows and columns are zero.
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 func LinearHamiltonianTensor() Tensor {
 
-	systemTensor := SelfInteractionTensor()
+	ret := SelfInteractionTensor()
 
-	Nx := systemTensor.Size[0]
-	Ny := systemTensor.Size[1]
-	Nz := systemTensor.Size[2]
+	m := en.M.Buffer().HostCopy().Vectors() //order is Z, Y, X
+	ms := HostSlice(en.Msat).Scalars()
 
-	mSl := en.M.Buffer().HostCopy()
-	m := mSl.Vectors() //order is Z, Y, X
+	// the ground state field of all the interactions. For those quadratic in m this is -(1/Ms) Σ_r' t_rr' m_r',
+	// but it is evaluated by each interaction so that the others are included correctly.
+	B0GPU := cuda.NewSlice(3, en.MeshSize())
+	cuda.Zero(B0GPU)
+	AddGroundStateField(B0GPU)
+	B0 := B0GPU.HostCopy().Vectors()
+	B0GPU.Free()
 
-	B_extGPU, rM := en.B_ext.Slice()
-	B_extSl := B_extGPU.HostCopy()
-	if rM {
-		cuda.Recycle(B_extGPU)
-	}
-
-	msatGPU, rM := en.Msat.Slice()
-	msat := msatGPU.HostCopy()
-	if rM {
-		cuda.Recycle(msatGPU)
-	}
-	ms := msat.Scalars()
+	addGroundStateTerm(ret, m, B0, ms)
+	pinTensor(ret)
+	return ret
+}
 
-	B_ext := B_extSl.Vectors()
+// addGroundStateTerm adds the term (B0 . m) Ms of the ground state field B0 to the diagonal of the self-interaction tensor t.
+func addGroundStateTerm(t Tensor, m, B0 [3][][][]float32, ms [][][]float32) {
 
-	ret := systemTensor.Copy()
+	Nx := t.Size[0]
+	Ny := t.Size[1]
+	Nz := t.Size[2]
 
 	for i := 0; i < Nx; i++ {
 		for j := 0; j < Ny; j++ {
 			for k := 0; k < Nz; k++ {
 
-				zeeTerm := 0.
-				for c := 0; c < 3; c++ {
-					zeeTerm += float64(B_ext[c][k][j][i] * m[c][k][j][i])
-				}
-
 				gsTerm := 0.
-				for i_ := 0; i_ < Nx; i_++ {
-					for j_ := 0; j_ < Ny; j_++ {
-						for k_ := 0; k_ < Nz; k_++ {
-							for c := 0; c < 3; c++ {
-								for c_ := 0; c_ < 3; c_++ {
-									gsTerm += float64(m[c][k][j][i]*m[c_][k_][j_][i_]) * systemTensor.GetIdx(c, c_, i, j, k, i_, j_, k_)
-								}
-							}
-
-						}
-					}
+				for c := 0; c < 3; c++ {
+					gsTerm += float64(B0[c][k][j][i] * m[c][k][j][i])
 				}
 
 				for c := 0; c < 3; c++ {
-					ret.AddIdx(c, c, i, j, k, i, j, k, zeeTerm*float64(ms[k][j][i])-gsTerm)
+					t.AddIdx(c, c, i, j, k, i, j, k, gsTerm*float64(ms[k][j][i]))
 				}
 
 			}
 		}
 	}
-	return ret
 }
 
 // EigenProblemTensor returns the tensor representation of the matrix (divided by i, so real)
 // which is diagonalised to find the eigenfrequencies and eigenmodes of the system.
+// As in LinearHamiltonianTensor, the rows and columns of the cells of the PinnedSurfaces are zero.
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 func EigenProblemTensor() Tensor {
 
 	lht := LinearHamiltonianTensor()
 	ept := DynamicOperate(lht)
+	if SpinTransfer {
+		ept = AddTensors(ept, NewLinearSTT().Tensor())
+		pinTensor(ept)
+	}
 	return ept
 
 }
 
+// DynamicOperate returns the tensor (γ/Ms) m x t, which takes a linear Hamiltonian tensor t to the linearised dynamics.
+// If Damping is set, the Gilbert term is included and it returns (γ/Ms) (m x t + α m x (m x t)) / (1 + α²).
 func DynamicOperate(t Tensor) Tensor {
+	return engineSystem().DynamicOperate(t)
+}
 
-	m := en.M.Buffer().HostCopy().Vectors()
+// DynamicOperate returns the tensor which takes a linear Hamiltonian tensor t of the System to the linearised dynamics, as DynamicOperate does.
+func (s *System) DynamicOperate(t Tensor) Tensor {
 
-	msatGPU, rM := en.Msat.Slice()
-	msat := msatGPU.HostCopy()
-	if rM {
-		cuda.Recycle(msatGPU)
-	}
-	ms := msat.Scalars()
+	s.check()
+	m := s.M.Vectors()
+	ms := s.Msat.Scalars()
+	alpha := s.damping()
 
-	γ := en.GammaLL
+	γ := s.GammaLL
 
 	result := ZeroTensor(t.NComp, t.Size)
 
@@ -118,6 +126,20 @@ func DynamicOperate(t Tensor) Tensor {
 					{-my, mx, 0},
 				}
 
+				// the dynamic matrix, including the dynamic factor.
+				α := float64(alpha[k][j][i])
+				factor := γ / (float64(ms[k][j][i]) * (1 + α*α))
+				var D [3][3]float64
+				for p := 0; p < 3; p++ {
+					for q := 0; q < 3; q++ {
+						D[p][q] = m_cross[p][q]
+						for r := 0; r < 3; r++ {
+							D[p][q] += α * m_cross[p][r] * m_cross[r][q]
+						}
+						D[p][q] *= factor
+					}
+				}
+
 				for k_ := 0; k_ < t.Size[2]; k_++ {
 					for j_ := 0; j_ < t.Size[1]; j_++ {
 						for i_ := 0; i_ < t.Size[0]; i_++ {
@@ -127,13 +149,8 @@ func DynamicOperate(t Tensor) Tensor {
 							for p := 0; p < 3; p++ {
 								for q := 0; q < 3; q++ {
 									for r := 0; r < 3; r++ {
-										result.AddIdx(p, q, i, j, k, i_, j_, k_, m_cross[p][r]*t.GetIdx(r, q, i, j, k, i_, j_, k_))
+										result.AddIdx(p, q, i, j, k, i_, j_, k_, D[p][r]*t.GetIdx(r, q, i, j, k, i_, j_, k_))
 									}
-
-									// multiply by the dynamic factor.
-									result.SetIdx(p, q, i, j, k, i_, j_, k_,
-										result.GetIdx(p, q, i, j, k, i_, j_, k_)*γ/float64(ms[k][j][i]))
-
 								}
 							}
 
@@ -149,28 +166,37 @@ func DynamicOperate(t Tensor) Tensor {
 
 }
 
+// DynamicOperateRotated is the equivalent of DynamicOperate for a tensor which has been rotated such that m = z at each position.
 func DynamicOperateRotated(t Tensor) Tensor {
+	return engineSystem().DynamicOperateRotated(t)
+}
 
-	msatGPU, rM := en.Msat.Slice()
-	msat := msatGPU.HostCopy()
-	if rM {
-		cuda.Recycle(msatGPU)
-	}
-	ms := msat.Scalars()
+// DynamicOperateRotated is the equivalent of DynamicOperate for a tensor of the System which has been rotated such that m = z at each position.
+func (s *System) DynamicOperateRotated(t Tensor) Tensor {
+
+	s.check()
+	ms := s.Msat.Scalars()
+	alpha := s.damping()
 
-	γ := en.GammaLL
+	γ := s.GammaLL
 
 	result := ZeroTensor(t.NComp, t.Size)
 
 	for k := 0; k < t.Size[2]; k++ {
 		for j := 0; j < t.Size[1]; j++ {
 			for i := 0; i < t.Size[0]; i++ {
+
+				α := float64(alpha[k][j][i])
+				factor := γ / (float64(ms[k][j][i]) * (1 + α*α))
+
 				for k_ := 0; k_ < t.Size[2]; k_++ {
 					for j_ := 0; j_ < t.Size[1]; j_++ {
 						for i_ := 0; i_ < t.Size[0]; i_++ {
-							for q := 0; q < 3; q++ {
-								result.SetIdx(0, q, i, j, k, i_, j_, k_, -t.GetIdx(1, q, i, j, k, i_, j_, k_)*γ/float64(ms[k][j][i]))
-								result.SetIdx(1, q, i, j, k, i_, j_, k_, t.GetIdx(0, q, i, j, k, i_, j_, k_)*γ/float64(ms[k][j][i]))
+							for q := 0; q < t.NComp; q++ {
+								t0 := t.GetIdx(0, q, i, j, k, i_, j_, k_)
+								t1 := t.GetIdx(1, q, i, j, k, i_, j_, k_)
+								result.SetIdx(0, q, i, j, k, i_, j_, k_, (-t1-α*t0)*factor)
+								result.SetIdx(1, q, i, j, k, i_, j_, k_, (t0-α*t1)*factor)
 							}
 						}
 					}
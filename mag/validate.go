@@ -0,0 +1,167 @@
+package mag
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/mumax/3/cuda"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// An UnsupportedFeature is physics set up in mumax which the eigenproblem does not include, and so silently ignores.
+type UnsupportedFeature struct {
+	Name        string // The mumax parameter or feature, e.g. "Dind".
+	Description string // What the eigenproblem ignores.
+	Regions     []int  // The regions in which a parameter is set. Empty for features which are not parameters.
+}
+
+func (f UnsupportedFeature) String() string {
+	if len(f.Regions) == 0 {
+		return fmt.Sprintf("%s: %s", f.Name, f.Description)
+	}
+	return fmt.Sprintf("%s: %s (in regions %v)", f.Name, f.Description, f.Regions)
+}
+
+// UnsupportedFeatures is a list of UnsupportedFeature. It satisfies the error interface, so it can be returned when strict validation fails.
+type UnsupportedFeatures []UnsupportedFeature
+
+func (fs UnsupportedFeatures) Error() string {
+	lines := make([]string, len(fs))
+	for i, f := range fs {
+		lines[i] = f.String()
+	}
+	return "unsupported physics: " + strings.Join(lines, "; ")
+}
+
+// Validate returns the features of the system set up in mumax which the registered interactions and the linearised dynamics do not model.
+// It should be called after Setup, and it inspects the mumax parameters, the mesh, and the custom fields added to mumax.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func Validate() UnsupportedFeatures {
+
+	var features UnsupportedFeatures
+	regions := RegionIndices()
+
+	parameters := []parameterCheck{
+		{"Dind", en.Dind, "interfacial Dzyaloshinskii-Moriya interaction"},
+		{"Dbulk", en.Dbulk, "bulk Dzyaloshinskii-Moriya interaction"},
+		{"Ku2", en.Ku2, "second order uniaxial anisotropy"},
+		{"Kc1", en.Kc1, "cubic anisotropy"},
+		{"Kc2", en.Kc2, "cubic anisotropy"},
+		{"Kc3", en.Kc3, "cubic anisotropy"},
+		{"Temp", en.Temp, "thermal fluctuations"},
+		{"FrozenSpins", en.FrozenSpins, "frozen spins (see PinnedSurfaces)"},
+	}
+	if !SpinTransfer {
+		parameters = append(parameters, parameterCheck{"J", en.J, "spin-transfer torque (see SpinTransfer)"})
+	}
+
+	// terms which are modelled only while their interaction is registered.
+	names, _ := Interactions()
+	registered := make(map[string]bool)
+	for _, name := range names {
+		registered[name] = true
+	}
+	for _, term := range []struct {
+		interaction string
+		parameterCheck
+	}{
+		{"exchange", parameterCheck{"Aex", en.Aex, ""}},
+		{"anisotropy", parameterCheck{"Ku1", en.Ku1, ""}},
+		{"zeeman", parameterCheck{"B_ext", en.B_ext, ""}},
+		{"magnetoelastic", parameterCheck{"B1", en.B1, ""}},
+		{"magnetoelastic", parameterCheck{"B2", en.B2, ""}},
+	} {
+		if !registered[term.interaction] {
+			term.description = "the " + term.interaction + " interaction is not registered"
+			parameters = append(parameters, term.parameterCheck)
+		}
+	}
+
+	for _, p := range parameters {
+		if set := regionsSet(p.param, regions); len(set) > 0 {
+			features = append(features, UnsupportedFeature{Name: p.name, Description: p.description, Regions: set})
+		}
+	}
+
+	if en.EnableDemag && !registered["demag"] {
+		features = append(features, UnsupportedFeature{Name: "EnableDemag", Description: "the demag interaction is not registered"})
+	}
+
+	if customFieldSet() {
+		features = append(features, UnsupportedFeature{Name: "custom field", Description: "field terms added to mumax other than those of the InterlayerCouplings and SurfaceAnisotropies"})
+	}
+
+	return features
+}
+
+// A parameterCheck is a mumax parameter or excitation which is not modelled if it is non-zero anywhere.
+type parameterCheck struct {
+	name        string
+	param       Slicer
+	description string
+}
+
+// regionsSet returns the regions, in increasing order, of the cells in which the mumax parameter or excitation q is non-zero.
+func regionsSet(q Slicer, regions [][][]int) []int {
+
+	values := HostSlice(q).Tensors()
+
+	set := make(map[int]bool)
+	for c := range values {
+		for k := range values[c] {
+			for j := range values[c][k] {
+				for i := range values[c][k][j] {
+					if values[c][k][j][i] != 0 {
+						set[regions[k][j][i]] = true
+					}
+				}
+			}
+		}
+	}
+
+	var list []int
+	for r := range set {
+		list = append(list, r)
+	}
+	sort.Ints(list)
+	return list
+}
+
+// customFieldSet returns whether the custom field terms added to mumax give a field for the ground state stored in en.M,
+// other than the fields of the InterlayerCouplings and SurfaceAnisotropies, which may be added to mumax for relaxation.
+func customFieldSet() bool {
+
+	size := en.MeshSize()
+
+	customGPU := cuda.NewSlice(3, size)
+	defer customGPU.Free()
+	cuda.Zero(customGPU)
+	en.AddCustomField(customGPU)
+	custom := customGPU.HostCopy().Host()
+
+	m := en.M.Buffer().HostCopy()
+	modelled := InterlayerField(m)
+	AddSurfaceAnisotropyField(modelled, m)
+	known := modelled.Host()
+
+	// the fields are compared relative to the largest of them, as they are evaluated on the GPU and the CPU.
+	scale := 0.
+	for c := 0; c < 3; c++ {
+		for idx := range custom[c] {
+			scale = math.Max(scale, math.Abs(float64(custom[c][idx])))
+			scale = math.Max(scale, math.Abs(float64(known[c][idx])))
+		}
+	}
+	for c := 0; c < 3; c++ {
+		for idx := range custom[c] {
+			if math.Abs(float64(custom[c][idx]-known[c][idx])) > 1e-4*scale {
+				return true
+			}
+		}
+	}
+	return false
+}
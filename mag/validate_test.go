@@ -0,0 +1,39 @@
+package mag
+
+import (
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestValidate checks that setting the interfacial DMI, and unregistering the exchange interaction, are reported by Validate.
+func TestValidate(t *testing.T) {
+
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		Setup(s)
+		en.Relax()
+
+		en.Dind.Set(1e-3)
+		Unregister("exchange")
+		features := Validate()
+		Register("exchange", ExchangeInteraction{})
+		en.Dind.Set(0)
+
+		found := make(map[string]bool)
+		for _, f := range features {
+			found[f.Name] = true
+		}
+		for _, name := range []string{"Dind", "Aex"} {
+			if !found[name] {
+				t.Errorf("%d: %s is not reported: %v", test_idx, name, features)
+			}
+		}
+	}
+}
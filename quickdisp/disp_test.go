@@ -37,7 +37,11 @@ func TestNumericalDispersion(t *testing.T) {
 	magnitudes, frequencies := NumericalDispersion(1e11, 5e7, ks)
 
 	outname := fmt.Sprintf("plots.out/fmr.dat")
-	DispImageDat(ks, frequencies, magnitudes[2], direction, outname)
-	GnuplotColorMap(outname)
+	if err := DispImageDat(ks, frequencies, magnitudes[2], direction, outname); err != nil {
+		t.Fatal(err)
+	}
+	if err := GnuplotColorMap(outname); err != nil {
+		t.Log(err)
+	}
 
 }
@@ -9,6 +9,8 @@ import (
 
 	"github.com/mumax/3/httpfs"
 	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
 )
 
 var OnlyPlotPositiveFrequencies = true
@@ -34,10 +36,14 @@ func newArgSort(arr []float64) *argSort {
 	return s
 }
 
-func DispImageDat(ks [3][]int32, frequencies []float64, magnitudes [][]float32, direction [3]float64, name string) {
+// DispImageDat writes the dispersion, the magnitudes of each frequency for the wavevectors ks along direction, to the file name,
+// for GnuplotColorMap. It returns an *IOError if the file cannot be written.
+func DispImageDat(ks [3][]int32, frequencies []float64, magnitudes [][]float32, direction [3]float64, name string) error {
 
 	buf, err := httpfs.Create(name)
-	util.FatalErr(err)
+	if err != nil {
+		return &IOError{Op: "create", Path: name, Err: err}
+	}
 
 	nK := len(magnitudes)
 	kmag := make([]float64, nK)
@@ -70,17 +76,26 @@ func DispImageDat(ks [3][]int32, frequencies []float64, magnitudes [][]float32,
 		fmt.Fprint(buf, NEWLINE)
 	}
 
-	buf.Close()
+	if err := buf.Close(); err != nil {
+		return &IOError{Op: "write", Path: name, Err: err}
+	}
+	return nil
 }
 
-func GnuplotColorMap(infile string) {
+// GnuplotColorMap plots the file written by DispImageDat to a png with gnuplot.
+// It returns a *BackendError if gnuplot fails.
+func GnuplotColorMap(infile string) error {
 	basename := util.NoExt(infile)
 	outfile := basename + ".png"
 
 	gnucmdFormatted := fmt.Sprintf(gnucmd, outfile, infile)
 	fmt.Println(gnucmdFormatted)
-	gnuplotOut, _ := exec.Command("gnuplot", "-e", gnucmdFormatted).CombinedOutput()
+	gnuplotOut, err := exec.Command("gnuplot", "-e", gnucmdFormatted).CombinedOutput()
 	os.Stderr.Write(gnuplotOut)
+	if err != nil {
+		return &BackendError{Routine: "gnuplot", Reason: err.Error()}
+	}
+	return nil
 }
 
 var gnucmd = `set terminal png;
@@ -1,12 +1,14 @@
 package quickdisp
 
 import (
+	"fmt"
 	"math"
 	"math/rand"
 
 	"github.com/mumax/3/cuda"
 	en "github.com/mumax/3/engine"
-	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
 )
 
 // Arguments for the method of finding the ground state.
@@ -19,11 +21,15 @@ const (
 	AVERAGE_MAGNETISATION = 1
 )
 
-func UniformGroundState(method int) (m0 [3]float32) {
+// UniformGroundState returns the uniform ground state found by the minimisation scheme method,
+// or an *OptionError if the scheme is not known.
+func UniformGroundState(method int) (m0 [3]float32, err error) {
+
+	defer func() { Catch(recover(), &err) }()
 
 	switch method {
 	default:
-		util.Fatalf("Unknown minimisation scheme: %v", method)
+		return m0, &OptionError{Option: "minimisation scheme", Reason: fmt.Sprintf("%v is not known", method)}
 	case AVERAGE_FIELD:
 		au := NewAssumeUniform()
 		m0 = au.Relax()
@@ -35,7 +41,7 @@ func UniformGroundState(method int) (m0 [3]float32) {
 		m0 = normalise(m0)
 	}
 
-	return m0
+	return m0, nil
 
 }
 
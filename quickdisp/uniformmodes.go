@@ -15,6 +15,7 @@ import (
 func UniformModes(samplePoints [3][]int32) {
 
 	le := field.NewLinearEvolution()
+	defer le.Free()
 
 	for i := 0; i < len(samplePoints[0]); i++ {
 		for j := 0; j < len(samplePoints[1]); j++ {
@@ -56,7 +57,10 @@ func uniformMode(idx [3]int32, le *field.LinearEvolution) {
 		}
 		mReal := data.SliceFromPtrs(en.MeshSize(), data.GPUMemory, ptrsReal)
 		mImag := data.SliceFromPtrs(en.MeshSize(), data.GPUMemory, ptrsImag)
-		m := CSliceFromParts(mReal, mImag)
+		m, err := CSliceFromParts(mReal, mImag)
+		if err != nil {
+			panic(err)
+		}
 		le.OperateComplex(&B, m)
 
 		for c_ := 0; c_ < 3; c_++ {
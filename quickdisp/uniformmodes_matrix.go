@@ -16,17 +16,28 @@ import (
 */
 import "C"
 
-func UniformModesMatrix(samplePoints [3][]int32) (w [][3]complex128, V [][3][3]complex128) {
+// UniformModesMatrix returns the eigenvalues and eigenvectors of the eigenproblem tensor projected on the uniform mode of each wavevector,
+// whose components are given by the sample points, which must all be as many. It returns a *BackendError if LAPACK fails.
+func UniformModesMatrix(samplePoints [3][]int32) (w [][3]complex128, V [][3][3]complex128, err error) {
+
+	defer func() { Catch(recover(), &err) }()
+
+	if len(samplePoints[1]) != len(samplePoints[0]) || len(samplePoints[2]) != len(samplePoints[0]) {
+		return nil, nil, &OptionError{Option: "sample points", Reason: "there are not as many of each component"}
+	}
 
 	ept := mag.EigenProblemTensor()
 
 	w = make([][3]complex128, len(samplePoints[0]))
 	V = make([][3][3]complex128, len(samplePoints[0]))
 	for i := 0; i < len(samplePoints); i++ {
-		w[i], V[i] = uniformModeMatrix([3]int32{samplePoints[0][i], samplePoints[1][i], samplePoints[2][i]}, ept)
+		w[i], V[i], err = uniformModeMatrix([3]int32{samplePoints[0][i], samplePoints[1][i], samplePoints[2][i]}, ept)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return w, V
+	return w, V, nil
 
 }
 
@@ -55,7 +66,7 @@ func FourierMode(idx, size [3]int32, cellsize [3]float64) []complex128 {
 
 }
 
-func uniformModeMatrix(idx [3]int32, ept Tensor) (w [3]complex128, V [3][3]complex128) {
+func uniformModeMatrix(idx [3]int32, ept Tensor) (w [3]complex128, V [3][3]complex128, err error) {
 
 	size := [3]int32{int32(ept.Size[0]), int32(ept.Size[1]), int32(ept.Size[2])}
 
@@ -86,9 +97,12 @@ func uniformModeMatrix(idx [3]int32, ept Tensor) (w [3]complex128, V [3][3]compl
 
 	VFlat := make([]complex128, 9)
 
-	C.LAPACKE_zgeev(C.LAPACK_ROW_MAJOR, C.char([]rune("N")[0]), C.char([]rune("V")[0]),
+	info := C.LAPACKE_zgeev(C.LAPACK_ROW_MAJOR, C.char([]rune("N")[0]), C.char([]rune("V")[0]),
 		C.lapack_int(3), (*C.complexdouble)(&HkFlat[0]), C.lapack_int(3),
 		(*C.complexdouble)(&w[0]), nil, li3, (*C.complexdouble)(&VFlat[0]), li3)
+	if info != 0 {
+		return w, V, &BackendError{Routine: "zgeev", Info: int(info)}
+	}
 
 	for c := 0; c < 3; c++ {
 		for c_ := 0; c_ < 3; c_++ {
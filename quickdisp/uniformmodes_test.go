@@ -24,7 +24,10 @@ func TestModes(t *testing.T) {
 			[3]int{3, 3, 3})
 
 		//UniformModes(samplePoints)
-		w, _ := UniformModesMatrix(samplePoints)
+		w, _, err := UniformModesMatrix(samplePoints)
+		if err != nil {
+			t.Fatal(err)
+		}
 
 		for i := 0; i < len(samplePoints[0]); i++ {
 			for j := 0; j < len(samplePoints[1]); j++ {
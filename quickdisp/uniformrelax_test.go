@@ -20,8 +20,14 @@ func TestUniformRelax(t *testing.T) {
 
 		Setup(s)
 
-		m0 := UniformGroundState(AVERAGE_FIELD)
-		mmm := UniformGroundState(AVERAGE_MAGNETISATION)
+		m0, err := UniformGroundState(AVERAGE_FIELD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mmm, err := UniformGroundState(AVERAGE_MAGNETISATION)
+		if err != nil {
+			t.Fatal(err)
+		}
 		//average over all space.
 		fmt.Println(test_idx, m0, mmm)
 
@@ -8,6 +8,13 @@ package solver
 */
 import "C"
 
+import (
+	"math"
+	"strings"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
 type arnoldiD struct {
 	n, nev, ncv  C.int
 	bmat, which  *C.char
@@ -21,12 +28,13 @@ type arnoldiD struct {
 	workd, workl []float64
 	lworkl       C.int
 	info         *C.int
+	applications int // the number of applications of the operator requested so far.
 }
 
 func newArnoldiD(n, nev, ncv int, bmat, which string, tol float64, maxIter int, v0 []float64) arnoldiD {
 
 	if nev > n-1 {
-		panic("Can only compute maximum n-1 eigenvalues")
+		panic(&OptionError{Option: "NEV", Reason: "can only compute maximum n-1 eigenvalues"})
 	}
 
 	//the default parameter scipy uses.
@@ -42,7 +50,7 @@ func newArnoldiD(n, nev, ncv int, bmat, which string, tol float64, maxIter int,
 	}
 
 	if ncv > n || ncv < nev+2 {
-		panic("Must have nev + 2 <= ncv <= n")
+		panic(&OptionError{Option: "NCV", Reason: "must have nev + 2 <= ncv <= n"})
 	}
 
 	// v0 allows starting guess to be entered
@@ -99,12 +107,48 @@ func (arn *arnoldiD) iterate() (int32, []float64, []float64) {
 		arn.lworkl, arn.info)
 
 	if *arn.ido != C.int(99) {
+		arn.report()
 		return int32(*arn.ido), arn.workd[arn.ipntr[0]-1 : arn.ipntr[0]+arn.n-1], arn.workd[arn.ipntr[1]-1 : arn.ipntr[1]+arn.n-1]
 	} else {
 		return 99, nil, nil
 	}
 }
 
+// report checks whether the solve should be interrupted, and reports the progress of the iteration after each ncv applications
+// of the operator, once the first factorisation is complete, with the wanted Ritz values, which ARPACK keeps at the end of its array in workl, and the number of them
+// whose error bounds meet its convergence test.
+func (arn *arnoldiD) report() {
+
+	interrupt()
+	arn.applications++
+	if arn.applications <= int(arn.ncv) || arn.applications%int(arn.ncv) != 0 {
+		return
+	}
+
+	ncv, nev := int(arn.ncv), int(arn.nev)
+	ritzr := arn.workl[arn.ipntr[5]-1:][:ncv]
+	ritzi := arn.workl[arn.ipntr[6]-1:][:ncv]
+	bounds := arn.workl[arn.ipntr[7]-1:][:ncv]
+
+	eps := math.Pow(2, -53)
+	tol := float64(arn.tol)
+	if tol == 0 {
+		tol = eps
+	}
+	eps23 := math.Pow(eps, 2./3)
+
+	ritz := make([]complex128, nev)
+	converged := 0
+	for i := range ritz {
+		j := ncv - 1 - i
+		ritz[i] = complex(float64(ritzr[j]), float64(ritzi[j]))
+		if float64(bounds[j]) <= tol*math.Max(eps23, math.Hypot(real(ritz[i]), imag(ritz[i]))) {
+			converged++
+		}
+	}
+	progress(arn.applications, converged, ritz)
+}
+
 func (arn *arnoldiD) extract(computeVectors bool, selection []int32) ([]complex128, [][]complex128) {
 
 	var rvec C.int
@@ -229,6 +273,25 @@ func (arn arnoldiD) extractInfo() (int, string) {
 	return int(*arn.info), extractInfoDescription[*arn.info]
 }
 
+// checkIterate reports the exit of the iteration: after the maximum number of iterations, through notConverged,
+// as the converged pairs can still be extracted, and otherwise, unless it is a normal exit, by panicking with a *BackendError.
+func (arn arnoldiD) checkIterate() {
+	switch info, description := arn.iterateInfo(); info {
+	case 0:
+	case 1:
+		notConverged(&ConvergenceError{Solver: "ARPACK", Converged: int(arn.iparam[4]), Wanted: int(arn.nev), Iterations: int(arn.iparam[2])})
+	default:
+		panic(&BackendError{Routine: "dnaupd", Info: info, Reason: strings.Join(strings.Fields(description), " ")})
+	}
+}
+
+// checkExtract panics with a *BackendError if the extraction of the eigenpairs failed.
+func (arn arnoldiD) checkExtract() {
+	if info, description := arn.extractInfo(); info != 0 {
+		panic(&BackendError{Routine: "dneupd", Info: info, Reason: strings.Join(strings.Fields(description), " ")})
+	}
+}
+
 // Reimplement but for the single precision case.
 
 type arnoldiS struct {
@@ -244,12 +307,13 @@ type arnoldiS struct {
 	workd, workl []float32
 	lworkl       C.int
 	info         *C.int
+	applications int // the number of applications of the operator requested so far.
 }
 
 func newArnoldiS(n, nev, ncv int, bmat, which string, tol float32, maxIter int, v0 []float32) arnoldiS {
 
 	if nev > n-1 {
-		panic("Can only compute maximum n-1 eigenvalues")
+		panic(&OptionError{Option: "NEV", Reason: "can only compute maximum n-1 eigenvalues"})
 	}
 
 	//the default parameter scipy uses.
@@ -265,7 +329,7 @@ func newArnoldiS(n, nev, ncv int, bmat, which string, tol float32, maxIter int,
 	}
 
 	if ncv > n || ncv < nev+2 {
-		panic("Must have nev + 2 <= ncv <= n")
+		panic(&OptionError{Option: "NCV", Reason: "must have nev + 2 <= ncv <= n"})
 	}
 
 	// v0 allows starting guess to be entered
@@ -322,12 +386,48 @@ func (arn *arnoldiS) iterate() (int32, []float32, []float32) {
 		arn.lworkl, arn.info)
 
 	if *arn.ido != C.int(99) {
+		arn.report()
 		return int32(*arn.ido), arn.workd[arn.ipntr[0]-1 : arn.ipntr[0]+arn.n-1], arn.workd[arn.ipntr[1]-1 : arn.ipntr[1]+arn.n-1]
 	} else {
 		return 99, nil, nil
 	}
 }
 
+// report checks whether the solve should be interrupted, and reports the progress of the iteration after each ncv applications
+// of the operator, once the first factorisation is complete, with the wanted Ritz values, which ARPACK keeps at the end of its array in workl, and the number of them
+// whose error bounds meet its convergence test.
+func (arn *arnoldiS) report() {
+
+	interrupt()
+	arn.applications++
+	if arn.applications <= int(arn.ncv) || arn.applications%int(arn.ncv) != 0 {
+		return
+	}
+
+	ncv, nev := int(arn.ncv), int(arn.nev)
+	ritzr := arn.workl[arn.ipntr[5]-1:][:ncv]
+	ritzi := arn.workl[arn.ipntr[6]-1:][:ncv]
+	bounds := arn.workl[arn.ipntr[7]-1:][:ncv]
+
+	eps := math.Pow(2, -24)
+	tol := float64(arn.tol)
+	if tol == 0 {
+		tol = eps
+	}
+	eps23 := math.Pow(eps, 2./3)
+
+	ritz := make([]complex128, nev)
+	converged := 0
+	for i := range ritz {
+		j := ncv - 1 - i
+		ritz[i] = complex(float64(ritzr[j]), float64(ritzi[j]))
+		if float64(bounds[j]) <= tol*math.Max(eps23, math.Hypot(real(ritz[i]), imag(ritz[i]))) {
+			converged++
+		}
+	}
+	progress(arn.applications, converged, ritz)
+}
+
 func (arn *arnoldiS) extract(computeVectors bool, selection []int32) ([]complex128, [][]complex128) {
 
 	var rvec C.int
@@ -388,3 +488,22 @@ func (arn arnoldiS) iterateInfo() (int, string) {
 func (arn arnoldiS) extractInfo() (int, string) {
 	return int(*arn.info), extractInfoDescription[*arn.info]
 }
+
+// checkIterate reports the exit of the iteration: after the maximum number of iterations, through notConverged,
+// as the converged pairs can still be extracted, and otherwise, unless it is a normal exit, by panicking with a *BackendError.
+func (arn arnoldiS) checkIterate() {
+	switch info, description := arn.iterateInfo(); info {
+	case 0:
+	case 1:
+		notConverged(&ConvergenceError{Solver: "ARPACK", Converged: int(arn.iparam[4]), Wanted: int(arn.nev), Iterations: int(arn.iparam[2])})
+	default:
+		panic(&BackendError{Routine: "snaupd", Info: info, Reason: strings.Join(strings.Fields(description), " ")})
+	}
+}
+
+// checkExtract panics with a *BackendError if the extraction of the eigenpairs failed.
+func (arn arnoldiS) checkExtract() {
+	if info, description := arn.extractInfo(); info != 0 {
+		panic(&BackendError{Routine: "sneupd", Info: info, Reason: strings.Join(strings.Fields(description), " ")})
+	}
+}
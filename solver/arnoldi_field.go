@@ -19,97 +19,191 @@ var (
 	ARNOLDI_NEV = 20 //the maximum number of eigenvectors to find is n - 2.
 )
 
+// An ArnoldiField solver returns the ARNOLDI_NEV modes of smallest frequency by the implicitly restarted Arnoldi method,
+// applying the linear evolution of the system in the local frame of the ground state on the GPU.
+// With ShiftInvert set, it instead returns the modes with frequencies nearest ±Sigma.
+// It can be started from, and deflate, the modes of a previous solve through its KrylovStart.
 type ArnoldiField struct {
 	eigenSolver
+	KrylovStart
+
+	// ShiftInvert iterates with Re((L - i Sigma)⁻¹) rather than the linear evolution L, whose largest eigenvalues belong to the modes
+	// with frequencies nearest ±Sigma (rad/s), so these converge quickly. Each application solves a linear system with GMRES.
+	// Locked modes are not supported with ShiftInvert.
+	ShiftInvert bool
+	Sigma       float64
+
+	// NEV is the number of modes to find. If it is zero, ARNOLDI_NEV are found.
+	NEV int
+
+	// Checkpoint, if not empty, is a file to which the state of the iteration is written every CHECKPOINT_EVERY restarts, and from which
+	// it resumes, as for KrylovSchur. ARPACK keeps its state in variables which cannot be saved, so with a Checkpoint the implicitly restarted
+	// Arnoldi iteration is replaced by the equivalent Krylov–Schur iteration, which converges to a relative residual of KRYLOV_SCHUR_TOL.
+	Checkpoint string
 }
 
 func (solver ArnoldiField) Modes() ([]float64, []CSlice) {
+	return realFrequencies(solver.ComplexModes())
+}
 
-	totalSize := 2 * en.Mesh().NCell()
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the system, which include the decay rates when Damping is set.
+func (solver ArnoldiField) ComplexModes() ([]complex128, []CSlice) {
 
-	le := field.NewLinearEvolution()
-	rot := new(field.RotationToZ)
-	rot.InitRotation()
-	arn := newArnoldiS(totalSize, ARNOLDI_NEV, -1, "I", "SM", 0, 100*totalSize, nil)
+	NCell := en.Mesh().NCell()
+	totalSize := 2 * NCell
+
+	rotOp := newRotatedOperator()
+	defer rotOp.free()
+
+	rotCPU := new(mag.RotationToZ)
+	rotCPU.InitRotation()
 
 	xSl2 := cuda.NewSlice(2, en.MeshSize())
 	ySl2 := cuda.NewSlice(2, en.MeshSize())
-	xSl3 := cuda.NewSlice(3, en.MeshSize())
-	ySl3 := cuda.NewSlice(3, en.MeshSize())
+	defer xSl2.Free()
+	defer ySl2.Free()
 
-	ido, x, y := arn.iterate()
-	for ido == 1 || ido == -1 {
+	op := func(y, x []float32) {
 		xArr := make([][]float32, 2)
-		xArr[0] = x[0:en.Mesh().NCell()]
-		xArr[1] = x[en.Mesh().NCell():totalSize]
+		xArr[0] = x[0:NCell]
+		xArr[1] = x[NCell:totalSize]
 		xSlCPU := data.SliceFromArray(xArr, en.Mesh().Size())
 		data.Copy(xSl2, xSlCPU)
 
-		rot.DerotateMode(xSl3, xSl2)
-		le.Operate(ySl3, xSl3)
-		rot.RotateMode(ySl2, ySl3)
+		rotOp.apply(ySl2, xSl2)
 
 		yArr := make([][]float32, 2)
-		yArr[0] = y[0:en.Mesh().NCell()]
-		yArr[1] = y[en.Mesh().NCell():totalSize]
+		yArr[0] = y[0:NCell]
+		yArr[1] = y[NCell:totalSize]
 		ySlCPU := data.SliceFromArray(yArr, en.Mesh().Size())
 		data.Copy(ySlCPU, ySl2)
+	}
+	which := "SM"
 
-		ido, x, y = arn.iterate()
+	var si *shiftInvert
+	if solver.ShiftInvert {
+		if len(solver.Locked) > 0 {
+			panic(&OptionError{Option: "Locked", Reason: "locked modes are not supported with ShiftInvert"})
+		}
+		si = newShiftInvert(rotOp, solver.Sigma)
+		defer si.free()
+		op = si.apply
+		which = "LM"
 	}
 
-	xSl2.Free()
-	ySl2.Free()
-	xSl3.Free()
-	ySl3.Free()
+	defl := newDeflation(rotCPU, solver.Locked, func(y, x []float64) {
+		yS := make([]float32, totalSize)
+		op(yS, toSingle(x))
+		for i := range y {
+			y[i] = float64(yS[i])
+		}
+	})
 
-	info, infoString := arn.iterateInfo()
-	util.AssertMsg(info == 0, infoString)
+	var v0 []float32
+	if start := solver.startVector(rotCPU, defl); start != nil {
+		v0 = toSingle(start)
+	}
 
-	values, vectors := arn.extract(true, nil)
-	info, infoString = arn.extractInfo()
-	util.AssertMsg(info == 0, infoString)
+	nev := solver.NEV
+	if nev == 0 {
+		nev = ARNOLDI_NEV
+	}
 
-	nevReturned := len(values)
-	iterations := arn.iparam[2]
-	util.Log(fmt.Sprintf("Found %d eigenvalues (out of total dimension %d) in %d iterations.", nevReturned, totalSize, iterations))
+	var values []complex128
+	var vectors [][]complex128
+	if solver.Checkpoint != "" {
+		values, vectors = solver.checkpointed(totalSize, nev, op, defl, v0)
+	} else {
+		arn := newArnoldiS(totalSize, nev, -1, "I", which, 0, 100*totalSize, v0)
+
+		ido, x, y := arn.iterate()
+		for ido == 1 || ido == -1 {
+			op(y, x)
+			defl.shiftS(y, x)
+			ido, x, y = arn.iterate()
+		}
 
-	freq := make([]float64, nevReturned)
-	modes := make([]CSlice, nevReturned)
+		arn.checkIterate()
 
-	Nx := en.MeshSize()[0]
-	Ny := en.MeshSize()[1]
-	Nz := en.MeshSize()[2]
+		values, vectors = arn.extract(true, nil)
+		arn.checkExtract()
 
-	rotCPU := new(mag.RotationToZ)
-	rotCPU.InitRotation()
+		iterations := arn.iparam[2]
+		util.Log(fmt.Sprintf("Found %d eigenvalues (out of total dimension %d) in %d iterations.", len(values), totalSize, iterations))
+	}
 
-	for p := 0; p < nevReturned; p++ {
+	nevReturned := len(values)
+	if si != nil {
+		util.Log(fmt.Sprintf("Shift-invert took %d GMRES iterations.", si.iterations))
+	}
 
-		freq[p] = imag(values[p])
+	var freq []complex128
+	var modes []CSlice
 
-		mode := NewCSliceCPU(2, en.MeshSize())
-		modeReal := mode.Real().Tensors()
-		modeImag := mode.Imag().Tensors()
+	for p := 0; p < nevReturned; p++ {
 
-		for c := 0; c < 2; c++ {
-			for i := 0; i < Nx; i++ {
-				for j := 0; j < Ny; j++ {
-					for k := 0; k < Nz; k++ {
-						modeReal[c][k][j][i] = float32(real(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-						modeImag[c][k][j][i] = float32(imag(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-					}
-				}
-			}
+		if defl.shifted(values[p]) {
+			continue
 		}
 
-		modes[p] = rotCPU.DerotateMode(mode)
+		vector := defl.correct(values[p], vectors[p])
+		λ := values[p]
+		if si != nil {
+			λ = si.rayleighQuotient(vector)
+		}
+
+		freq = append(freq, complexFrequency(λ))
+		mode := transverseMode(vector, en.MeshSize())
+		modes = append(modes, rotCPU.DerotateMode(mode))
 	}
 
 	return freq, modes
 
 }
 
+// checkpointed finds the nev wanted eigenpairs of the deflated operator op by the Krylov–Schur iteration, which can be checkpointed,
+// starting from v0, or from a random vector if it is nil.
+func (solver ArnoldiField) checkpointed(n, nev int, op func(y, x []float32), defl deflation, v0 []float32) ([]complex128, [][]complex128) {
+
+	yS := make([]float32, n)
+	op64 := func(y, x []float64) {
+		xS := toSingle(x)
+		op(yS, xS)
+		defl.shiftS(yS, xS)
+		for i := range y {
+			y[i] = float64(yS[i])
+		}
+	}
+
+	var start []float64
+	if v0 != nil {
+		start = make([]float64, n)
+		for i := range v0 {
+			start[i] = float64(v0[i])
+		}
+	} else {
+		start = magnetisedStart(n/2, 2)
+		defl.project(start)
+	}
+
+	better := smallerMagnitude
+	if solver.ShiftInvert {
+		better = largerMagnitude
+	}
+
+	ks := KrylovSchur{NEV: nev, ShiftInvert: solver.ShiftInvert, Sigma: solver.Sigma, Checkpoint: solver.Checkpoint}
+	return ks.iterate(n, op64, start, magnetisedMask(n/2, 2), better, fmt.Sprintf("ArnoldiField with %d locked modes shifted by %g", len(defl.q), defl.σ))
+}
+
+// toSingle returns a single precision copy of v.
+func toSingle(v []float64) []float32 {
+	s := make([]float32, len(v))
+	for i := range v {
+		s[i] = float32(v[i])
+	}
+	return s
+}
+
 type ArnoldiFieldUnrotated struct {
 	eigenSolver
 }
@@ -119,6 +213,7 @@ func (solver ArnoldiFieldUnrotated) Modes() ([]float64, []CSlice) {
 	totalSize := 3 * en.Mesh().NCell()
 
 	le := field.NewLinearEvolution()
+	defer le.Free()
 	rot := new(field.RotationToZ)
 	rot.InitRotation()
 	defer rot.Free()
@@ -151,12 +246,10 @@ func (solver ArnoldiFieldUnrotated) Modes() ([]float64, []CSlice) {
 	xSl3.Free()
 	ySl3.Free()
 
-	info, infoString := arn.iterateInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkIterate()
 
 	values, vectors := arn.extract(true, nil)
-	info, infoString = arn.extractInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkExtract()
 
 	nevReturned := len(values)
 	iterations := arn.iparam[2]
@@ -212,6 +305,7 @@ func (solver ArnoldiField2) Modes() ([]float64, []CSlice) {
 	totalSize := 2 * en.Mesh().NCell()
 
 	le := field.NewLinearEvolution()
+	defer le.Free()
 	rot := new(mag.RotationToZ)
 	rot.InitRotation()
 	arn := newArnoldiS(totalSize, totalSize-2, -1, "I", "SM", 0, 100*totalSize, nil)
@@ -247,12 +341,10 @@ func (solver ArnoldiField2) Modes() ([]float64, []CSlice) {
 	xSl3.Free()
 	ySl3.Free()
 
-	info, infoString := arn.iterateInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkIterate()
 
 	values, vectors := arn.extract(true, nil)
-	info, infoString = arn.extractInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkExtract()
 
 	nevReturned := len(values)
 	iterations := arn.iparam[2]
@@ -304,6 +396,7 @@ func (solver *ArnoldiFieldTimes) Modes() ([]float64, []CSlice) {
 	totalSize := 2 * en.Mesh().NCell()
 
 	le := field.NewLinearEvolution()
+	defer le.Free()
 	rot := new(field.RotationToZ)
 	rot.InitRotation()
 	arn := newArnoldiS(totalSize, ARNOLDI_NEV, -1, "I", "SM", 0, 100*totalSize, nil)
@@ -356,14 +449,12 @@ func (solver *ArnoldiFieldTimes) Modes() ([]float64, []CSlice) {
 	xSl3.Free()
 	ySl3.Free()
 
-	info, infoString := arn.iterateInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkIterate()
 
 	tBefore := time.Now()
 	values, vectors := arn.extract(true, nil)
 	solver.finishingTime = time.Now().Sub(tBefore)
-	info, infoString = arn.extractInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkExtract()
 
 	nevReturned := len(values)
 	iterations := arn.iparam[2]
@@ -10,13 +10,14 @@ import (
 	"github.com/mumax/3/util"
 )
 
-// A RotatedToZ solver returns the modes of the system by first rotating the system
+// An ArnoldiMatrix solver returns the modes of the system by first rotating the system
 // such that the z direction at each point coincides with the ground state direction at that point.
 // As a result (and because non-null eigenmodes are perpendicular to the ground state), the zero eigenmodes can be eliminated from the system
-// and hence a smaller matrix can be diagonalised.
-// The time complexity is O((2*Nx*Ny*Nz)^3)
+// and the remaining matrix is diagonalised by the implicitly restarted Arnoldi method.
+// It can be started from, and deflate, the modes of a previous solve through its KrylovStart.
 type ArnoldiMatrix struct {
 	eigenSolver
+	KrylovStart
 }
 
 // Modes returns the eigenfrequencies and corresponding eigenmodes of the system.
@@ -28,8 +29,19 @@ func (solver ArnoldiMatrix) Modes() ([]float64, []CSlice) {
 	return solver.Solve(t)
 }
 
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the system, which include the decay rates when Damping is set.
+func (solver ArnoldiMatrix) ComplexModes() ([]complex128, []CSlice) {
+	t := EigenProblemTensor()
+	return solver.SolveComplex(t)
+}
+
 // Solve returns the non-null eigenpairs of a particular input Tensor after taking cross product with the system magnetisation.
 func (solver ArnoldiMatrix) Solve(t Tensor) ([]float64, []CSlice) {
+	return realFrequencies(solver.SolveComplex(t))
+}
+
+// SolveComplex returns the non-null eigenpairs of a particular input Tensor, with complex frequencies.
+func (solver ArnoldiMatrix) SolveComplex(t Tensor) ([]complex128, []CSlice) {
 
 	rot := new(mag.RotationToZ)
 	rot.InitRotation()
@@ -39,52 +51,41 @@ func (solver ArnoldiMatrix) Solve(t Tensor) ([]float64, []CSlice) {
 
 	totalSize := 2 * twoD.Length()
 
-	arn := newArnoldiD(totalSize, totalSize-2, -1, "I", "SM", 0, 100*totalSize, nil)
+	op := func(y, x []float64) {
+		matvecmul(totalSize, totalSize, arr, x, y)
+	}
+	defl := newDeflation(rot, solver.Locked, op)
+
+	arn := newArnoldiD(totalSize, totalSize-2-len(defl.q), -1, "I", "SM", 0, 100*totalSize, solver.startVector(rot, defl))
 
 	ido, x, y := arn.iterate()
 	for ido == 1 || ido == -1 {
-		matvecmul(totalSize, totalSize, arr, x, y)
+		op(y, x)
+		defl.shift(y, x)
 		ido, x, y = arn.iterate()
 	}
 
-	info, infoString := arn.iterateInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkIterate()
 
 	values, vectors := arn.extract(true, nil)
-	info, infoString = arn.extractInfo()
-	util.AssertMsg(info == 0, infoString)
+	arn.checkExtract()
 
 	nevReturned := len(values)
 	iterations := arn.iparam[2]
 	util.Log(fmt.Sprintf("Found %d eigenvalues (out of total dimension %d) in %d iterations.", nevReturned, totalSize, iterations))
 
-	freq := make([]float64, nevReturned)
-	modes := make([]CSlice, nevReturned)
-
-	Nx := t.Size[0]
-	Ny := t.Size[1]
-	Nz := t.Size[2]
+	var freq []complex128
+	var modes []CSlice
 
 	for p := 0; p < nevReturned; p++ {
 
-		freq[p] = imag(values[p])
-
-		mode := NewCSliceCPU(2, t.Size)
-		modeReal := mode.Real().Tensors()
-		modeImag := mode.Imag().Tensors()
-
-		for c := 0; c < 2; c++ {
-			for i := 0; i < Nx; i++ {
-				for j := 0; j < Ny; j++ {
-					for k := 0; k < Nz; k++ {
-						modeReal[c][k][j][i] = float32(real(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-						modeImag[c][k][j][i] = float32(imag(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-					}
-				}
-			}
+		if defl.shifted(values[p]) {
+			continue
 		}
 
-		modes[p] = rot.DerotateMode(mode)
+		freq = append(freq, complexFrequency(values[p]))
+		mode := transverseMode(defl.correct(values[p], vectors[p]), t.Size)
+		modes = append(modes, rot.DerotateMode(mode))
 	}
 
 	return freq, modes
@@ -2,6 +2,8 @@ package solver
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"testing"
 	"time"
 
@@ -85,3 +87,150 @@ func TestArnoldiField(t *testing.T) {
 		}
 	}
 }
+
+// TestArnoldiKrylovStart checks that ArnoldiField started from its own modes finds them again,
+// and that locking the lowest pairs of modes returns the next ones, as found by RotatedToZ.
+func TestArnoldiKrylovStart(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	nLocked := 4
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		Solver = new(ArnoldiField)
+		valsB, vecsB := Modes()
+
+		Solver = &ArnoldiField{KrylovStart: KrylovStart{Guess: vecsB}}
+		valsC, vecsC := Modes()
+
+		err := tests.EqualSubDecompositions(vecsA, vecsC, valsA, valsC, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: warm started decompositions are not equal: %d%% error", test_idx, 100*err/len(valsA))
+		}
+
+		// lock the lowest pairs of modes, and remove them from the reference.
+		lowest := smallestMagnitude(valsB, nLocked)
+		var locked []CSlice
+		for _, p := range lowest {
+			locked = append(locked, vecsB[p])
+		}
+		Solver = &ArnoldiField{KrylovStart: KrylovStart{Locked: locked}}
+		valsD, vecsD := Modes()
+
+		var valsRest []float64
+		var vecsRest []CSlice
+		lowestA := smallestMagnitude(valsA, nLocked)
+		for p := range valsA {
+			isLocked := false
+			for _, q := range lowestA {
+				isLocked = isLocked || p == q
+			}
+			if !isLocked {
+				valsRest = append(valsRest, valsA[p])
+				vecsRest = append(vecsRest, vecsA[p])
+			}
+		}
+
+		err = tests.EqualSubDecompositions(vecsRest, vecsD, valsRest, valsD, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: deflated decompositions are not equal: %d%% error", test_idx, 100*err/len(valsD))
+		}
+	}
+}
+
+// smallestMagnitude returns the indices of the n values of smallest magnitude.
+func smallestMagnitude(vals []float64, n int) []int {
+	idx := make([]int, len(vals))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return math.Abs(vals[idx[i]]) < math.Abs(vals[idx[j]]) })
+	return idx[:n]
+}
+
+// TestShiftInvert checks that ArnoldiField with ShiftInvert returns the modes with frequencies nearest ±Sigma, as found by RotatedToZ.
+func TestShiftInvert(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, _ := Modes()
+
+		sorted := append([]float64(nil), valsA...)
+		sort.Float64s(sorted)
+		σ := sorted[3*len(sorted)/4]
+
+		Solver = &ArnoldiField{ShiftInvert: true, Sigma: σ}
+		valsB, _ := Modes()
+
+		distance := func(f float64) float64 { return math.Abs(math.Abs(f) - σ) }
+		sort.Slice(sorted, func(i, j int) bool { return distance(sorted[i]) < distance(sorted[j]) })
+		want := sorted[:len(valsB)]
+		sort.Float64s(want)
+		sort.Float64s(valsB)
+
+		err := 0
+		for i := range valsB {
+			err += tests.EqualScalars(want[i], valsB[i], 1e-3)
+		}
+		if err > 0 {
+			t.Errorf("%d: shift-inverted frequencies are not those nearest σ: %d%% error", test_idx, 100*err/len(valsB))
+		}
+	}
+}
+
+// TestKrylovSchur checks that KrylovSchur, with and without locking, returns a subset of the modes found by RotatedToZ,
+// that the Monitor is called with a residual for each Ritz value, and that stopping at the first restart returns only the converged pairs.
+func TestKrylovSchur(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		for _, lock := range []bool{false, true} {
+			restarts := 0
+			Solver = KrylovSchur{NEV: 6, Lock: lock, Monitor: func(restart int, ritz []complex128, residuals []float64) bool {
+				if len(ritz) != len(residuals) {
+					t.Errorf("%d: %d Ritz values but %d residuals", test_idx, len(ritz), len(residuals))
+				}
+				restarts = restart + 1
+				return false
+			}}
+			valsB, vecsB := Modes()
+
+			if restarts == 0 {
+				t.Errorf("%d: the monitor was not called", test_idx)
+			}
+			if len(valsB) < 6 {
+				t.Fatalf("%d: found %d modes; want at least 6", test_idx, len(valsB))
+			}
+
+			err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+			if err > 0 {
+				t.Errorf("%d: Decompositions are not equal with Lock %v: %d%% error", test_idx, lock, 100*err/len(valsB))
+			}
+		}
+
+		// stopping at the first restart returns only the pairs converged by then.
+		Solver = KrylovSchur{NEV: 6, Restart: 8, Monitor: func(int, []complex128, []float64) bool { return true }}
+		valsC, _ := Modes()
+		if len(valsC) > 7 {
+			t.Errorf("%d: stopped early but found %d modes", test_idx, len(valsC))
+		}
+	}
+}
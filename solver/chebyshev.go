@@ -0,0 +1,416 @@
+package solver
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"sort"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+var (
+	CHEB_DEGREE     = 10   // the degree of the Chebyshev filter.
+	CHEB_GUARD      = 10   // the number of vectors in the block beyond those sought, which speed the convergence of the last of them.
+	CHEB_TOL        = 1e-4 // the residual, relative to the frequency, at which a mode has converged.
+	CHEB_MAXITER    = 200  // the maximum number of filter applications.
+	CHEB_CHECKPOINT = 10   // the number of iterations between checkpoints.
+	CHEB_BOUND      = 20   // the number of Lanczos steps used to bound the spectrum.
+)
+
+// A ChebyshevFilter solver returns the NEV modes of lowest frequency by Chebyshev-filtered subspace iteration, for a stable ground state without Damping.
+// A block of vectors in the local frame of the ground state is repeatedly multiplied by a Chebyshev polynomial in -L², whose eigenvalues are ω², which grows the modes
+// with ω² below a cut and damps those above it, up to a bound on the spectrum found by a few Lanczos steps. The cut is lowered each iteration
+// to the largest Ritz value of the block. The Rayleigh–Ritz step solves H x = ω K x projected on the block, in the metric K of the spin waves
+// (see pseudoHermitianLanczos), so that the modes come in ± pairs and are σ-orthogonal.
+// The block is held on the GPU as CSlices. It suits many modes of large meshes, as the work is in applications of L to the whole block.
+type ChebyshevFilter struct {
+	eigenSolver
+	NEV int // the number of modes to find, counting each of a ± pair. If it is zero, ARNOLDI_NEV are found.
+
+	// Checkpoint, if not empty, is a directory to which the block is written by WriteModes every CHEB_CHECKPOINT iterations, and at the end.
+	// If it already holds modes written for the same system, verified by a hash of it, the iteration starts from them.
+	Checkpoint string
+}
+
+// Modes returns the eigenfrequencies and corresponding eigenmodes of the system, in increasing order of the magnitude of the frequency.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation is currently stored in en.M.
+func (solver ChebyshevFilter) Modes() ([]float64, []CSlice) {
+
+	if Damping || SpinTransfer {
+		panic(&OptionError{Option: "dynamics", Reason: "the Chebyshev filter needs the undamped dynamics without spin-transfer torques"})
+	}
+
+	nev := solver.NEV
+	if nev == 0 {
+		nev = ARNOLDI_NEV
+	}
+	NCell := en.Mesh().NCell()
+	size := en.MeshSize()
+	k := nev + CHEB_GUARD
+	if k > 2*NCell {
+		k = 2 * NCell
+	}
+
+	op := newRotatedOperator()
+	defer op.free()
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+
+	applyL, freeL := hostOperator(op)
+	ωMax := newPseudoHermitianLanczos(applyL).bound(CHEB_BOUND, 0)
+	freeL()
+
+	var hash string
+	if solver.Checkpoint != "" {
+		hash = systemHash(nil, nil)
+	}
+
+	cf := newChebyshevFilter(op)
+	defer cf.free()
+
+	rng := rand.New(rand.NewSource(0))
+	X := solver.startBlock(rot, k, rng, hash)
+
+	// the filter damps ω² in [cut, bound], and the spectrum is bounded below by zero.
+	bound := ωMax * ωMax
+	cut := bound / 4
+
+	var freqs []float64
+	var LX []CSlice
+
+	for iter := 0; ; iter++ {
+
+		cf.apply(X, cut, bound)
+
+		for i := range LX {
+			LX[i].Free()
+		}
+		var residuals []float64
+		X, LX, freqs, residuals = cf.rayleighRitz(X)
+		for len(X) < k {
+			X = append(X, randomTransverse(rng, size))
+			LX = append(LX, NewCSlice(2, size))
+			freqs = append(freqs, math.Inf(1))
+			residuals = append(residuals, math.Inf(1))
+		}
+
+		converged := 0
+		for converged < nev && residuals[converged] < CHEB_TOL {
+			converged++
+		}
+		ritz := make([]complex128, len(freqs))
+		for i := range freqs {
+			ritz[i] = complex(freqs[i], 0)
+		}
+		progress(iter, converged, ritz)
+
+		if converged == nev || iter == CHEB_MAXITER {
+			if converged < nev {
+				util.Log(fmt.Sprintf("The Chebyshev filter stopped after %d iterations with %d of %d modes converged.", iter, converged, nev))
+				notConverged(&ConvergenceError{Solver: "ChebyshevFilter", Converged: converged, Wanted: nev, Iterations: iter})
+			} else {
+				util.Log(fmt.Sprintf("Found %d modes in %d iterations.", nev, iter))
+			}
+			if solver.Checkpoint != "" {
+				writeModesCheckpoint(freqs, derotateBlock(rot, X), solver.Checkpoint, hash)
+			}
+			break
+		}
+
+		if f := freqs[len(freqs)-1]; !math.IsInf(f, 0) && f*f < bound {
+			cut = f * f
+		}
+
+		if solver.Checkpoint != "" && (iter+1)%CHEB_CHECKPOINT == 0 {
+			writeModesCheckpoint(freqs, derotateBlock(rot, X), solver.Checkpoint, hash)
+		}
+	}
+
+	modes := derotateBlock(rot, X[:nev])
+	for i := range X {
+		X[i].Free()
+		LX[i].Free()
+	}
+	return freqs[:nev], modes
+}
+
+// startBlock returns k vectors in the local frame of the ground state on the GPU, from the Checkpoint if it holds modes of the system
+// with the given hash, and otherwise random.
+func (solver ChebyshevFilter) startBlock(rot *mag.RotationToZ, k int, rng *rand.Rand, hash string) []CSlice {
+
+	size := en.MeshSize()
+	var X []CSlice
+
+	if solver.Checkpoint != "" {
+		if modesCheckpointMatches(solver.Checkpoint, hash) {
+			_, saved, err := ReadModes(solver.Checkpoint)
+			if err != nil {
+				panic(err)
+			}
+			for i := 0; i < len(saved) && i < k; i++ {
+				x := NewCSlice(2, size)
+				Copy(x, rot.RotateMode(saved[i]))
+				X = append(X, x)
+			}
+			util.Log(fmt.Sprintf("Started the Chebyshev filter from %d modes in %s.", len(X), solver.Checkpoint))
+		}
+	}
+
+	for len(X) < k {
+		X = append(X, randomTransverse(rng, size))
+	}
+	return X
+}
+
+// randomTransverse returns a random vector in the local frame of the ground state on the GPU, which is zero at the cells without magnetisation.
+func randomTransverse(rng *rand.Rand, size [3]int) CSlice {
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	host := NewCSliceCPU(2, size)
+	re, im := host.Real().Host(), host.Imag().Host()
+	for c := 0; c < 2; c++ {
+		for idx := range ms {
+			if ms[idx] != 0 {
+				re[c][idx] = float32(rng.NormFloat64())
+				im[c][idx] = float32(rng.NormFloat64())
+			}
+		}
+	}
+	x := NewCSlice(2, size)
+	Copy(x, host)
+	return x
+}
+
+// derotateBlock returns the three component modes on the CPU from the vectors in the local frame of the ground state.
+func derotateBlock(rot *mag.RotationToZ, X []CSlice) []CSlice {
+	modes := make([]CSlice, len(X))
+	for i := range X {
+		modes[i] = rot.DerotateMode(X[i].HostCopy())
+	}
+	return modes
+}
+
+// A chebyshevFilter applies the polynomial filters, and the Rayleigh–Ritz step, to blocks of vectors in the local frame of the ground state on the GPU.
+type chebyshevFilter struct {
+	op     *rotatedOperator
+	metric *data.Slice // Ms/γ and -Ms/γ in the two components, so that N y = metric * (y_1, y_0) applies (Ms/γ)(-J).
+	t      CSlice
+}
+
+func newChebyshevFilter(op *rotatedOperator) *chebyshevFilter {
+
+	size := en.MeshSize()
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	host := data.NewSlice(2, size)
+	m := host.Host()
+	for idx := range ms {
+		m[0][idx] = float32(float64(ms[idx]) / en.GammaLL)
+		m[1][idx] = -m[0][idx]
+	}
+	metric := cuda.NewSlice(2, size)
+	data.Copy(metric, host)
+
+	return &chebyshevFilter{
+		op:     op,
+		metric: metric,
+		t:      NewCSlice(2, size),
+	}
+}
+
+func (cf *chebyshevFilter) free() {
+	cf.metric.Free()
+	cf.t.Free()
+}
+
+// applySquare sets each dst[i] = A² src[i] = -L² src[i], for A = -iL, whose eigenvalues are ω², applying L to the whole block at once.
+// t holds L src.
+func (cf *chebyshevFilter) applySquare(dst, src, t []CSlice) {
+	cf.op.applyComplexBatch(t, src)
+	cf.op.applyComplexBatch(dst, t)
+	for i := range dst {
+		SScal(dst[i], dst[i], -1)
+	}
+}
+
+// applyMetric sets dst = (Ms/γ)(-J) src, for which H = N L and K = iN.
+func (cf *chebyshevFilter) applyMetric(dst, src CSlice) {
+	for _, part := range [][2]*data.Slice{{dst.Real(), src.Real()}, {dst.Imag(), src.Imag()}} {
+		data.Copy(part[0].Comp(0), part[1].Comp(1))
+		data.Copy(part[0].Comp(1), part[1].Comp(0))
+		cuda.Mul(part[0], part[0], cf.metric)
+	}
+}
+
+// apply replaces each vector of the block X by the Chebyshev polynomial of degree CHEB_DEGREE in A², which is small on [cut, bound]
+// and scaled to be one at zero, applied to it, and normalised. This is the scaled three term recurrence of Zhou and Saad, J. Comput. Phys. 219 (2006),
+// run for the whole block at once, so that each application of L is a single batch.
+func (cf *chebyshevFilter) apply(X []CSlice, cut, bound float64) {
+
+	e := (bound - cut) / 2
+	c := (bound + cut) / 2
+	σ := e / (0 - c)
+	τ := 2 / σ
+
+	size := en.MeshSize()
+	prev := make([]CSlice, len(X))
+	next := make([]CSlice, len(X))
+	t := make([]CSlice, len(X))
+	for i := range X {
+		prev[i] = NewCSlice(2, size)
+		next[i] = NewCSlice(2, size)
+		t[i] = NewCSlice(2, size)
+	}
+	defer func() {
+		for i := range X {
+			prev[i].Free()
+			next[i].Free()
+			t[i].Free()
+		}
+	}()
+
+	for i := range X {
+		Copy(prev[i], X[i])
+	}
+	cf.applySquare(X, prev, t)
+	for i := range X {
+		SMadd2(X[i], X[i], prev[i], float32(σ/e), float32(-c*σ/e))
+	}
+
+	for d := 2; d <= CHEB_DEGREE; d++ {
+		σNext := 1 / (τ - σ)
+		cf.applySquare(next, X, t)
+		for i := range X {
+			SMadd2(next[i], next[i], X[i], float32(2*σNext/e), float32(-2*c*σNext/e))
+			SMadd2(next[i], next[i], prev[i], 1, float32(-σ*σNext))
+			Copy(prev[i], X[i])
+			Copy(X[i], next[i])
+		}
+		σ = σNext
+	}
+
+	for i := range X {
+		SScal(X[i], X[i], float32(1/norm(X[i])))
+	}
+}
+
+// rayleighRitz returns the Ritz vectors of the block X, normalised and with L applied to them, their frequencies and their residuals
+// |L x - iω x| / |ω|, in increasing order of the magnitude of the frequency. The projected problem X* H X c = ω X* K X c is solved through the
+// eigendecomposition of the positive definite X* H X, which drops the directions in which the block is degenerate. X is freed.
+func (cf *chebyshevFilter) rayleighRitz(X []CSlice) ([]CSlice, []CSlice, []float64, []float64) {
+
+	k := len(X)
+	size := en.MeshSize()
+
+	LX := make([]CSlice, k)
+	for j := range LX {
+		LX[j] = NewCSlice(2, size)
+	}
+	cf.op.applyComplexBatch(LX, X)
+
+	GH := make([][]complex128, k)
+	GK := make([][]complex128, k)
+	for i := range GH {
+		GH[i] = make([]complex128, k)
+		GK[i] = make([]complex128, k)
+	}
+	for j := range X {
+		cf.applyMetric(cf.t, LX[j])
+		for i := range X {
+			GH[i][j] = complex128(Dotc(X[i], cf.t))
+		}
+		cf.applyMetric(cf.t, X[j])
+		for i := range X {
+			GK[i][j] = complex(0, 1) * complex128(Dotc(X[i], cf.t))
+		}
+	}
+	for i := 0; i < k; i++ {
+		for j := 0; j <= i; j++ {
+			GH[i][j] = (GH[i][j] + cmplx.Conj(GH[j][i])) / 2
+			GH[j][i] = cmplx.Conj(GH[i][j])
+			GK[i][j] = (GK[i][j] + cmplx.Conj(GK[j][i])) / 2
+			GK[j][i] = cmplx.Conj(GK[i][j])
+		}
+	}
+
+	// W = U D^(-1/2) over the well conditioned directions, so that W* X* H X W = I.
+	d, U := hermitianEigen(GH)
+	var W [][]complex128
+	for j := range d {
+		if d[j] > 1e-6*d[len(d)-1] {
+			col := make([]complex128, k)
+			for i := range col {
+				col[i] = U[i][j] / complex(math.Sqrt(d[j]), 0)
+			}
+			W = append(W, col)
+		}
+	}
+	r := len(W)
+
+	M := make([][]complex128, r)
+	for p := range M {
+		M[p] = make([]complex128, r)
+		for q := range M[p] {
+			for i := 0; i < k; i++ {
+				for j := 0; j < k; j++ {
+					M[p][q] -= cmplx.Conj(W[p][i]) * GK[i][j] * W[q][j]
+				}
+			}
+		}
+	}
+	μ, C := hermitianEigen(M)
+
+	ω := make([]float64, r)
+	for q := range ω {
+		ω[q] = -1 / μ[q]
+	}
+	order := make([]int, r)
+	for q := range order {
+		order[q] = q
+	}
+	sort.Slice(order, func(a, b int) bool {
+		if math.Abs(ω[order[a]]) != math.Abs(ω[order[b]]) {
+			return math.Abs(ω[order[a]]) < math.Abs(ω[order[b]])
+		}
+		return ω[order[a]] > ω[order[b]]
+	})
+
+	Y := make([]CSlice, r)
+	LY := make([]CSlice, r)
+	freqs := make([]float64, r)
+	residuals := make([]float64, r)
+	for p, q := range order {
+		Y[p] = NewCSlice(2, size)
+		LY[p] = NewCSlice(2, size)
+		Zero(Y[p])
+		Zero(LY[p])
+		for i := 0; i < k; i++ {
+			var coef complex128
+			for j := 0; j < r; j++ {
+				coef += W[j][i] * C[j][q]
+			}
+			CMadd2(Y[p], Y[p], X[i], 1, complex64(coef))
+			CMadd2(LY[p], LY[p], LX[i], 1, complex64(coef))
+		}
+		s := float32(1 / norm(Y[p]))
+		SScal(Y[p], Y[p], s)
+		SScal(LY[p], LY[p], s)
+
+		freqs[p] = ω[q]
+		CMadd2(cf.t, LY[p], Y[p], 1, complex64(complex(0, -ω[q])))
+		residuals[p] = norm(cf.t) / math.Abs(ω[q])
+	}
+
+	for i := range X {
+		X[i].Free()
+		LX[i].Free()
+	}
+	return Y, LY, freqs, residuals
+}
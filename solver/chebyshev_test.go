@@ -0,0 +1,44 @@
+package solver
+
+import (
+	"path/filepath"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestChebyshevFilter checks that the lowest modes found by the Chebyshev filter are found by RotatedToZ,
+// and that starting again from the checkpoint gives the same modes.
+func TestChebyshevFilter(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		checkpoint := filepath.Join(t.TempDir(), "block")
+		Solver = ChebyshevFilter{NEV: 6, Checkpoint: checkpoint}
+		valsB, vecsB := Modes()
+
+		if len(valsB) != 6 {
+			t.Fatalf("%d: found %d modes; want 6", test_idx, len(valsB))
+		}
+		err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions are not equal: %d%% error", test_idx, 100*err/len(valsB))
+		}
+
+		valsC, vecsC := Modes()
+		err = tests.EqualDecompositions(vecsB, vecsC, valsB, valsC, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions from the checkpoint are not equal: %d%% error", test_idx, 100*err/len(valsC))
+		}
+	}
+}
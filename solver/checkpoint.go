@@ -0,0 +1,207 @@
+package solver
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/field"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+var (
+	CHECKPOINT_EVERY = 10 // the number of restarts between checkpoints of the Krylov solvers.
+)
+
+// A krylovCheckpoint is the state of the Krylov–Schur iteration after the decomposition A V_m = V_{m+1} S has been expanded,
+// from which it can be resumed exactly. The locked, converged, pairs are the leading NLock columns of the decomposition,
+// and the residual vector is the last vector of V.
+type krylovCheckpoint struct {
+	Hash    string // the systemHash of the system, and the options, when the checkpoint was written.
+	Restart int
+	NLock   int
+	V       [][]float64
+	S       []float64
+}
+
+// krylovOptions are the options of the Krylov–Schur iteration, which a checkpoint must have been written with to be resumed.
+type krylovOptions struct {
+	N, NEV, Restart, Keep int
+	Lock, ShiftInvert     bool
+	Sigma                 float64
+	Tol                   float64
+	Operator              string // distinguishes the operators iterated by different solvers, such as a deflation of locked modes.
+}
+
+// systemHash returns a hash of the mesh, every parameter which enters the linear evolution and the ground state magnetisation,
+// along with the options, which identifies the eigenproblem that a checkpoint belongs to.
+// If s is nil it is the system set up in mumax, and otherwise the System s.
+// It panics with an *OptionError if an Interaction is registered, other than those of package mag, which is not a fmt.Stringer.
+func systemHash(s *mag.System, options interface{}) string {
+
+	h := sha256.New()
+	write := func(v interface{}) {
+		if err := binary.Write(h, binary.LittleEndian, v); err != nil {
+			panic(err)
+		}
+	}
+	writeSlice := func(sl *data.Slice) {
+		for _, c := range sl.Host() {
+			write(c)
+		}
+	}
+
+	if s != nil {
+		for c := 0; c < 3; c++ {
+			write(int64(s.Size[c]))
+			write(int64(s.PBC[c]))
+			write(s.CellSize[c])
+		}
+		write(s.GammaLL)
+		write(s.EnableDemag)
+		write(s.DemagAccuracy)
+		write(s.Damping)
+		for _, sl := range []*data.Slice{s.Msat, s.Alpha, s.Ku1, s.AnisU, s.BExt, s.M, s.Exchange} {
+			writeSlice(sl)
+		}
+		fmt.Fprintf(h, "%+v", options)
+		return fmt.Sprintf("%x", h.Sum(nil))
+	}
+
+	mesh := en.Mesh()
+	for c := 0; c < 3; c++ {
+		write(int64(mesh.Size()[c]))
+		write(int64(mesh.PBC()[c]))
+		write(mesh.CellSize()[c])
+	}
+	write(en.GammaLL)
+	write(en.EnableDemag)
+	write(en.DemagAccuracy)
+	write(Damping)
+	write(SpinTransfer)
+
+	for _, p := range []mag.Slicer{en.Msat, en.Alpha, en.Ku1, en.Ku2, en.AnisU, en.Kc1, en.Kc2, en.Kc3, en.AnisC1, en.AnisC2,
+		en.Dind, en.Dbulk, en.B1, en.B2, en.B_ext, en.J, en.Pol, en.Xi, en.Lambda, en.EpsilonPrime} {
+		writeSlice(mag.HostSlice(p))
+	}
+	writeSlice(mag.EngineExchange())
+	if vol := en.Geometry().Gpu(); vol != nil {
+		writeSlice(vol.HostCopy())
+	}
+	writeSlice(en.M.Buffer().HostCopy())
+
+	// the strain is not a parameter, so the magnetoelastic interaction enters by its field for a magnetisation along each axis.
+	size := en.MeshSize()
+	probe := cuda.NewSlice(3, size)
+	B := cuda.NewSlice(3, size)
+	for c := 0; c < 3; c++ {
+		cuda.Zero(probe)
+		cuda.Zero(B)
+		data.Copy(probe.Comp(c), mag.Uniform(size, 1))
+		mag.MagnetoelasticInteraction{}.AddField(B, probe)
+		writeSlice(B.HostCopy())
+	}
+	probe.Free()
+	B.Free()
+
+	B0 := field.GroundStateField()
+	write(B0.HostCopy().Host()[0])
+	B0.Free()
+
+	// the terms defined in this package, and the interactions registered. Those of package mag have no fields, and read the parameters above.
+	// Any other is hashed by its String method, as printing its fields would give the addresses of its pointers and maps,
+	// which differ between processes, so that a checkpoint would never be resumed.
+	names, interactions := mag.Interactions()
+	for r, i := range interactions {
+		switch i := i.(type) {
+		case mag.DemagInteraction, mag.ExchangeInteraction, mag.AnisotropyInteraction, mag.ZeemanInteraction,
+			mag.MagnetoelasticInteraction, mag.InterlayerInteraction, mag.SurfaceAnisotropyInteraction:
+			fmt.Fprintf(h, "%s %T;", names[r], i)
+		case fmt.Stringer:
+			fmt.Fprintf(h, "%s %T %s;", names[r], i, i.String())
+		default:
+			panic(&OptionError{Option: "Checkpoint", Reason: fmt.Sprintf("the interaction %q has no String method giving its parameters, so the checkpoint could not be matched to it", names[r])})
+		}
+	}
+	fmt.Fprintf(h, "%+v %+v %+v", mag.InterlayerCouplings, mag.SurfaceAnisotropies, mag.PinnedSurfaces)
+
+	fmt.Fprintf(h, "%+v", options)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// writeCheckpoint writes c to the file name with gob, by way of a temporary file, so that a checkpoint is never left half written.
+// As it is called during a solve, it panics with an *IOError if it fails, which ModesContext returns.
+func writeCheckpoint(name string, c interface{}) {
+
+	tmp := name + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		panic(&IOError{Op: "create", Path: tmp, Err: err})
+	}
+	err = gob.NewEncoder(f).Encode(c)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		panic(&IOError{Op: "write", Path: tmp, Err: err})
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		panic(&IOError{Op: "rename", Path: tmp, Err: err})
+	}
+}
+
+// readCheckpoint reads the checkpoint in the file name into c, and returns whether it exists and was written for the system and options
+// with the given hash. A checkpoint for a different system is not used.
+func readCheckpoint(name, hash string, c *krylovCheckpoint) bool {
+
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	if err := gob.NewDecoder(f).Decode(c); err != nil {
+		util.Log(fmt.Sprintf("The checkpoint %s could not be read, so it is not resumed: %v", name, err))
+		return false
+	}
+	if c.Hash != hash {
+		util.Log(fmt.Sprintf("The checkpoint %s is for a different system or options, so it is not resumed.", name))
+		return false
+	}
+	return true
+}
+
+// hashFile is the file in a checkpoint directory of modes, written by WriteModes, which holds the systemHash they were written for.
+const hashFile = "system.hash"
+
+// writeModesCheckpoint writes the modes to the directory name with WriteModes, with the hash of the system.
+func writeModesCheckpoint(frequencies []float64, modes []CSlice, name, hash string) {
+	if err := WriteModes(frequencies, modes, name); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filepath.Join(name, hashFile), []byte(hash), 0644); err != nil {
+		panic(&IOError{Op: "write", Path: filepath.Join(name, hashFile), Err: err})
+	}
+}
+
+// modesCheckpointMatches returns whether the directory name holds modes written for the system with the given hash.
+func modesCheckpointMatches(name, hash string) bool {
+	saved, err := os.ReadFile(filepath.Join(name, hashFile))
+	if err != nil {
+		return false
+	}
+	if string(saved) != hash {
+		util.Log(fmt.Sprintf("The checkpoint %s is for a different system, so it is not resumed.", name))
+		return false
+	}
+	return true
+}
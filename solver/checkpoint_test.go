@@ -0,0 +1,130 @@
+package solver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mumax/3/cuda"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestCheckpoint checks that Krylov–Schur and ArnoldiField, stopped and resumed from their checkpoints, find the modes found by RotatedToZ,
+// and that a checkpoint is not resumed for a different ground state or exchange stiffness, nor hashed by the addresses held by an interaction.
+func TestCheckpoint(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	every := CHECKPOINT_EVERY
+	CHECKPOINT_EVERY = 1
+	defer func() { CHECKPOINT_EVERY = every }()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		checkpoint := filepath.Join(t.TempDir(), "krylovschur")
+		last := -1
+		Solver = KrylovSchur{NEV: 6, Restart: 10, Checkpoint: checkpoint, Monitor: func(restart int, _ []complex128, _ []float64) bool {
+			last = restart
+			return restart == 2
+		}}
+		Modes()
+		if _, err := os.Stat(checkpoint); err != nil {
+			t.Fatalf("%d: no checkpoint was written: %v", test_idx, err)
+		}
+
+		first := -1
+		Solver = KrylovSchur{NEV: 6, Restart: 10, Checkpoint: checkpoint, Monitor: func(restart int, _ []complex128, _ []float64) bool {
+			if first < 0 {
+				first = restart
+			}
+			return false
+		}}
+		valsB, vecsB := Modes()
+		if first != last {
+			t.Errorf("%d: resumed at restart %d; want %d", test_idx, first, last)
+		}
+		err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions resumed by Krylov–Schur are not equal: %d%% error", test_idx, 100*err/len(valsB))
+		}
+
+		checkpoint = filepath.Join(t.TempDir(), "arnoldi")
+		Solver = ArnoldiField{NEV: 6, Checkpoint: checkpoint}
+		valsC, vecsC := Modes()
+		valsD, vecsD := Modes()
+		err = tests.EqualSubDecompositions(vecsA, vecsC, valsA, valsC, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions of ArnoldiField are not equal: %d%% error", test_idx, 100*err/len(valsC))
+		}
+		err = tests.EqualSubDecompositions(vecsA, vecsD, valsA, valsD, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions resumed by ArnoldiField are not equal: %d%% error", test_idx, 100*err/len(valsD))
+		}
+
+		// reversing the ground state gives a different system, whose checkpoints are not resumed.
+		hash := systemHash(nil, nil)
+		cuda.Scale(en.M.Buffer(), en.M.Buffer(), -1)
+		if systemHash(nil, nil) == hash {
+			t.Errorf("%d: the hash does not depend on the ground state", test_idx)
+		}
+		var saved krylovCheckpoint
+		if readCheckpoint(checkpoint, systemHash(nil, nil), &saved) {
+			t.Errorf("%d: the checkpoint was resumed for a different ground state", test_idx)
+		}
+		cuda.Scale(en.M.Buffer(), en.M.Buffer(), -1)
+
+		// as does changing the exchange stiffness, which need not change the ground state field.
+		hash = systemHash(nil, nil)
+		aex := en.Aex.GetRegion(0)
+		en.Aex.Set(aex + 1e-12)
+		if systemHash(nil, nil) == hash {
+			t.Errorf("%d: the hash does not depend on the exchange stiffness", test_idx)
+		}
+		en.Aex.Set(aex)
+
+		// an interaction registered by the user is hashed by its String method, not by the addresses it holds,
+		// and one without a String method cannot be checkpointed.
+		mag.Register("scaled zeeman", &scaledZeeman{scale: new(float64)})
+		var hashErr error
+		func() {
+			defer func() { Catch(recover(), &hashErr) }()
+			systemHash(nil, nil)
+		}()
+		var optionErr *OptionError
+		if !errors.As(hashErr, &optionErr) {
+			t.Errorf("%d: hashing an interaction without a String method returned %v", test_idx, hashErr)
+		}
+		mag.Register("scaled zeeman", &stringedZeeman{scaledZeeman{scale: new(float64)}})
+		hash = systemHash(nil, nil)
+		mag.Register("scaled zeeman", &stringedZeeman{scaledZeeman{scale: new(float64)}})
+		if systemHash(nil, nil) != hash {
+			t.Errorf("%d: the hash depends on the addresses held by an interaction", test_idx)
+		}
+		mag.Unregister("scaled zeeman")
+	}
+}
+
+// scaledZeeman is an interaction holding a pointer, whose address differs between instances with the same parameters.
+type scaledZeeman struct {
+	mag.ZeemanInteraction
+	scale *float64
+}
+
+type stringedZeeman struct {
+	scaledZeeman
+}
+
+func (z *stringedZeeman) String() string {
+	return fmt.Sprint(*z.scale)
+}
@@ -1,7 +1,7 @@
 package solver
 
 import (
-	"fmt"
+	. "github.com/will-henderson/mumax-vhf/data"
 )
 
 //#cgo CFLAGS: -I /home/will/amd/aocl/4.0/include_LP64
@@ -11,16 +11,20 @@ import (
 import "C"
 
 // Cholesky returns the cholesky decomposition of a matrix mat, and returns the lower triangular part by overwriting the input.
-func Cholesky(mat []float64) {
+// It returns a *BackendError if LAPACK fails, as it does if mat is not positive definite.
+func Cholesky(mat []float64) error {
 	info := C.cholesky(C.int(len(mat)), (*C.double)(&mat[0]))
 	if info != 0 {
-		panic(fmt.Sprintf("dpotrf failed: info = %d", info))
+		return &BackendError{Routine: "dpotrf", Info: int(info)}
 	}
+	return nil
 }
 
-func TriInv(mat []float64) {
+// TriInv inverts the lower triangular matrix mat, overwriting it. It returns a *BackendError if LAPACK fails.
+func TriInv(mat []float64) error {
 	info := C.triinv(C.int(len(mat)), (*C.double)(&mat[0]))
 	if info != 0 {
-		panic(fmt.Sprintf("dtrtri failed: info = %d", info))
+		return &BackendError{Routine: "dtrtri", Info: int(info)}
 	}
+	return nil
 }
@@ -33,12 +33,19 @@ func (solver CholeskyFirst) Solve(t Tensor) ([]float64, []CSlice) {
 	twoD := rotated.XY()
 	arr := twoD.To1D()
 
-	Cholesky(arr)
-	TriInv(arr)
+	if err := Cholesky(arr); err != nil {
+		panic(err)
+	}
+	if err := TriInv(arr); err != nil {
+		panic(err)
+	}
 	LT := From1D(arr, 2, twoD.Size)
 	DynamicOperateRotated(LT)
 
-	values, vectors := Eig(3*twoD.Length(), arr)
+	values, vectors, err := Eig(3*twoD.Length(), arr)
+	if err != nil {
+		panic(err)
+	}
 
 	totalSize := 2 * twoD.Length()
 	freq := make([]float64, totalSize)
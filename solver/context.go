@@ -0,0 +1,288 @@
+package solver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// ContextEigenSolver is an interface which wraps the ModesContext method, a variant of Modes which can be cancelled, reports its progress,
+// and returns errors rather than panicking.
+// ModesContext stops with the error of the context when it is cancelled or its deadline passes, checking between applications
+// of the linear evolution. Solvers which diagonalise a dense matrix in one call to LAPACK only check before they start.
+// If the context was made by WithProgress, the solver reports its progress as it iterates.
+// The typed errors of the data package which the solver panics with are returned: an *OptionError for invalid options,
+// a *SizeError, a *BackendError if LAPACK or ARPACK fails, and an *IOError if a checkpoint cannot be written.
+// If the solver stops before all the modes it seeks have converged, it returns those that have along with a *ConvergenceError.
+type ContextEigenSolver interface {
+	ModesContext(ctx context.Context) ([]float64, []CSlice, error)
+}
+
+// Progress is reported by the iterative solvers during ModesContext.
+type Progress struct {
+	// Iteration counts the iterations so far, as the solver counts them: restarts for KrylovSchur, block iterations for LOBPCG and ChebyshevFilter,
+	// steps for the Lanczos solvers, and applications of the operator for the solvers using ARPACK.
+	Iteration int
+
+	Converged int // the number of modes converged so far, or -1 if the solver does not know until it finishes.
+
+	// Ritz holds the current Ritz values of the operator which the solver iterates, best first, as for the Monitor of KrylovSchur.
+	// For LOBPCG these are the eigenvalues μ = -1/ω of its pencil, and for ChebyshevFilter the frequencies. It is nil for the Lanczos solvers.
+	Ritz []complex128
+
+	Elapsed time.Duration
+}
+
+type progressKey struct{}
+
+// WithProgress returns a copy of ctx with which ModesContext calls report with the Progress of the solver.
+// The report is called on the goroutine of the solver, so it should be quick; it can send the Progress on a channel.
+func WithProgress(ctx context.Context, report func(Progress)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// ModesContext returns the eigenfrequencies and eigenmodes from the current Solver, which must implement ContextEigenSolver.
+func ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	contextSolver, ok := Solver.(ContextEigenSolver)
+	if !ok {
+		return nil, nil, &OptionError{Option: "solver", Reason: "it cannot be cancelled"}
+	}
+	return contextSolver.ModesContext(ctx)
+}
+
+// a solve is a run of ModesContext, watched by the solvers through the package variable running.
+// Like mumax, the solvers use global state, so only one runs at a time.
+// running, and the err of the solve it points to, are guarded by runningMu, as ModesContext may be called from any goroutine.
+type solve struct {
+	ctx    context.Context
+	report func(Progress)
+	start  time.Time
+	err    error // the first *ConvergenceError reported by notConverged.
+}
+
+var (
+	running   *solve
+	runningMu sync.Mutex
+)
+
+// current returns the solve which is running, or nil if there is none.
+func current() *solve {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	return running
+}
+
+// cancelled is panicked with by interrupt to unwind the solver, freeing what it has allocated with its deferred calls,
+// and recovered by modesContext.
+type cancelled struct {
+	err error
+}
+
+// ComplexModesContext returns the complex eigenfrequencies and eigenmodes from the current Solver, which must implement ComplexEigenSolver,
+// stopping if ctx is done and returning the errors the solver panics with, as ModesContext does.
+func ComplexModesContext(ctx context.Context) (freqs []complex128, vecs []CSlice, err error) {
+	complexSolver, ok := Solver.(ComplexEigenSolver)
+	if !ok {
+		return nil, nil, &OptionError{Option: "solver", Reason: "it does not return complex frequencies"}
+	}
+	err = runContext(ctx, func() { freqs, vecs = complexSolver.ComplexModes() })
+	return freqs, vecs, err
+}
+
+// A TensorSolver finds the eigenpairs of a given eigenproblem tensor, with the Solve method of the dense solvers.
+// Solve is Must-style, and panics with the errors which SolveContext returns.
+type TensorSolver interface {
+	Solve(t Tensor) ([]float64, []CSlice)
+}
+
+// A ComplexTensorSolver finds the eigenpairs of a given eigenproblem tensor with complex frequencies, with the SolveComplex method of the dense solvers.
+// SolveComplex is Must-style, and panics with the errors which SolveComplexContext returns.
+type ComplexTensorSolver interface {
+	SolveComplex(t Tensor) ([]complex128, []CSlice)
+}
+
+// SolveContext returns the eigenpairs of the tensor t from the current Solver, which must implement TensorSolver,
+// returning the errors the solver panics with, as ModesContext does.
+func SolveContext(ctx context.Context, t Tensor) (freqs []float64, vecs []CSlice, err error) {
+	tensorSolver, ok := Solver.(TensorSolver)
+	if !ok {
+		return nil, nil, &OptionError{Option: "solver", Reason: "it does not solve a given tensor"}
+	}
+	err = runContext(ctx, func() { freqs, vecs = tensorSolver.Solve(t) })
+	return freqs, vecs, err
+}
+
+// SolveComplexContext returns the eigenpairs of the tensor t, with complex frequencies, from the current Solver, which must implement ComplexTensorSolver,
+// returning the errors the solver panics with, as ModesContext does.
+func SolveComplexContext(ctx context.Context, t Tensor) (freqs []complex128, vecs []CSlice, err error) {
+	tensorSolver, ok := Solver.(ComplexTensorSolver)
+	if !ok {
+		return nil, nil, &OptionError{Option: "solver", Reason: "it does not solve a given tensor with complex frequencies"}
+	}
+	err = runContext(ctx, func() { freqs, vecs = tensorSolver.SolveComplex(t) })
+	return freqs, vecs, err
+}
+
+// SliceContext solves each window in turn, as Slice does, stopping if ctx is done and returning the errors the windows panic with.
+func (solver SpectrumSlicing) SliceContext(ctx context.Context) (windows []SliceWindow, err error) {
+	err = runContext(ctx, func() { windows = solver.Slice() })
+	return windows, err
+}
+
+// RunContext performs the sweep, as Run does, stopping if ctx is done and returning the errors the steps panic with.
+func (s Sweep) RunContext(ctx context.Context) (branches []Branch, err error) {
+	err = runContext(ctx, func() { branches = s.Run() })
+	return branches, err
+}
+
+// EstimateModeCountContext returns an estimate of the number of modes with frequency in [fMin, fMax), as EstimateModeCount does,
+// stopping if ctx is done and returning the errors the GMRES solves panic with.
+func EstimateModeCountContext(ctx context.Context, fMin, fMax float64, seed int64) (count int, err error) {
+	err = runContext(ctx, func() { count = EstimateModeCount(fMin, fMax, seed) })
+	return count, err
+}
+
+// modesContext runs modes, the Modes method of a solver, stopping it if ctx is done.
+func modesContext(ctx context.Context, modes func() ([]float64, []CSlice)) (freqs []float64, vecs []CSlice, err error) {
+	err = runContext(ctx, func() { freqs, vecs = modes() })
+	return freqs, vecs, err
+}
+
+// runContext runs f as a solve, stopping it if ctx is done. It returns the error of ctx, or one of the typed errors of the data package
+// which f panics with, in which case the results f was to set are left unset. Otherwise it returns the *ConvergenceError reported
+// by notConverged, if there was one, with the results f set.
+func runContext(ctx context.Context, f func()) (err error) {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	report, _ := ctx.Value(progressKey{}).(func(Progress))
+	s := &solve{ctx: ctx, report: report, start: time.Now()}
+
+	runningMu.Lock()
+	if running != nil {
+		runningMu.Unlock()
+		return &OptionError{Option: "ModesContext", Reason: "another solve is already running"}
+	}
+	running = s
+	runningMu.Unlock()
+
+	defer func() {
+		runningMu.Lock()
+		running = nil
+		runningMu.Unlock()
+		r := recover()
+		if c, ok := r.(cancelled); ok {
+			err = c.err
+			return
+		}
+		Catch(r, &err)
+	}()
+
+	f()
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	return s.err
+}
+
+// notConverged records that the solver stopped before all the modes it sought had converged, for ModesContext to return.
+func notConverged(e *ConvergenceError) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if running != nil && running.err == nil {
+		running.err = e
+	}
+}
+
+// interrupt stops the solver if the context of ModesContext is done. It is called between applications of the operator.
+func interrupt() {
+	s := current()
+	if s == nil {
+		return
+	}
+	select {
+	case <-s.ctx.Done():
+		panic(cancelled{s.ctx.Err()})
+	default:
+	}
+}
+
+// progress reports the progress of the solver, if it is running in ModesContext with WithProgress.
+func progress(iteration, converged int, ritz []complex128) {
+	s := current()
+	if s == nil || s.report == nil {
+		return
+	}
+	s.report(Progress{
+		Iteration: iteration,
+		Converged: converged,
+		Ritz:      append([]complex128(nil), ritz...),
+		Elapsed:   time.Since(s.start),
+	})
+}
+
+func (solver StraightGonum) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver Straight) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver RotatedToZ) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver CholeskyFirst) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver TwoSublattice) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver ArnoldiMatrix) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver ArnoldiField) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver ArnoldiFieldUnrotated) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver ArnoldiField2) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver *ArnoldiFieldTimes) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver KrylovSchur) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver SpectrumSlicing) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver LanczosMatrix) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver LanczosField) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver LOBPCG) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
+
+func (solver ChebyshevFilter) ModesContext(ctx context.Context) ([]float64, []CSlice, error) {
+	return modesContext(ctx, solver.Modes)
+}
@@ -0,0 +1,99 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestModesContext checks that the iterative solvers report their progress and find the modes found by RotatedToZ,
+// and that they stop when the context is cancelled, after which they can solve again.
+// SpectrumSlicing is cancelled within a window, and must return the error rather than crash,
+// and the Context variants of ComplexModes, Solve, Slice and EstimateModeCount return errors rather than panic.
+func TestModesContext(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, _, err := (KrylovSchur{NEV: 6}).ModesContext(cancelled); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d: solving with a cancelled context returned %v", test_idx, err)
+		}
+
+		// a SpectrumSlicing cancelled during a window returns the error too.
+		fMax := 0.
+		for _, f := range valsA {
+			fMax = math.Max(fMax, f)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		ctx = WithProgress(ctx, func(Progress) { cancel() })
+		if _, _, err := (SpectrumSlicing{FMax: fMax, Windows: 2}).ModesContext(ctx); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d: SpectrumSlicing cancelled returned %v", test_idx, err)
+		}
+
+		// the Context variants of the panicking functions return the errors instead.
+		var optionErr *OptionError
+		if _, err := (SpectrumSlicing{}).SliceContext(context.Background()); !errors.As(err, &optionErr) {
+			t.Errorf("%d: SliceContext without windows returned %v", test_idx, err)
+		}
+		if _, err := EstimateModeCountContext(cancelled, 0, fMax, 1); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d: EstimateModeCountContext cancelled returned %v", test_idx, err)
+		}
+		Solver = KrylovSchur{NEV: 6}
+		if _, _, err := ComplexModesContext(cancelled); !errors.Is(err, context.Canceled) {
+			t.Errorf("%d: ComplexModesContext cancelled returned %v", test_idx, err)
+		}
+		Solver = LOBPCG{NEV: 6}
+		if _, _, err := ComplexModesContext(context.Background()); !errors.As(err, &optionErr) {
+			t.Errorf("%d: ComplexModesContext with LOBPCG returned %v", test_idx, err)
+		}
+		if _, _, err := SolveContext(context.Background(), Tensor{}); !errors.As(err, &optionErr) {
+			t.Errorf("%d: SolveContext with LOBPCG returned %v", test_idx, err)
+		}
+
+		for _, solver := range []ContextEigenSolver{KrylovSchur{NEV: 6}, ArnoldiField{NEV: 6}, LOBPCG{NEV: 6}} {
+
+			var reports []Progress
+			ctx := WithProgress(context.Background(), func(p Progress) { reports = append(reports, p) })
+			valsB, vecsB, err := solver.ModesContext(ctx)
+			if err != nil {
+				t.Fatalf("%d: %T returned %v", test_idx, solver, err)
+			}
+			if len(reports) == 0 {
+				t.Errorf("%d: %T reported no progress", test_idx, solver)
+			}
+			for i := 1; i < len(reports); i++ {
+				if reports[i].Iteration <= reports[i-1].Iteration || reports[i].Elapsed < reports[i-1].Elapsed {
+					t.Errorf("%d: %T reported progress out of order", test_idx, solver)
+					break
+				}
+			}
+			err_ := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+			if err_ > 0 {
+				t.Errorf("%d: Decompositions of %T are not equal: %d%% error", test_idx, solver, 100*err_/len(valsB))
+			}
+
+			// cancelling at the first report stops the solver, unless it had already converged by then.
+			if len(reports) > 1 {
+				ctx, cancel := context.WithCancel(context.Background())
+				ctx = WithProgress(ctx, func(Progress) { cancel() })
+				if _, _, err := solver.ModesContext(ctx); !errors.Is(err, context.Canceled) {
+					t.Errorf("%d: %T cancelled returned %v", test_idx, solver, err)
+				}
+			}
+		}
+	}
+}
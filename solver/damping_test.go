@@ -0,0 +1,58 @@
+package solver
+
+import (
+	"sort"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestLinewidths checks that for weak damping the decay rates of the damped eigenproblem agree with the
+// first order estimate from the undamped modes, and that the frequencies are unchanged.
+func TestLinewidths(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+	defer func() { Damping = false }()
+
+	for test_idx, s := range testcases {
+
+		Setup(s)
+		en.Relax()
+		en.Alpha.Set(1e-3)
+
+		Damping = false
+		Solver = new(RotatedToZ)
+		vals, vecs := Modes()
+		Γ := Linewidths(vals, vecs)
+
+		Damping = true
+		cvals, _ := ComplexModes()
+
+		if len(cvals) != len(vals) {
+			t.Fatalf("%d: %d damped modes; want %d", test_idx, len(cvals), len(vals))
+		}
+
+		undamped := make([]int, len(vals))
+		damped := make([]int, len(cvals))
+		for i := range vals {
+			undamped[i] = i
+			damped[i] = i
+		}
+		sort.Slice(undamped, func(i, j int) bool { return vals[undamped[i]] < vals[undamped[j]] })
+		sort.Slice(damped, func(i, j int) bool { return real(cvals[damped[i]]) < real(cvals[damped[j]]) })
+
+		err := 0
+		for i := range vals {
+			if tests.EqualScalars(vals[undamped[i]], real(cvals[damped[i]]), 1e-3) > 0 ||
+				tests.EqualScalars(Γ[undamped[i]], imag(cvals[damped[i]]), 1e-2) > 0 {
+				err++
+			}
+		}
+		if err > 0 {
+			t.Errorf("%d: Linewidths are not equal: %d%% error", test_idx, 100*err/len(vals))
+		}
+	}
+}
@@ -1,7 +1,7 @@
 package solver
 
 import (
-	"fmt"
+	. "github.com/will-henderson/mumax-vhf/data"
 )
 
 //#cgo CFLAGS: -I /home/will/amd/aocl/4.0/include_LP64
@@ -11,8 +11,8 @@ import (
 import "C"
 
 // Eig returns the eigenvalues and eigenvectors of a matrix mat, which passed in a flattened to row major form.
-// Note that mat is overwritten during the routine.
-func Eig(n int, mat []float64) ([]complex128, [][]complex128) {
+// Note that mat is overwritten during the routine. It returns a *BackendError if LAPACK fails.
+func Eig(n int, mat []float64) ([]complex128, [][]complex128, error) {
 	wr := make([]float64, n)
 	wi := make([]float64, n)
 	V := make([]float64, n*n)
@@ -27,10 +27,11 @@ func Eig(n int, mat []float64) ([]complex128, [][]complex128) {
 		(*C.double)(&mat[0]), nC, (*C.double)(&wr[0]), (*C.double)(&wi[0]), nil, nC, (*C.double)(&V[0]), nC)
 
 	if info != 0 {
-		panic(fmt.Sprintf("dgeev failed: info = %d", info))
+		return nil, nil, &BackendError{Routine: "dgeev", Info: int(info)}
 	}
 
-	return GeevToCmplx(n, wr, wi, V)
+	values, vectors := GeevToCmplx(n, wr, wi, V)
+	return values, vectors, nil
 
 }
 
@@ -1,4 +1,9 @@
 // Package solver contains structs with a Modes function to calculate the eigenmodes and corresponding eigenfrequencies of the system.
+//
+// The functions which return an error, ModesContext, ComplexModesContext, SolveContext, SolveComplexContext, SliceContext,
+// RunContext and EstimateModeCountContext, are the API for programs which handle the errors. Modes, ComplexModes, the Modes,
+// ComplexModes and Solve methods of the solvers, Slice, Sweep.Run and EstimateModeCount are their Must-style forms, like
+// regexp.MustCompile: they panic with the same typed errors of the data package, for scripts in which any error is fatal.
 package solver
 
 import (
@@ -10,8 +15,8 @@ import (
 	en "github.com/mumax/3/engine"
 	"github.com/mumax/3/httpfs"
 	"github.com/mumax/3/oommf"
-	"github.com/mumax/3/util"
 	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
 // EigenSolver is an interface which wraps the Modes method.
@@ -19,10 +24,19 @@ import (
 // Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
 // and assumes that the ground state magnetisation is currently stored in en.M.
 // It returns 2 * Nx * Ny * Nz eigenpairs (zero eigenfrequencies are ignored)
+// Modes is Must-style: it panics with the typed errors which ModesContext of a ContextEigenSolver returns.
 type EigenSolver interface {
 	Modes() ([]float64, []CSlice) //returns real eigenvalues and the eigenvectors
 }
 
+// ComplexEigenSolver is an interface which wraps the ComplexModes method, for solvers that can solve the non-Hermitian
+// eigenproblem which results from including Damping.
+// ComplexModes returns complex eigenfrequencies ω + iΓ, where Γ is the decay rate of the mode, and the corresponding eigenmodes.
+// Like Modes it panics with its errors; ComplexModesContext returns them.
+type ComplexEigenSolver interface {
+	ComplexModes() ([]complex128, []CSlice)
+}
+
 // Types implementing the EigenSolver interface should extend the eigenSolver struct.
 // This will allow convience methods to be added to all solvers in the future.
 type eigenSolver struct{}
@@ -31,25 +45,75 @@ var (
 	Solver EigenSolver
 )
 
-func SetSolver(solverName string) {
+// SetSolver sets the Solver by its name, or returns an *OptionError if the name is not known.
+func SetSolver(solverName string) error {
 	switch solverName {
 	case "StraightGonum":
 		Solver = new(StraightGonum)
 	case "RotatedToZ":
 		Solver = new(RotatedToZ)
 	default:
-		panic("solver not known")
+		return &OptionError{Option: "solver", Reason: fmt.Sprintf("%q is not known", solverName)}
 	}
+	return nil
 }
 
+// Modes returns the eigenfrequencies and eigenmodes from the current Solver.
+// It is the Must-style form of ModesContext, and panics with the error which that would return.
 func Modes() ([]float64, []CSlice) {
 	return Solver.Modes()
 }
 
-func WriteModes(frequencies []float64, modes []CSlice, name string) {
-	//probably want to make a directory
+// ComplexModes returns the complex eigenfrequencies and eigenmodes from the current Solver, which must implement ComplexEigenSolver.
+// It is the Must-style form of ComplexModesContext, and panics with the error which that would return.
+func ComplexModes() ([]complex128, []CSlice) {
+	complexSolver, ok := Solver.(ComplexEigenSolver)
+	if !ok {
+		panic(&OptionError{Option: "solver", Reason: "it does not return complex frequencies"})
+	}
+	return complexSolver.ComplexModes()
+}
+
+// ValidateSolver returns the features of the system set up in mumax which the current Solver does not model; see mag.Validate.
+// A Solver which does not model some of the features the others do, such as TwoSublattice with the mag.PinnedSurfaces, reports them itself.
+func ValidateSolver() mag.UnsupportedFeatures {
+	features := mag.Validate()
+	if s, ok := Solver.(limitedSolver); ok {
+		features = append(features, s.unsupported()...)
+	}
+	return features
+}
+
+// A limitedSolver does not model some of the features set up in mumax which are supported by the other solvers.
+// It should be implemented with a value receiver, so that it is found whether the Solver is set to a value or a pointer.
+type limitedSolver interface {
+	unsupported() mag.UnsupportedFeatures
+}
+
+// complexFrequency returns the complex frequency ω + iΓ of a mode evolving as exp(λt), for an eigenvalue λ of the linear evolution.
+func complexFrequency(λ complex128) complex128 {
+	return complex(imag(λ), -real(λ))
+}
+
+// realFrequencies discards the decay rates from complex frequencies.
+func realFrequencies(freqs []complex128, modes []CSlice) ([]float64, []CSlice) {
+	realFreqs := make([]float64, len(freqs))
+	for i, f := range freqs {
+		realFreqs[i] = real(f)
+	}
+	return realFreqs, modes
+}
 
-	os.Mkdir(name, os.ModePerm)
+// WriteModes writes the modes, with their frequencies, to the directory name, as the ovf files i_real.ovf and i_imag.ovf for mode i.
+// It returns an *OptionError if there is not a frequency for each mode, and an *IOError if they cannot be written.
+func WriteModes(frequencies []float64, modes []CSlice, name string) error {
+
+	if len(frequencies) != len(modes) {
+		return &OptionError{Option: "modes", Reason: "there is not a frequency for each mode"}
+	}
+	if err := os.Mkdir(name, os.ModePerm); err != nil && !os.IsExist(err) {
+		return &IOError{Op: "mkdir", Path: name, Err: err}
+	}
 
 	for i := 0; i < len(frequencies); i++ {
 
@@ -58,20 +122,67 @@ func WriteModes(frequencies []float64, modes []CSlice, name string) {
 			CellSize: en.Mesh().CellSize()}
 
 		fname := filepath.Join(name, fmt.Sprintf("%d_real.ovf", i))
-		f, err := httpfs.Create(fname)
-		util.FatalErr(err)
-		oommf.WriteOVF2(f, modes[i].Real(), info, "binary 4")
-		f.Close()
+		if err := writeOVF(fname, modes[i].Real(), info); err != nil {
+			return err
+		}
 
 		//write the real part
 		info = data.Meta{Time: frequencies[i], Name: "Imag Eigenmode", Unit: "1",
 			CellSize: en.Mesh().CellSize()}
 
 		fname = filepath.Join(name, fmt.Sprintf("%d_imag.ovf", i))
-		f, err = httpfs.Create(fname)
-		util.FatalErr(err)
-		oommf.WriteOVF2(f, modes[i].Imag(), info, "binary 4")
-		f.Close()
+		if err := writeOVF(fname, modes[i].Imag(), info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeOVF writes the slice s to the file fname in the OVF2 format.
+func writeOVF(fname string, s *data.Slice, info data.Meta) error {
+	f, err := httpfs.Create(fname)
+	if err != nil {
+		return &IOError{Op: "create", Path: fname, Err: err}
+	}
+	oommf.WriteOVF2(f, s, info, "binary 4")
+	if err := f.Close(); err != nil {
+		return &IOError{Op: "write", Path: fname, Err: err}
+	}
+	return nil
+}
+
+// ReadModes reads the modes written to the directory name by WriteModes, with their frequencies.
+// The modes are returned on the CPU. It returns an *IOError if they cannot be read.
+func ReadModes(name string) ([]float64, []CSlice, error) {
+
+	var frequencies []float64
+	var modes []CSlice
+
+	for i := 0; ; i++ {
+
+		fname := filepath.Join(name, fmt.Sprintf("%d_real.ovf", i))
+		if _, err := os.Stat(fname); err != nil {
+			break
+		}
+		real, info, err := oommf.ReadFile(fname)
+		if err != nil {
+			return nil, nil, &IOError{Op: "read", Path: fname, Err: err}
+		}
+
+		fname = filepath.Join(name, fmt.Sprintf("%d_imag.ovf", i))
+		imag, _, err := oommf.ReadFile(fname)
+		if err != nil {
+			return nil, nil, &IOError{Op: "read", Path: fname, Err: err}
+		}
+
+		mode, err := CSliceFromParts(real, imag)
+		if err != nil {
+			return nil, nil, err
+		}
+		frequencies = append(frequencies, info.Time)
+		modes = append(modes, mode)
 	}
 
+	return frequencies, modes, nil
 }
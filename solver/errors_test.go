@@ -0,0 +1,47 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestErrors checks that invalid options and inputs are returned as the typed errors of the data package rather than panicking,
+// and that a solve which fails this way does not stop the next one.
+func TestErrors(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	var optionErr *OptionError
+	if err := SetSolver("no such solver"); !errors.As(err, &optionErr) {
+		t.Errorf("SetSolver with an unknown name returned %v", err)
+	}
+
+	var ioErr *IOError
+	if err := WriteModes([]float64{1}, []CSlice{NewCSliceCPU(3, [3]int{1, 1, 1})}, filepath.Join(t.TempDir(), "missing", "modes")); !errors.As(err, &ioErr) {
+		t.Errorf("WriteModes to a missing directory returned %v", err)
+	}
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		if _, _, err := (KrylovSchur{NEV: 1 << 30}).ModesContext(context.Background()); !errors.As(err, &optionErr) {
+			t.Errorf("%d: KrylovSchur with too many eigenvalues returned %v", test_idx, err)
+		}
+
+		if _, err := (JacobiDavidson{}).Refine([]complex128{1}, nil); !errors.As(err, &optionErr) {
+			t.Errorf("%d: Refine without a mode for each frequency returned %v", test_idx, err)
+		}
+
+		if _, _, err := (KrylovSchur{NEV: 2}).ModesContext(context.Background()); err != nil {
+			t.Errorf("%d: KrylovSchur after a failed solve returned %v", test_idx, err)
+		}
+	}
+}
@@ -29,7 +29,9 @@ func TestFM(t *testing.T) {
 	Solver = new(RotatedToZ)
 	vals, vecs := Modes()
 
-	WriteModes(vals, vecs, "direct.out")
+	if err := WriteModes(vals, vecs, "direct.out"); err != nil {
+		t.Fatal(err)
+	}
 
 	en.SaveAs(&en.M, "gs")
 
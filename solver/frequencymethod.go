@@ -2,6 +2,8 @@ package solver
 
 import (
 	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
 )
 
 type FrequencyMethod struct {
@@ -10,8 +12,10 @@ type FrequencyMethod struct {
 
 func (fm FrequencyMethod) evolve(δ float64, timesteps int) {
 
-	//set alpha equal to zero as we don't want to damp.
-	en.Alpha.Set(0)
+	//set alpha equal to zero as we don't want to damp, unless the damping is part of the problem.
+	if !Damping {
+		en.Alpha.Set(0)
+	}
 
 	//I think just evolving normally is the same as acting with my matrix.
 
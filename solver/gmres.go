@@ -0,0 +1,140 @@
+package solver
+
+import (
+	"math"
+	"math/cmplx"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+var (
+	GMRES_RESTART = 30   // the dimension of the Krylov subspace before GMRES restarts.
+	GMRES_TOL     = 1e-5 // the residual, relative to the right hand side, at which GMRES has converged.
+	GMRES_MAXITER = 1000 // the maximum number of GMRES iterations in a solve.
+)
+
+// gmres solves a x = b by restarted GMRES with right preconditioning by precond, which approximates a⁻¹.
+// a and precond set dst from src, and all the slices live on the GPU with the same shape.
+// x holds the initial guess, and is overwritten by the solution. It returns the number of iterations and the final relative residual.
+func gmres(a, precond func(dst, src CSlice), x, b CSlice, restart, maxIter int, tol float64) (int, float64) {
+
+	nComp := b.NComp()
+	size := b.Size()
+
+	r := NewCSlice(nComp, size)
+	defer r.Free()
+	w := NewCSlice(nComp, size)
+	defer w.Free()
+	z := NewCSlice(nComp, size)
+	defer z.Free()
+
+	V := make([]CSlice, restart+1)
+	for i := range V {
+		V[i] = NewCSlice(nComp, size)
+		defer V[i].Free()
+	}
+
+	bNorm := norm(b)
+	if bNorm == 0 {
+		Zero(x)
+		return 0, 0
+	}
+
+	iter := 0
+	residual := math.Inf(1)
+
+	for iter < maxIter {
+
+		// r = b - a x
+		a(r, x)
+		SMadd2(r, b, r, 1, -1)
+		β := norm(r)
+		residual = β / bNorm
+		if residual < tol {
+			break
+		}
+		SScal(V[0], r, float32(1/β))
+
+		H := make([][]complex128, restart+1) // H[i][j], the upper Hessenberg matrix.
+		for i := range H {
+			H[i] = make([]complex128, restart)
+		}
+		cs := make([]float64, restart)
+		sn := make([]complex128, restart)
+		g := make([]complex128, restart+1)
+		g[0] = complex(β, 0)
+
+		j := 0
+		for ; j < restart && iter < maxIter; j++ {
+			iter++
+
+			precond(z, V[j])
+			a(w, z)
+
+			// modified Gram-Schmidt.
+			for i := 0; i <= j; i++ {
+				H[i][j] = complex128(Dotc(V[i], w))
+				CMadd2(w, w, V[i], 1, complex64(-H[i][j]))
+			}
+			h := norm(w)
+			H[j+1][j] = complex(h, 0)
+			if h != 0 {
+				SScal(V[j+1], w, float32(1/h))
+			}
+
+			// apply the previous rotations, and then the new one which eliminates H[j+1][j].
+			for i := 0; i < j; i++ {
+				H[i][j], H[i+1][j] = applyGivens(cs[i], sn[i], H[i][j], H[i+1][j])
+			}
+			cs[j], sn[j] = givens(H[j][j], H[j+1][j])
+			H[j][j], H[j+1][j] = applyGivens(cs[j], sn[j], H[j][j], H[j+1][j])
+			g[j], g[j+1] = applyGivens(cs[j], sn[j], g[j], g[j+1])
+
+			residual = cmplx.Abs(g[j+1]) / bNorm
+			if residual < tol || h == 0 {
+				j++
+				break
+			}
+		}
+
+		// solve the triangular system H y = g, and update x += precond(Σ y_i V_i).
+		y := make([]complex128, j)
+		for i := j - 1; i >= 0; i-- {
+			s := g[i]
+			for k := i + 1; k < j; k++ {
+				s -= H[i][k] * y[k]
+			}
+			y[i] = s / H[i][i]
+		}
+		Zero(w)
+		for i := 0; i < j; i++ {
+			CMadd2(w, w, V[i], 1, complex64(y[i]))
+		}
+		precond(z, w)
+		SMadd2(x, x, z, 1, 1)
+
+		if residual < tol {
+			break
+		}
+	}
+
+	return iter, residual
+}
+
+// givens returns the complex Givens rotation [c, s; -s*, c] which takes (a, b) to (r, 0).
+func givens(a, b complex128) (float64, complex128) {
+	if a == 0 {
+		return 0, 1
+	}
+	r := math.Hypot(cmplx.Abs(a), cmplx.Abs(b))
+	return cmplx.Abs(a) / r, a / complex(cmplx.Abs(a), 0) * cmplx.Conj(b) / complex(r, 0)
+}
+
+func applyGivens(c float64, s, x, y complex128) (complex128, complex128) {
+	return complex(c, 0)*x + s*y, -cmplx.Conj(s)*x + complex(c, 0)*y
+}
+
+// norm returns the 2-norm of a CSlice.
+func norm(v CSlice) float64 {
+	return math.Sqrt(float64(real(Dotc(v, v))))
+}
@@ -0,0 +1,264 @@
+package solver
+
+import (
+	"fmt"
+	"math/cmplx"
+
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	// JD_TOL is the residual, relative to the eigenvalue, at which a refined pair has converged.
+	// The linear evolution is applied in single precision, so the residuals stall not far below it.
+	JD_TOL        = 1e-4
+	JD_MAXITER    = 50 // the maximum number of iterations for each pair.
+	JD_SUBSPACE   = 20 // the dimension of the search space at which it restarts from the current Ritz vector.
+	JD_GMRES_ITER = 10 // the number of GMRES iterations for each correction equation.
+
+	// JD_PRECOND_SHIFT is the change in the Ritz value, relative to the value for which the preconditioner was built,
+	// beyond which the preconditioner is rebuilt.
+	JD_PRECOND_SHIFT = 1e-2
+)
+
+// A JacobiDavidson refines approximate modes, such as those of quickdisp, of a coarser mesh or of a ringdown, by the Jacobi–Davidson method.
+// Each mode is refined separately: the search space, started from the mode, is expanded by approximate solutions of the correction equation
+// (I - u u*)(L - θ)(I - u u*) t = -r for the current Ritz pair (θ, u) with residual r, found by GMRES with the local preconditioner of ArnoldiField.
+// The linear evolution L is applied in the local frame of the ground state on the GPU, so Damping and SpinTransfer are included.
+type JacobiDavidson struct {
+	Tol     float64 // if it is zero, JD_TOL is used.
+	MaxIter int     // if it is zero, JD_MAXITER is used.
+}
+
+// A RefinedMode is a mode refined by JacobiDavidson, with the relative residual |L ψ - λ ψ| / |λ| of its Ritz pair at each iteration.
+type RefinedMode struct {
+	Freq      complex128
+	Mode      CSlice
+	Residuals []float64
+	Converged bool
+}
+
+// Refine refines each of the modes, with three components as returned by Modes, and the complex frequencies near which they are sought.
+// Note that it does not have any inputs describing the system. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation is currently stored in en.M.
+// It returns an *OptionError if there is not a frequency for each mode, and a *BackendError if an eigendecomposition fails.
+func (jd JacobiDavidson) Refine(freqs []complex128, modes []CSlice) (refined []RefinedMode, err error) {
+
+	if len(freqs) != len(modes) {
+		return nil, &OptionError{Option: "freqs", Reason: fmt.Sprintf("%d frequencies for %d modes", len(freqs), len(modes))}
+	}
+	defer func() { Catch(recover(), &err) }()
+
+	op := newRotatedOperator()
+	defer op.free()
+
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+
+	refined = make([]RefinedMode, len(modes))
+	for p := range modes {
+		mode := modes[p]
+		if !mode.CPUAccess() {
+			mode = mode.HostCopy()
+		}
+		refined[p] = jd.refine(op, freqs[p], rot.RotateMode(mode))
+		refined[p].Mode = rot.DerotateMode(refined[p].Mode)
+	}
+	return refined, nil
+}
+
+// refine refines the pair near the frequency f from the mode u0 in the local frame of the ground state.
+func (jd JacobiDavidson) refine(op *rotatedOperator, f complex128, u0 CSlice) RefinedMode {
+
+	tol := jd.Tol
+	if tol == 0 {
+		tol = JD_TOL
+	}
+	maxIter := jd.MaxIter
+	if maxIter == 0 {
+		maxIter = JD_MAXITER
+	}
+
+	size := en.MeshSize()
+	τ := complex(-imag(f), real(f))
+
+	var V, W []CSlice
+	defer func() {
+		for i := range V {
+			V[i].Free()
+			W[i].Free()
+		}
+	}()
+
+	u := NewCSlice(2, size)
+	defer u.Free()
+	Lu := NewCSlice(2, size)
+	defer Lu.Free()
+	r := NewCSlice(2, size)
+	defer r.Free()
+	t := NewCSlice(2, size)
+	defer t.Free()
+	tmp := NewCSlice(2, size)
+	defer tmp.Free()
+
+	Copy(t, u0)
+	var θ complex128
+	var result RefinedMode
+
+	// the preconditioner, and the Ritz value for which it was built.
+	var precond [][2][2]complex128
+	var θp complex128
+
+	for iter := 0; ; iter++ {
+
+		// expand the search space with t, orthonormalised against it, twice for stability.
+		for pass := 0; pass < 2; pass++ {
+			for i := range V {
+				CMadd2(t, t, V[i], 1, -Dotc(V[i], t))
+			}
+		}
+		if tNorm := norm(t); tNorm > 0 {
+			v := NewCSlice(2, size)
+			SScal(v, t, float32(1/tNorm))
+			w := NewCSlice(2, size)
+			op.applyComplex(w, v)
+			V = append(V, v)
+			W = append(W, w)
+		}
+
+		// the Ritz pair of the search space nearest the target.
+		m := len(V)
+		M := make([]complex128, m*m)
+		for i := 0; i < m; i++ {
+			for j := 0; j < m; j++ {
+				M[i*m+j] = complex128(Dotc(V[i], W[j]))
+			}
+		}
+		var s []complex128
+		θ, s = nearestEigenpair(m, M, τ)
+
+		Zero(u)
+		Zero(Lu)
+		for i := 0; i < m; i++ {
+			CMadd2(u, u, V[i], 1, complex64(s[i]))
+			CMadd2(Lu, Lu, W[i], 1, complex64(s[i]))
+		}
+		uNorm := norm(u)
+		SScal(u, u, float32(1/uNorm))
+		SScal(Lu, Lu, float32(1/uNorm))
+
+		CMadd2(r, Lu, u, 1, complex64(-θ))
+		residual := norm(r) / cmplx.Abs(θ)
+		result.Residuals = append(result.Residuals, residual)
+
+		if residual < tol {
+			result.Converged = true
+			break
+		}
+		if iter == maxIter {
+			util.Log(fmt.Sprintf("Jacobi–Davidson did not converge near %v: the residual is %g after %d iterations.", f, residual, iter))
+			break
+		}
+
+		// restart from the Ritz vector.
+		if m >= JD_SUBSPACE {
+			for i := range V {
+				V[i].Free()
+				W[i].Free()
+			}
+			V = []CSlice{NewCSlice(2, size)}
+			W = []CSlice{NewCSlice(2, size)}
+			Copy(V[0], u)
+			Copy(W[0], Lu)
+		}
+
+		// solve the correction equation approximately, for t orthogonal to u.
+		project := func(x CSlice) {
+			CMadd2(x, x, u, 1, -Dotc(u, x))
+		}
+		if precond == nil || cmplx.Abs(θ-θp) > JD_PRECOND_SHIFT*cmplx.Abs(θp) {
+			precond, θp = localPreconditioner(θ), θ
+		}
+		a := func(dst, src CSlice) {
+			Copy(tmp, src)
+			project(tmp)
+			op.applyComplex(dst, tmp)
+			CMadd2(dst, dst, tmp, 1, complex64(-θ))
+			project(dst)
+		}
+		pre := func(dst, src CSlice) {
+			Copy(tmp, src)
+			project(tmp)
+			applyLocal(precond, dst, tmp)
+			project(dst)
+		}
+
+		SScal(r, r, -1)
+		Zero(t)
+		gmres(a, pre, t, r, JD_GMRES_ITER, JD_GMRES_ITER, 1e-2)
+	}
+
+	result.Freq = complexFrequency(θ)
+	result.Mode = u.HostCopy()
+	return result
+}
+
+// nearestEigenpair returns the eigenvalue of the m x m complex matrix a, row major, nearest τ, and its eigenvector.
+// The eigenpairs are found from those of the real matrix [[Re a, -Im a], [Im a, Re a]], whose eigenvalues are those of a and of its conjugate.
+// An eigenvector z of a gives the eigenvector [z, -iz] of the real matrix, while an eigenvector w of the conjugate gives [w, iw],
+// so x + iy is twice the eigenvector of a for [x, y] of the first kind, and zero for the second.
+func nearestEigenpair(m int, a []complex128, τ complex128) (complex128, []complex128) {
+
+	re := mat.NewDense(2*m, 2*m, nil)
+	for i := 0; i < m; i++ {
+		for j := 0; j < m; j++ {
+			re.Set(i, j, real(a[i*m+j]))
+			re.Set(i, j+m, -imag(a[i*m+j]))
+			re.Set(i+m, j, imag(a[i*m+j]))
+			re.Set(i+m, j+m, real(a[i*m+j]))
+		}
+	}
+
+	var eig mat.Eigen
+	if !eig.Factorize(re, mat.EigenRight) {
+		panic(&BackendError{Routine: "Eigen", Reason: "eigendecomposition of the projected matrix failed"})
+	}
+	values := eig.Values(nil)
+	var vectors mat.CDense
+	eig.VectorsTo(&vectors)
+
+	// if a has real eigenvalues, those of the two kinds coincide and the eigenvectors may be mixed,
+	// so if none is clearly of the first kind, take the one most like it.
+	best, mixed := -1, -1
+	zs := make([][]complex128, len(values))
+	ratios := make([]float64, len(values))
+	for k, λ := range values {
+
+		zs[k] = make([]complex128, m)
+		zNorm, xyNorm := 0., 0.
+		for i := 0; i < m; i++ {
+			x, y := vectors.At(i, k), vectors.At(i+m, k)
+			zs[k][i] = x + complex(0, 1)*y
+			zNorm += real(zs[k][i] * cmplx.Conj(zs[k][i]))
+			xyNorm += real(x*cmplx.Conj(x) + y*cmplx.Conj(y))
+		}
+		ratios[k] = zNorm / xyNorm
+
+		if ratios[k] >= 1 && (best < 0 || cmplx.Abs(λ-τ) < cmplx.Abs(values[best]-τ)) {
+			best = k
+		}
+		if mixed < 0 || ratios[k] > ratios[mixed] {
+			mixed = k
+		}
+	}
+	if best < 0 {
+		best = mixed
+	}
+
+	return values[best], zs[best]
+}
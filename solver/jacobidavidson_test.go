@@ -0,0 +1,59 @@
+package solver
+
+import (
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestJacobiDavidson checks that modes of RotatedToZ, perturbed by another mode and with their frequencies moved by 1%, are refined back to them.
+func TestJacobiDavidson(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		var freqs []complex128
+		var rough []CSlice
+		for p := 0; p < 4 && p+1 < len(valsA); p++ {
+			a, b := vecsA[p].DevCopy(), vecsA[p+1].DevCopy()
+			mode := NewCSlice(3, en.MeshSize())
+			CMadd2(mode, a, b, 1, 0.1)
+			a.Free()
+			b.Free()
+			freqs = append(freqs, complex(1.01*valsA[p], 0))
+			rough = append(rough, mode)
+		}
+
+		refined, refineErr := JacobiDavidson{Tol: 1e-5}.Refine(freqs, rough)
+		if refineErr != nil {
+			t.Fatal(refineErr)
+		}
+
+		var valsB []float64
+		var vecsB []CSlice
+		for p, r := range refined {
+			if !r.Converged {
+				t.Errorf("%d: mode %d did not converge: residuals %v", test_idx, p, r.Residuals)
+			}
+			if last := len(r.Residuals) - 1; r.Residuals[last] > r.Residuals[0] {
+				t.Errorf("%d: the residual of mode %d grew from %g to %g", test_idx, p, r.Residuals[0], r.Residuals[last])
+			}
+			valsB = append(valsB, real(r.Freq))
+			vecsB = append(vecsB, r.Mode)
+		}
+
+		err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions are not equal: %d%% error", test_idx, 100*err/len(valsB))
+		}
+	}
+}
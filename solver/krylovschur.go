@@ -0,0 +1,530 @@
+package solver
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+
+	"gonum.org/v1/gonum/lapack"
+	"gonum.org/v1/gonum/lapack/gonum"
+)
+
+var (
+	KRYLOV_SCHUR_TOL         = 1e-5 // the residual, relative to the eigenvalue, at which a Ritz pair has converged.
+	KRYLOV_SCHUR_MAXRESTARTS = 1000 // the maximum number of restarts.
+)
+
+// A KrylovSchur solver returns the NEV modes of smallest frequency by the Krylov–Schur method, applying the linear evolution
+// of the system in the local frame of the ground state on the GPU, like ArnoldiField. With ShiftInvert set, it instead returns
+// the modes with frequencies nearest ±Sigma.
+// The Krylov subspace grows to Restart vectors, and is then cut back to the Keep wanted Ritz vectors by reordering its real Schur form;
+// the others are purged. Converged Ritz pairs can be locked, and the iteration can be monitored and stopped after each restart.
+type KrylovSchur struct {
+	eigenSolver
+
+	NEV     int // the number of modes to find, counting each of a complex conjugate pair. If it is zero, ARNOLDI_NEV are found.
+	Restart int // the dimension of the Krylov subspace at which it restarts. If it is zero, it is 2 NEV + 1, and at least 20.
+	Keep    int // the number of Ritz vectors kept at a restart. If it is zero, it is half way between NEV and Restart.
+
+	// Lock sets the couplings of converged Ritz vectors to the rest of the subspace to zero, so they are no longer updated
+	// and the Schur form is only reordered below them.
+	Lock bool
+
+	Tol         float64 // the relative residual |L x - λ x| / |λ| of a converged pair. If it is zero, KRYLOV_SCHUR_TOL is used.
+	MaxRestarts int     // the maximum number of restarts. If it is zero, KRYLOV_SCHUR_MAXRESTARTS is used.
+
+	ShiftInvert bool    // iterates with Re((L - i Sigma)⁻¹), as for ArnoldiField.
+	Sigma       float64 // the frequency (rad/s) about which modes are found with ShiftInvert.
+
+	// Monitor, if not nil, is called after each restart with the wanted Ritz values of the operator iterated, best first,
+	// and their relative residuals. If it returns true, the iteration stops and the pairs that have converged are returned.
+	Monitor func(restart int, ritz []complex128, residuals []float64) (stop bool)
+
+	// Checkpoint, if not empty, is a file to which the state of the iteration is written every CHECKPOINT_EVERY restarts,
+	// and when it stops before converging. If the file holds the state of an iteration for the same system and options,
+	// verified by a hash of them, the iteration resumes from it.
+	Checkpoint string
+
+	// the System being solved by SystemComplexModes, which the Checkpoint is hashed from. If nil, it is the system set up in mumax.
+	system *mag.System
+}
+
+func (solver KrylovSchur) Modes() ([]float64, []CSlice) {
+	return realFrequencies(solver.ComplexModes())
+}
+
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the system, which include the decay rates when Damping is set.
+func (solver KrylovSchur) ComplexModes() ([]complex128, []CSlice) {
+
+	NCell := en.Mesh().NCell()
+	totalSize := 2 * NCell
+
+	rotOp := newRotatedOperator()
+	defer rotOp.free()
+
+	rotCPU := new(mag.RotationToZ)
+	rotCPU.InitRotation()
+
+	xSl2 := cuda.NewSlice(2, en.MeshSize())
+	ySl2 := cuda.NewSlice(2, en.MeshSize())
+	defer xSl2.Free()
+	defer ySl2.Free()
+
+	opS := func(y, x []float32) {
+		xSlCPU := data.SliceFromArray([][]float32{x[0:NCell], x[NCell:totalSize]}, en.Mesh().Size())
+		data.Copy(xSl2, xSlCPU)
+		rotOp.apply(ySl2, xSl2)
+		ySlCPU := data.SliceFromArray([][]float32{y[0:NCell], y[NCell:totalSize]}, en.Mesh().Size())
+		data.Copy(ySlCPU, ySl2)
+	}
+	better := smallerMagnitude
+
+	var si *shiftInvert
+	if solver.ShiftInvert {
+		si = newShiftInvert(rotOp, solver.Sigma)
+		defer si.free()
+		opS = si.apply
+		better = largerMagnitude
+	}
+
+	yS := make([]float32, totalSize)
+	op := func(y, x []float64) {
+		opS(yS, toSingle(x))
+		for i := range y {
+			y[i] = float64(yS[i])
+		}
+	}
+
+	values, vectors := solver.iterate(totalSize, op, magnetisedStart(NCell, 2), magnetisedMask(NCell, 2), better, "KrylovSchur")
+
+	var freq []complex128
+	var modes []CSlice
+	for p := range values {
+		λ := values[p]
+		if si != nil {
+			λ = si.rayleighQuotient(vectors[p])
+		}
+		freq = append(freq, complexFrequency(λ))
+		modes = append(modes, rotCPU.DerotateMode(transverseMode(vectors[p], en.MeshSize())))
+	}
+	if si != nil {
+		util.Log(fmt.Sprintf("Shift-invert took %d GMRES iterations.", si.iterations))
+	}
+
+	return freq, modes
+}
+
+// SystemModes returns the eigenfrequencies and corresponding eigenmodes of the System s, without the mumax engine; see SystemComplexModes.
+func (solver KrylovSchur) SystemModes(s *mag.System) ([]float64, []CSlice) {
+	return realFrequencies(solver.SystemComplexModes(s))
+}
+
+// SystemComplexModes returns the NEV modes of smallest frequency of the System s, which include the decay rates when s.Damping is set.
+// The linear evolution of s is applied on the GPU by a mag.SystemOperator, in the local frame of its ground state, without building its tensor.
+// It panics with an *OptionError if ShiftInvert is set, as the preconditioner is built from the system set up in mumax.
+func (solver KrylovSchur) SystemComplexModes(s *mag.System) ([]complex128, []CSlice) {
+
+	if solver.ShiftInvert {
+		panic(&OptionError{Option: "ShiftInvert", Reason: "it is not supported for a System"})
+	}
+
+	NCell := s.Size[0] * s.Size[1] * s.Size[2]
+	totalSize := 2 * NCell
+
+	rot := new(mag.RotationToZ)
+	rot.InitRotationFrom(s.M)
+	sysOp := mag.NewSystemOperator(s, rot)
+	defer sysOp.Free()
+
+	x2 := cuda.NewSlice(2, s.Size)
+	y2 := cuda.NewSlice(2, s.Size)
+	defer x2.Free()
+	defer y2.Free()
+
+	yS := make([]float32, totalSize)
+	op := func(y, x []float64) {
+		interrupt()
+		xS := toSingle(x)
+		data.Copy(x2, data.SliceFromArray([][]float32{xS[0:NCell], xS[NCell:totalSize]}, s.Size))
+		sysOp.Operate(y2, x2)
+		data.Copy(data.SliceFromArray([][]float32{yS[0:NCell], yS[NCell:totalSize]}, s.Size), y2)
+		for i := range y {
+			y[i] = float64(yS[i])
+		}
+	}
+
+	ms := s.Msat.Host()[0]
+	mask := make([]bool, totalSize)
+	for i := range mask {
+		mask[i] = ms[i%NCell] != 0
+	}
+
+	solver.system = s
+	values, vectors := solver.iterate(totalSize, op, randomStart(mask), mask, smallerMagnitude, "KrylovSchur of a System")
+
+	freq := make([]complex128, len(values))
+	modes := make([]CSlice, len(values))
+	for p := range values {
+		freq[p] = complexFrequency(values[p])
+		modes[p] = rot.DerotateMode(transverseMode(vectors[p], s.Size))
+	}
+	return freq, modes
+}
+
+// magnetisedStart returns a random start vector with nComp components in the local frame of the ground state,
+// with no component on the cells without magnetisation or pinned by mag.PinnedSurfaces, whose eigenvalues are zero.
+func magnetisedStart(NCell, nComp int) []float64 {
+	return randomStart(magnetisedMask(NCell, nComp))
+}
+
+// randomStart returns a random start vector which is zero where mask is false.
+func randomStart(mask []bool) []float64 {
+	rng := rand.New(rand.NewSource(0))
+	v0 := make([]float64, len(mask))
+	for i := range v0 {
+		if mask[i] {
+			v0[i] = rng.NormFloat64()
+		}
+	}
+	return v0
+}
+
+// magnetisedMask returns whether each element of a vector with nComp components may be nonzero,
+// which it may not on the cells without magnetisation or pinned by mag.PinnedSurfaces.
+func magnetisedMask(NCell, nComp int) []bool {
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	pinned := mag.PinnedCells()
+	mask := make([]bool, nComp*NCell)
+	for i := range mask {
+		mask[i] = ms[i%NCell] != 0 && !pinned[i%NCell]
+	}
+	return mask
+}
+
+func smallerMagnitude(a, b complex128) bool { return cmplx.Abs(a) < cmplx.Abs(b) }
+func largerMagnitude(a, b complex128) bool  { return cmplx.Abs(a) > cmplx.Abs(b) }
+
+// iterate runs the Krylov–Schur iteration for the operator op(y, x), which sets y = A x for vectors of length n, from the start vector v0.
+// The Ritz values are ordered by better, which returns whether a is wanted before b. It returns the converged eigenpairs
+// of A, each of a complex conjugate pair followed by its partner, with the eigenvectors normalised.
+//
+// The iteration keeps a Krylov–Schur decomposition A V_k = V_k S_k + v_k bᵀ, stored as A V_k = V_{k+1} S, with the row k of S being bᵀ.
+// It is expanded by Arnoldi steps to dimension Restart, when S is reduced to real Schur form with the wanted Ritz values leading,
+// and the decomposition is truncated to the leading Keep columns.
+// If the subspace becomes invariant it continues with a random vector, which is zero where mask is false, as for the start vector.
+// The operator names op in the hash of a Checkpoint, so that the iterations of different operators are not resumed from each other.
+func (solver KrylovSchur) iterate(n int, op func(y, x []float64), v0 []float64, mask []bool, better func(a, b complex128) bool, operator string) ([]complex128, [][]complex128) {
+
+	nev := solver.NEV
+	if nev == 0 {
+		nev = ARNOLDI_NEV
+	}
+	m := solver.Restart
+	if m == 0 {
+		m = 2*nev + 1
+		if m < 20 {
+			m = 20
+		}
+	}
+	if m > n {
+		m = n
+	}
+	keep := solver.Keep
+	if keep == 0 {
+		keep = nev + (m-nev)/2
+	}
+	if nev > n-1 || m < nev+2 || keep < nev || keep >= m {
+		panic(&OptionError{Option: "NEV, Restart or Keep", Reason: "Krylov–Schur needs NEV < n - 1, NEV + 2 <= Restart <= n and NEV <= Keep < Restart"})
+	}
+	tol := solver.Tol
+	if tol == 0 {
+		tol = KRYLOV_SCHUR_TOL
+	}
+	maxRestarts := solver.MaxRestarts
+	if maxRestarts == 0 {
+		maxRestarts = KRYLOV_SCHUR_MAXRESTARTS
+	}
+
+	impl := gonum.Implementation{}
+	rng := rand.New(rand.NewSource(1))
+
+	V := make([][]float64, m+1)
+	S := make([]float64, (m+1)*m) // row major with leading dimension m.
+	k, nlock, first := 0, 0, 0
+
+	var hash string
+	var saved krylovCheckpoint
+	if solver.Checkpoint != "" {
+		hash = systemHash(solver.system, krylovOptions{N: n, NEV: nev, Restart: m, Keep: keep, Lock: solver.Lock, ShiftInvert: solver.ShiftInvert,
+			Sigma: solver.Sigma, Tol: tol, Operator: operator})
+	}
+	if hash != "" && readCheckpoint(solver.Checkpoint, hash, &saved) {
+		V, S = saved.V, saved.S
+		k, nlock, first = m, saved.NLock, saved.Restart
+		util.Log(fmt.Sprintf("Resumed Krylov–Schur from %s after %d restarts.", solver.Checkpoint, first))
+	} else {
+		V[0] = append([]float64(nil), v0...)
+		if !normaliseVector(V[0]) {
+			panic(&OptionError{Option: "start vector", Reason: "it is zero"})
+		}
+	}
+	checkpoint := func(restart int) {
+		writeCheckpoint(solver.Checkpoint, krylovCheckpoint{Hash: hash, Restart: restart, NLock: nlock, V: V, S: S})
+	}
+
+	T := make([]float64, m*m)
+	Q := make([]float64, m*m)
+	Y := make([]float64, m*m)
+	b := make([]float64, m)
+	tau := make([]float64, m)
+	wr := make([]float64, m)
+	wi := make([]float64, m)
+	work := make([]float64, 64*m)
+
+	var values []complex128
+	var vectors [][]complex128
+
+	for restart := first; ; restart++ {
+
+		// expand the decomposition to dimension m by Arnoldi steps, with full reorthogonalisation, twice for stability.
+		for j := k; j < m; j++ {
+			w := make([]float64, n)
+			op(w, V[j])
+			wNorm := math.Sqrt(dot(w, w))
+			for pass := 0; pass < 2; pass++ {
+				for i := 0; i <= j; i++ {
+					h := dot(V[i], w)
+					S[i*m+j] += h
+					for l := range w {
+						w[l] -= h * V[i][l]
+					}
+				}
+			}
+			β := math.Sqrt(dot(w, w))
+
+			// if the subspace is invariant, continue with a random vector orthogonal to it.
+			if β < 1e-12*wNorm {
+				β = 0
+				for l := range w {
+					if mask[l] {
+						w[l] = rng.NormFloat64()
+					} else {
+						w[l] = 0
+					}
+				}
+				for pass := 0; pass < 2; pass++ {
+					for i := 0; i <= j; i++ {
+						h := dot(V[i], w)
+						for l := range w {
+							w[l] -= h * V[i][l]
+						}
+					}
+				}
+			}
+			normaliseVector(w)
+			S[(j+1)*m+j] = β
+			V[j+1] = w
+		}
+		if hash != "" && (restart+1)%CHECKPOINT_EVERY == 0 {
+			checkpoint(restart)
+		}
+
+		// reduce the unlocked part of S to real Schur form, T = Qᵀ S Q.
+		copy(T, S[:m*m])
+		copy(b, S[m*m:])
+		impl.Dgehrd(m, nlock, m-1, T, m, tau, work, len(work))
+		copy(Q, T)
+		impl.Dorghr(m, nlock, m-1, Q, m, tau, work, len(work))
+		for j := nlock; j < m; j++ {
+			for i := j + 2; i < m; i++ {
+				T[i*m+j] = 0
+			}
+		}
+		if impl.Dhseqr(lapack.EigenvaluesAndSchur, lapack.SchurOrig, m, nlock, m-1, T, m, wr, wi, Q, m, work, len(work)) > 0 {
+			panic(&BackendError{Routine: "Dhseqr", Reason: "the Schur decomposition of the Krylov–Schur matrix failed"})
+		}
+
+		// order the unlocked Ritz values, wanted first.
+		for pos := nlock; pos < m; pos += schurBlockSize(T, m, pos) {
+			best := pos
+			for i := pos; i < m; i += schurBlockSize(T, m, i) {
+				if better(schurBlockValue(T, m, i), schurBlockValue(T, m, best)) {
+					best = i
+				}
+			}
+			if best != pos {
+				if _, _, ok := impl.Dtrexc(lapack.UpdateSchur, m, T, m, Q, m, best, pos, work); !ok {
+					util.Log("Reordering of the Krylov–Schur matrix failed: the Ritz values are not all in order.")
+				}
+			}
+		}
+
+		// bᵀ Q gives the residuals of the Ritz pairs: for an eigenvector y of T, |A V Q y - λ V Q y| = |bᵀ Q y|.
+		bQ := make([]float64, m)
+		for j := 0; j < m; j++ {
+			for i := 0; i < m; i++ {
+				bQ[j] += b[i] * Q[i*m+j]
+			}
+		}
+		impl.Dtrevc3(lapack.EVRight, lapack.EVAll, nil, m, T, m, nil, 1, Y, m, m, work, len(work))
+
+		var ritz []complex128
+		var residuals []float64
+		var starts []int
+		nconv := 0
+		converging := true
+		for pos := 0; pos < nev; pos += schurBlockSize(T, m, pos) {
+			λ := schurBlockValue(T, m, pos)
+			r := cmplx.Abs(ritzResidual(bQ, Y, m, pos, schurBlockSize(T, m, pos))) / math.Max(cmplx.Abs(λ), 1e-300)
+			ritz = append(ritz, λ)
+			residuals = append(residuals, r)
+			starts = append(starts, pos)
+			if converging && r < tol {
+				nconv = pos + schurBlockSize(T, m, pos)
+			} else {
+				converging = false
+			}
+		}
+
+		progress(restart, nconv, ritz)
+		stop := solver.Monitor != nil && solver.Monitor(restart, ritz, residuals)
+		if nconv >= nev || stop || restart == maxRestarts {
+			if nconv < nev {
+				util.Log(fmt.Sprintf("Krylov–Schur stopped after %d restarts with %d of %d eigenvalues converged.", restart, nconv, nev))
+				if hash != "" {
+					checkpoint(restart)
+				}
+				if !stop {
+					notConverged(&ConvergenceError{Solver: "Krylov–Schur", Converged: nconv, Wanted: nev, Iterations: restart})
+				}
+			} else {
+				util.Log(fmt.Sprintf("Found %d eigenvalues (out of total dimension %d) in %d restarts.", nconv, n, restart))
+			}
+			for p, pos := range starts {
+				if pos >= nconv {
+					break
+				}
+				x := ritzVector(V, Q, Y, m, n, pos, schurBlockSize(T, m, pos))
+				values = append(values, ritz[p])
+				vectors = append(vectors, x)
+				if imag(ritz[p]) != 0 {
+					partner := make([]complex128, n)
+					for i := range x {
+						partner[i] = cmplx.Conj(x[i])
+					}
+					values = append(values, cmplx.Conj(ritz[p]))
+					vectors = append(vectors, partner)
+				}
+			}
+			return values, vectors
+		}
+
+		if solver.Lock {
+			for i := nlock; i < nconv; i++ {
+				bQ[i] = 0
+			}
+			nlock = nconv
+		}
+
+		// truncate to the leading k Schur vectors, not splitting a complex conjugate pair, and purge the rest.
+		k = keep
+		if k < nlock+1 {
+			k = nlock + 1
+		}
+		if k < m && T[k*m+k-1] != 0 {
+			k++
+		}
+		if k >= m {
+			panic(&OptionError{Option: "Restart", Reason: "Krylov–Schur cannot restart: the locked and kept vectors fill the subspace; increase Restart"})
+		}
+
+		Vk := make([][]float64, k+1)
+		for j := 0; j < k; j++ {
+			Vk[j] = make([]float64, n)
+			for i := 0; i < m; i++ {
+				if q := Q[i*m+j]; q != 0 {
+					for l := range Vk[j] {
+						Vk[j][l] += q * V[i][l]
+					}
+				}
+			}
+		}
+		Vk[k] = V[m]
+		V = append(Vk, make([][]float64, m-k)...)
+
+		for i := range S {
+			S[i] = 0
+		}
+		for i := 0; i < k; i++ {
+			for j := 0; j < k; j++ {
+				S[i*m+j] = T[i*m+j]
+			}
+			S[k*m+i] = bQ[i]
+		}
+	}
+}
+
+// schurBlockSize returns the size, 1 or 2, of the diagonal block starting at row i of the m x m quasi-triangular matrix t.
+func schurBlockSize(t []float64, m, i int) int {
+	if i+1 < m && t[(i+1)*m+i] != 0 {
+		return 2
+	}
+	return 1
+}
+
+// schurBlockValue returns the eigenvalue of the diagonal block starting at row i of t, the one with positive imaginary part for a 2 x 2 block,
+// which is in the standard form [[a, b], [c, a]] with bc < 0.
+func schurBlockValue(t []float64, m, i int) complex128 {
+	a := t[i*m+i]
+	if schurBlockSize(t, m, i) == 1 {
+		return complex(a, 0)
+	}
+	return complex(a, math.Sqrt(math.Abs(t[i*m+i+1]))*math.Sqrt(math.Abs(t[(i+1)*m+i])))
+}
+
+// ritzResidual returns bᵀ y / |y|, where y is the eigenvector in the columns of Y, as returned by Dtrevc3, for the block at pos.
+func ritzResidual(b, Y []float64, m, pos, size int) complex128 {
+	var r, yNorm complex128
+	for i := 0; i < m; i++ {
+		y := complex(Y[i*m+pos], 0)
+		if size == 2 {
+			y += complex(0, Y[i*m+pos+1])
+		}
+		r += complex(b[i], 0) * y
+		yNorm += y * cmplx.Conj(y)
+	}
+	return r / cmplx.Sqrt(yNorm)
+}
+
+// ritzVector returns the normalised Ritz vector V Q y, for the eigenvector y of the block at pos.
+func ritzVector(V [][]float64, Q, Y []float64, m, n, pos, size int) []complex128 {
+
+	z := make([]complex128, m)
+	for i := 0; i < m; i++ {
+		for l := 0; l < m; l++ {
+			y := complex(Y[l*m+pos], 0)
+			if size == 2 {
+				y += complex(0, Y[l*m+pos+1])
+			}
+			z[i] += complex(Q[i*m+l], 0) * y
+		}
+	}
+
+	x := make([]complex128, n)
+	for i := 0; i < m; i++ {
+		for l := range x {
+			x[l] += z[i] * complex(V[i][l], 0)
+		}
+	}
+	normaliseComplex(x)
+	return x
+}
@@ -0,0 +1,304 @@
+package solver
+
+import (
+	"math"
+	"math/cmplx"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	// LOCK_SHIFT is the factor, relative to the largest eigenvalue of the locked modes, by which they are shifted out of the way
+	// of the smallest eigenvalues sought by the Arnoldi solvers.
+	LOCK_SHIFT = 100.
+)
+
+// A KrylovStart holds modes from a previous solve, at a nearby field or on a nearby mesh, which the Arnoldi solvers use to start the Krylov iteration.
+// The modes are those returned by Modes, with three components, and are rotated into the local frame of the current ground state.
+type KrylovStart struct {
+
+	// Guess, if not empty, gives the starting vector of the iteration as the sum of the real and imaginary parts of these modes,
+	// each normalised, rather than a random vector.
+	Guess []CSlice
+
+	// Locked modes are eigenmodes of the current system which have already converged. They are deflated, by shifting their eigenvalues
+	// by LOCK_SHIFT times the largest of them, so that they are not found again and the solver returns only the other modes.
+	// Both modes of a complex conjugate pair should be locked.
+	Locked []CSlice
+}
+
+// SetGuess sets the modes used to start the iteration. It is used through a pointer, so a Sweep warm-starts a Solver only when it holds a pointer.
+func (ks *KrylovStart) SetGuess(modes []CSlice) {
+	ks.Guess = modes
+}
+
+// guess returns the modes used to start the iteration.
+func (ks KrylovStart) guess() []CSlice {
+	return ks.Guess
+}
+
+// A warmStarter is a solver which can be started from the modes of a previous solve.
+// Only a pointer to a solver with a KrylovStart is one, as SetGuess has a pointer receiver.
+type warmStarter interface {
+	SetGuess(modes []CSlice)
+	guess() []CSlice
+}
+
+// startVector returns the starting vector for the iteration, of length 2 * Nx * Ny * Nz in the frame of rot, orthogonal to the
+// deflated subspace d. It returns nil if there is no Guess.
+func (ks KrylovStart) startVector(rot *mag.RotationToZ, d deflation) []float64 {
+
+	if len(ks.Guess) == 0 {
+		return nil
+	}
+
+	var v0 []float64
+	for _, mode := range ks.Guess {
+		for _, part := range flattenModeParts(rot, mode) {
+			normaliseVector(part)
+			if v0 == nil {
+				v0 = make([]float64, len(part))
+			}
+			for i := range part {
+				v0[i] += part[i]
+			}
+		}
+	}
+
+	d.project(v0)
+	if !normaliseVector(v0) {
+		return nil
+	}
+	return v0
+}
+
+// A deflation shifts the eigenvalues of the locked modes by σ, by adding σ Q Qᵀ to the operator A,
+// where the columns of Q are an orthonormal basis of the locked modes. As the span of Q is invariant under A, this leaves the
+// other eigenvalues unchanged, and their eigenvectors are recovered from those of the deflated operator by correct.
+type deflation struct {
+	q [][]float64 // the orthonormal basis of the locked modes.
+	r []float64   // Qᵀ A Q, row major.
+	σ float64
+}
+
+// newDeflation returns the deflation of the locked modes, rotated into the frame of rot, for the operator op(y, x) which sets y = A x.
+func newDeflation(rot *mag.RotationToZ, locked []CSlice, op func(y, x []float64)) deflation {
+
+	var d deflation
+	for _, mode := range locked {
+		for _, part := range flattenModeParts(rot, mode) {
+			// modified Gram-Schmidt, twice for stability.
+			for pass := 0; pass < 2; pass++ {
+				d.project(part)
+			}
+			if normaliseVector(part) {
+				d.q = append(d.q, part)
+			}
+		}
+	}
+
+	k := len(d.q)
+	if k == 0 {
+		return d
+	}
+
+	d.r = make([]float64, k*k)
+	aq := make([]float64, len(d.q[0]))
+	for j := range d.q {
+		op(aq, d.q[j])
+		for i := range d.q {
+			d.r[i*k+j] = dot(d.q[i], aq)
+		}
+	}
+
+	var eig mat.Eigen
+	if !eig.Factorize(mat.NewDense(k, k, append([]float64(nil), d.r...)), mat.EigenNone) {
+		panic(&BackendError{Routine: "Eigen", Reason: "eigendecomposition of the locked modes failed"})
+	}
+	largest := 0.
+	for _, λ := range eig.Values(nil) {
+		largest = math.Max(largest, cmplx.Abs(λ))
+	}
+	d.σ = LOCK_SHIFT * largest
+
+	return d
+}
+
+// active returns whether any modes are deflated.
+func (d deflation) active() bool {
+	return len(d.q) > 0
+}
+
+// project removes the components of v in the deflated subspace.
+func (d deflation) project(v []float64) {
+	for _, q := range d.q {
+		a := dot(q, v)
+		for i := range v {
+			v[i] -= a * q[i]
+		}
+	}
+}
+
+// shift adds σ Q Qᵀ x to y.
+func (d deflation) shift(y, x []float64) {
+	for _, q := range d.q {
+		a := d.σ * dot(q, x)
+		for i := range y {
+			y[i] += a * q[i]
+		}
+	}
+}
+
+// shiftS is shift for single precision vectors.
+func (d deflation) shiftS(y, x []float32) {
+	for _, q := range d.q {
+		a := 0.
+		for i := range x {
+			a += q[i] * float64(x[i])
+		}
+		a *= d.σ
+		for i := range y {
+			y[i] += float32(a * q[i])
+		}
+	}
+}
+
+// shifted returns whether λ is, to within the tolerance of the solvers, the shifted eigenvalue of a locked mode.
+func (d deflation) shifted(λ complex128) bool {
+	return d.active() && real(λ) > d.σ/2
+}
+
+// correct returns the eigenvector of A with eigenvalue λ, from the eigenvector v of the deflated operator.
+// Writing v = Q a' + w, with w orthogonal to Q, the eigenvector is w + Q a where (λ - R) a = (λ - σ - R) a'.
+func (d deflation) correct(λ complex128, v []complex128) []complex128 {
+
+	if !d.active() {
+		return v
+	}
+
+	k := len(d.q)
+
+	a_ := make([]complex128, k)
+	for i, q := range d.q {
+		for j := range v {
+			a_[i] += complex(q[j], 0) * v[j]
+		}
+	}
+
+	lhs := make([]complex128, k*k)
+	rhs := make([]complex128, k)
+	for i := 0; i < k; i++ {
+		rhs[i] = (λ - complex(d.σ, 0)) * a_[i]
+		for j := 0; j < k; j++ {
+			lhs[i*k+j] = complex(-d.r[i*k+j], 0)
+			rhs[i] -= complex(d.r[i*k+j], 0) * a_[j]
+		}
+		lhs[i*k+i] += λ
+	}
+	a := solveDense(k, lhs, rhs)
+
+	x := make([]complex128, len(v))
+	copy(x, v)
+	for i, q := range d.q {
+		c := a[i] - a_[i]
+		for j := range x {
+			x[j] += c * complex(q[j], 0)
+		}
+	}
+
+	norm := 0.
+	for _, xj := range x {
+		norm += real(xj)*real(xj) + imag(xj)*imag(xj)
+	}
+	norm = math.Sqrt(norm)
+	for j := range x {
+		x[j] /= complex(norm, 0)
+	}
+
+	return x
+}
+
+// flattenModeParts rotates a three component mode into the frame of rot, and returns its real and imaginary parts
+// as vectors ordered by component and then by cell.
+func flattenModeParts(rot *mag.RotationToZ, mode CSlice) [2][]float64 {
+
+	if !mode.CPUAccess() {
+		mode = mode.HostCopy()
+	}
+	rotated := rot.RotateMode(mode)
+
+	var parts [2][]float64
+	for p, sl := range []([][]float32){rotated.Real().Host(), rotated.Imag().Host()} {
+		n := len(sl[0])
+		parts[p] = make([]float64, 2*n)
+		for c := 0; c < 2; c++ {
+			for idx := 0; idx < n; idx++ {
+				parts[p][c*n+idx] = float64(sl[c][idx])
+			}
+		}
+	}
+	return parts
+}
+
+// solveDense solves the n x n complex linear system a x = b, with a row major, by Gaussian elimination with partial pivoting.
+// a and b are overwritten.
+func solveDense(n int, a, b []complex128) []complex128 {
+
+	for col := 0; col < n; col++ {
+
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if cmplx.Abs(a[row*n+col]) > cmplx.Abs(a[pivot*n+col]) {
+				pivot = row
+			}
+		}
+		if a[pivot*n+col] == 0 {
+			panic(&BackendError{Routine: "solveDense", Reason: "singular matrix"})
+		}
+		for j := 0; j < n; j++ {
+			a[col*n+j], a[pivot*n+j] = a[pivot*n+j], a[col*n+j]
+		}
+		b[col], b[pivot] = b[pivot], b[col]
+
+		for row := col + 1; row < n; row++ {
+			f := a[row*n+col] / a[col*n+col]
+			for j := col; j < n; j++ {
+				a[row*n+j] -= f * a[col*n+j]
+			}
+			b[row] -= f * b[col]
+		}
+	}
+
+	x := make([]complex128, n)
+	for row := n - 1; row >= 0; row-- {
+		s := b[row]
+		for j := row + 1; j < n; j++ {
+			s -= a[row*n+j] * x[j]
+		}
+		x[row] = s / a[row*n+row]
+	}
+	return x
+}
+
+func dot(a, b []float64) float64 {
+	s := 0.
+	for i := range a {
+		s += a[i] * b[i]
+	}
+	return s
+}
+
+// normaliseVector scales v to unit norm. It returns false, leaving v unchanged, if v is too small to normalise.
+func normaliseVector(v []float64) bool {
+	norm := math.Sqrt(dot(v, v))
+	if norm < 1e-10 {
+		return false
+	}
+	for i := range v {
+		v[i] /= norm
+	}
+	return true
+}
@@ -0,0 +1,237 @@
+package solver
+
+import (
+	"math"
+	"math/cmplx"
+	"math/rand"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	LANCZOS_STEPS = 100  // the default number of Lanczos steps, which is the size of the tridiagonal matrix.
+	LANCZOS_TOL   = 1e-6 // the residual, relative to the frequency, at which a Ritz pair has converged.
+)
+
+// A pseudoHermitianLanczos finds the modes of the undamped linear evolution L in the local frame of the ground state.
+// There L = D H, with H the symmetric linear Hamiltonian and D = (γ/Ms) J at each cell, where J = [[0, -1], [1, 0]].
+// The eigenproblem is then H x = ω K x with the indefinite metric K = (Ms/γ)(-iJ), the Bogoliubov structure of the spin waves,
+// and A = K⁻¹ H = -iL is self-adjoint in the inner product <x, y>_H = x* H y, which is positive definite for a stable ground state.
+// The Lanczos process is run on A in this inner product, so the tridiagonal matrix is real and symmetric, the Ritz frequencies are real,
+// and the Ritz vectors are H-orthogonal and so σ-orthogonal, x_i* K x_j = 0 for i ≠ j. Each mode is returned with its partner (-ω, x̄).
+// The Lanczos vectors are fully reorthogonalised.
+type pseudoHermitianLanczos struct {
+	n      int                         // the length of the vectors, 2 * Nx * Ny * Nz.
+	applyL func(dst, src []complex128) // sets dst = L src.
+	scale  []float64                   // Ms/γ for each degree of freedom.
+}
+
+func newPseudoHermitianLanczos(applyL func(dst, src []complex128)) pseudoHermitianLanczos {
+
+	if Damping || SpinTransfer {
+		panic(&OptionError{Option: "dynamics", Reason: "the pseudo-Hermitian structure needs the undamped dynamics without spin-transfer torques"})
+	}
+
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	NCell := len(ms)
+	scale := make([]float64, 2*NCell)
+	for c := 0; c < 2; c++ {
+		for idx := 0; idx < NCell; idx++ {
+			scale[c*NCell+idx] = float64(ms[idx]) / en.GammaLL
+		}
+	}
+
+	return pseudoHermitianLanczos{n: 2 * NCell, applyL: applyL, scale: scale}
+}
+
+// applyH sets Hx = (Ms/γ)(-J) Lx, from Lx = L x.
+func (pl pseudoHermitianLanczos) applyH(Hx, Lx []complex128) {
+	half := pl.n / 2
+	for idx := 0; idx < half; idx++ {
+		Hx[idx] = complex(pl.scale[idx], 0) * Lx[half+idx]
+		Hx[half+idx] = -complex(pl.scale[half+idx], 0) * Lx[idx]
+	}
+}
+
+// solve performs steps Lanczos steps from a random start vector, and returns the converged modes of positive frequency,
+// each followed by its partner of negative frequency.
+func (pl pseudoHermitianLanczos) solve(steps int, seed int64) ([]float64, [][]complex128) {
+
+	V, θ, Y, β := pl.run(steps, seed)
+	m := len(V)
+	n := pl.n
+
+	// the residual of a Ritz pair is β |y_m|, where β is the norm of the next Lanczos vector, which is zero if the space is exhausted.
+	var freqs []float64
+	var modes [][]complex128
+	for k := 0; k < m; k++ {
+
+		if θ[k] <= 0 || β*math.Abs(Y.At(m-1, k)) > LANCZOS_TOL*θ[k] {
+			continue
+		}
+
+		x := make([]complex128, n)
+		for j := 0; j < m; j++ {
+			y := complex(Y.At(j, k), 0)
+			for i := range x {
+				x[i] += y * V[j][i]
+			}
+		}
+		normaliseComplex(x)
+
+		partner := make([]complex128, n)
+		for i := range x {
+			partner[i] = cmplx.Conj(x[i])
+		}
+
+		freqs = append(freqs, θ[k], -θ[k])
+		modes = append(modes, x, partner)
+	}
+
+	return freqs, modes
+}
+
+// run performs steps Lanczos steps from a random start vector, and returns the Lanczos vectors, the eigenvalues and eigenvectors
+// of the tridiagonal matrix, and the norm of the next Lanczos vector, which is zero if the Krylov space is exhausted.
+func (pl pseudoHermitianLanczos) run(steps int, seed int64) ([][]complex128, []float64, *mat.Dense, float64) {
+
+	n := pl.n
+	if steps > n {
+		steps = n
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	v := make([]complex128, n)
+	for i := range v {
+		if pl.scale[i] != 0 {
+			v[i] = complex(rng.NormFloat64(), rng.NormFloat64())
+		}
+	}
+	Lv := make([]complex128, n)
+	Hv := make([]complex128, n)
+	pl.applyL(Lv, v)
+	pl.applyH(Hv, Lv)
+	β := hNorm(v, Hv)
+
+	var V, HV [][]complex128
+	var αs, βs []float64
+
+	for j := 0; j < steps; j++ {
+
+		interrupt()
+		progress(j, -1, nil)
+
+		scaleComplex(v, 1/β)
+		scaleComplex(Lv, 1/β)
+		scaleComplex(Hv, 1/β)
+		V = append(V, v)
+		HV = append(HV, Hv)
+
+		// w = A v_j - α v_j - β v_{j-1}, with A = -iL.
+		w := make([]complex128, n)
+		for i := range w {
+			w[i] = complex(0, -1) * Lv[i]
+		}
+		α := real(dotc(Hv, w))
+		αs = append(αs, α)
+
+		// full reorthogonalisation in the H inner product, twice for stability, which includes the three term recurrence.
+		for pass := 0; pass < 2; pass++ {
+			for k := range V {
+				c := dotc(HV[k], w)
+				for i := range w {
+					w[i] -= c * V[k][i]
+				}
+			}
+		}
+
+		Lw := make([]complex128, n)
+		Hw := make([]complex128, n)
+		pl.applyL(Lw, w)
+		pl.applyH(Hw, Lw)
+
+		// if the Krylov space is invariant, all its Ritz pairs are exact.
+		if real(dotc(w, Hw)) < 1e-24*α*α {
+			β = 0
+			break
+		}
+		β = hNorm(w, Hw)
+		if j == steps-1 {
+			break
+		}
+		βs = append(βs, β)
+
+		v, Lv, Hv = w, Lw, Hw
+	}
+
+	m := len(αs)
+	T := mat.NewSymDense(m, nil)
+	for i := 0; i < m; i++ {
+		T.SetSym(i, i, αs[i])
+		if i+1 < m {
+			T.SetSym(i, i+1, βs[i])
+		}
+	}
+	var eig mat.EigenSym
+	if !eig.Factorize(T, true) {
+		panic(&BackendError{Routine: "EigenSym", Reason: "eigendecomposition of the Lanczos tridiagonal matrix failed"})
+	}
+	θ := eig.Values(nil)
+	Y := new(mat.Dense)
+	eig.VectorsTo(Y)
+
+	return V, θ, Y, β
+}
+
+// bound returns an upper bound on the frequencies, from the largest Ritz frequency of steps Lanczos steps and the norm of the next Lanczos vector.
+func (pl pseudoHermitianLanczos) bound(steps int, seed int64) float64 {
+	_, θ, _, β := pl.run(steps, seed)
+	largest := 0.
+	for _, t := range θ {
+		largest = math.Max(largest, math.Abs(t))
+	}
+	return largest + β
+}
+
+// hNorm returns sqrt(x* H x) from Hx = H x. It panics if H is not positive for x, as it is not for an unstable ground state.
+func hNorm(x, Hx []complex128) float64 {
+	n2 := real(dotc(x, Hx))
+	if n2 <= 0 {
+		panic(&OptionError{Option: "ground state", Reason: "the linear Hamiltonian is not positive definite: the ground state is not stable (see Stability)"})
+	}
+	return math.Sqrt(n2)
+}
+
+// dotc returns a* b.
+func dotc(a, b []complex128) complex128 {
+	var s complex128
+	for i := range a {
+		s += cmplx.Conj(a[i]) * b[i]
+	}
+	return s
+}
+
+func scaleComplex(x []complex128, f float64) {
+	for i := range x {
+		x[i] *= complex(f, 0)
+	}
+}
+
+// normaliseComplex scales x to unit 2-norm.
+func normaliseComplex(x []complex128) {
+	scaleComplex(x, 1/math.Sqrt(real(dotc(x, x))))
+}
+
+// lanczosModes turns the vectors of length 2 * Nx * Ny * Nz in the local frame of rot into three component modes.
+func lanczosModes(vectors [][]complex128, derotate func(CSlice) CSlice) []CSlice {
+	modes := make([]CSlice, len(vectors))
+	for p, v := range vectors {
+		modes[p] = derotate(transverseMode(v, en.MeshSize()))
+	}
+	return modes
+}
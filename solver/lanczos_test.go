@@ -0,0 +1,48 @@
+package solver
+
+import (
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestLanczos checks that the Lanczos solvers, taking a step for every degree of freedom, find the modes found by Straight,
+// in ± frequency pairs.
+func TestLanczos(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(Straight)
+		valsA, vecsA := Modes()
+
+		steps := 2 * en.Mesh().NCell()
+		for name, solver := range map[string]EigenSolver{
+			"LanczosField":  LanczosField{Steps: steps},
+			"LanczosMatrix": LanczosMatrix{Steps: steps},
+		} {
+			Solver = solver
+			valsB, vecsB := Modes()
+
+			if len(valsB) == 0 {
+				t.Fatalf("%d: %s found no modes", test_idx, name)
+			}
+			for p := 0; p < len(valsB); p += 2 {
+				if tests.EqualScalars(valsB[p], -valsB[p+1], 1e-6) > 0 {
+					t.Errorf("%d: %s mode %d is not paired: %g, %g", test_idx, name, p, valsB[p], valsB[p+1])
+				}
+			}
+
+			err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+			if err > 0 {
+				t.Errorf("%d: %s decompositions are not equal: %d%% error", test_idx, name, 100*err/len(valsB))
+			}
+		}
+	}
+}
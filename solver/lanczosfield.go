@@ -1,56 +1,65 @@
 package solver
 
-type tridiagonal struct {
-	Td, To []complex64
+import (
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// A LanczosField solver returns the modes of the system by the pseudo-Hermitian Lanczos method, which preserves the Bogoliubov
+// structure of the spin waves: the frequencies are real and come in ± pairs, and the modes are σ-orthogonal.
+// It applies the linear evolution of the system in the local frame of the ground state on the GPU, and needs a stable ground state without Damping.
+// It converges first to the modes at the ends of the spectrum, of highest frequency; ArnoldiField finds those of lowest frequency.
+type LanczosField struct {
+	eigenSolver
+	Steps int // the number of Lanczos steps. If it is zero, LANCZOS_STEPS are taken.
 }
 
-/*
-func tridiagonalField(m int) ([]CSlice, tridiagonal) {
+// Modes returns the converged eigenfrequencies and corresponding eigenmodes of the system, each mode followed by its partner of opposite frequency.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation is currently stored in en.M.
+func (solver LanczosField) Modes() ([]float64, []CSlice) {
 
-	V := make([]CSlice, Nx*Ny*Nz*2) //this dimension because we enforce only working with vectors perpendicular to the ground state
-	Td := make([]complex64, m)
-	To := make([]complex64, m-1)
+	rotOp := newRotatedOperator()
+	defer rotOp.free()
+	applyL, free := hostOperator(rotOp)
+	defer free()
 
-	operator := field.NewLinearEvolution()
-
-	//generate a random initial slice and enforce that it is perpendicular to the ground state
-	vPrev := RandomPerpendicular(en.M.Buffer())
-
-	V[0] = NewCSliceCPU(3, en.Mesh().Size())
-	Copy(V[0], vPrev)
-
-	u := operator.Operate(vPrev)
-	// α is real.
-	α := Dotc(u, vPrev)
-	CMadd2(u, u, vPrev, -α, 1)
-
-	Td[0] = α
-
-	v := NewCSlice(3, en.Mesh().Size())
-
-	// other iterations
-	for j := 1; j < m; j++ {
-		β := float32(math.Sqrt(float64(real(Dotc(u, u)))))
-		SScal(v, u, 1/β)
-
-		V[j] = NewCSliceCPU(3, en.Mesh().Size())
-		Copy(V[j], v)
-
-		u = operator.Operate(v)
-		α = Dotc(u, v)
-
-		SMadd2(u, u, vPrev, 1, -β)
-		CMadd2(u, u, v, 1, -α)
-
-		temp := vPrev
-		vPrev = v
-		v = temp
+	steps := solver.Steps
+	if steps == 0 {
+		steps = LANCZOS_STEPS
+	}
+	freqs, vectors := newPseudoHermitianLanczos(applyL).solve(steps, 0)
 
-		Td[j] = α
-		To[j-1] = complex(β, 0)
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+	return freqs, lanczosModes(vectors, rot.DerotateMode)
+}
 
+// hostOperator returns a function which applies the linear evolution of op to complex host vectors of length 2 * Nx * Ny * Nz,
+// through slices on the GPU, and a function which frees them.
+func hostOperator(op *rotatedOperator) (func(y, x []complex128), func()) {
+
+	NCell := en.Mesh().NCell()
+	size := en.MeshSize()
+	src := NewCSlice(2, size)
+	dst := NewCSlice(2, size)
+
+	apply := func(y, x []complex128) {
+		Copy(src, transverseMode(x, size))
+		op.applyComplex(dst, src)
+		yRe := dst.Real().HostCopy().Host()
+		yIm := dst.Imag().HostCopy().Host()
+		for c := 0; c < 2; c++ {
+			for idx := 0; idx < NCell; idx++ {
+				y[c*NCell+idx] = complex(float64(yRe[c][idx]), float64(yIm[c][idx]))
+			}
+		}
 	}
-
-	return V, tridiagonal{Td, To}
+	free := func() {
+		src.Free()
+		dst.Free()
+	}
+	return apply, free
 }
-*/
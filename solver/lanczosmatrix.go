@@ -1,64 +1,57 @@
 package solver
 
 import (
-	"math"
-	"math/rand"
-
-	cblas "gonum.org/v1/gonum/blas/cblas128"
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
 )
 
+// A LanczosMatrix solver returns the modes of the system by the pseudo-Hermitian Lanczos method, like LanczosField,
+// but applying the eigenproblem tensor rotated to the local frame of the ground state.
+// Taking as many steps as there are degrees of freedom, 2*Nx*Ny*Nz, it returns all the modes.
 type LanczosMatrix struct {
 	eigenSolver
-	R [][][][3][3]float64
+	Steps int // the number of Lanczos steps. If it is zero, LANCZOS_STEPS are taken.
 }
 
-func tridiagonalMatrix(H cblas.Hermitian, m int) {
-
-	n := 4
-
-	Td := make([]complex128, m)
-	Tu := make([]float64, m-1)
-
-	//Define the Lanczos vectors
-	V := make([]cblas.Vector, n)
+// Modes returns the converged eigenfrequencies and corresponding eigenmodes of the system, each mode followed by its partner of opposite frequency.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation is currently stored in en.M.
+func (solver LanczosMatrix) Modes() ([]float64, []CSlice) {
+	t := mag.EigenProblemTensor()
+	return solver.Solve(t)
+}
 
-	//Generate a random unit vector V[0]
-	V[0] = cblas.Vector{n, 1, make([]complex128, n)}
-	for j := 0; j < n; j++ {
-		V[0].Data[j] = complex(rand.Float64(), rand.Float64())
+// Solve returns the converged eigenpairs of a particular input Tensor, which must be an undamped eigenproblem tensor.
+func (solver LanczosMatrix) Solve(t Tensor) ([]float64, []CSlice) {
+
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+	twoD := rot.RotateTensor(t).XY()
+	arr := twoD.To1D()
+	n := 2 * twoD.Length()
+
+	re := make([]float64, n)
+	im := make([]float64, n)
+	Lre := make([]float64, n)
+	Lim := make([]float64, n)
+
+	applyL := func(y, x []complex128) {
+		for i := range x {
+			re[i] = real(x[i])
+			im[i] = imag(x[i])
+		}
+		matvecmul(n, n, arr, re, Lre)
+		matvecmul(n, n, arr, im, Lim)
+		for i := range y {
+			y[i] = complex(Lre[i], Lim[i])
+		}
 	}
-	normalise(V[0])
-
-	//Initial iteration, u initially corresponds to w but is overwritten
-	u := cblas.Vector{n, 1, make([]complex128, n)}
-
-	//perform the matrix vector multiplication H * V[0] and store the result in u
-	cblas.Hemv(1, H, V[0], 0, u)
-
-	α := cblas.Dotc(u, V[0])
-	Td[0] = α
-
-	//I am overwriting the w value is overwritten with u here!
-	cblas.Axpy(-α, V[0], u)
-
-	//other iterations
-	for j := 1; j < m; j++ {
-		β := math.Sqrt(real(cblas.Dotc(u, u)))
-		Tu[j-1] = β
-		cblas.Dscal(1/β, u) //so u now represents the value of V[j]
-		V[j] = u
-		u = cblas.Vector{n, 1, make([]complex128, n)}
-		cblas.Hemv(1, H, V[j], 0, u)
-		α = cblas.Dotc(u, V[0])
-		Td[j] = α
-		cblas.Axpy(-α, V[j], u)
-		cblas.Axpy(complex(-β, 0), V[j-1], u)
 
+	steps := solver.Steps
+	if steps == 0 {
+		steps = LANCZOS_STEPS
 	}
+	freqs, vectors := newPseudoHermitianLanczos(applyL).solve(steps, 0)
 
-}
-
-func normalise(v cblas.Vector) {
-	magnitude := math.Sqrt(real(cblas.Dotc(v, v)))
-	cblas.Dscal(1/magnitude, v)
+	return freqs, lanczosModes(vectors, rot.DerotateMode)
 }
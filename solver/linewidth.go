@@ -0,0 +1,72 @@
+package solver
+
+import (
+	"github.com/mumax/3/cuda"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+// Linewidths returns a first order estimate of the decay rate Γ that the Gilbert damping en.Alpha gives each of the undamped modes.
+// Writing a mode as ψ = a + ib, to first order in α,
+// Γ = -ω Σ_r α_r Ms_r |ψ_r|² / (2 Σ_r Ms_r m_r.(a_r × b_r)),
+// so it needs no further applications of the operator, unlike solving with Damping set.
+// The modes are those returned by Modes, with three components perpendicular to the ground state stored in en.M.
+func Linewidths(freqs []float64, modes []CSlice) []float64 {
+
+	m := en.M.Buffer().HostCopy().Vectors()
+
+	msatGPU, rM := en.Msat.Slice()
+	ms := msatGPU.HostCopy().Scalars()
+	if rM {
+		cuda.Recycle(msatGPU)
+	}
+
+	alphaGPU, rA := en.Alpha.Slice()
+	alpha := alphaGPU.HostCopy().Scalars()
+	if rA {
+		cuda.Recycle(alphaGPU)
+	}
+
+	size := en.MeshSize()
+	Γ := make([]float64, len(freqs))
+
+	for p, mode := range modes {
+
+		if !mode.CPUAccess() {
+			mode = mode.HostCopy()
+		}
+		a := mode.Real().Vectors()
+		b := mode.Imag().Vectors()
+
+		var dissipation, precession float64
+		for k := 0; k < size[2]; k++ {
+			for j := 0; j < size[1]; j++ {
+				for i := 0; i < size[0]; i++ {
+
+					ψ2 := 0.
+					for c := 0; c < 3; c++ {
+						ψ2 += float64(a[c][k][j][i]*a[c][k][j][i] + b[c][k][j][i]*b[c][k][j][i])
+					}
+
+					axb := [3]float64{
+						float64(a[1][k][j][i]*b[2][k][j][i] - a[2][k][j][i]*b[1][k][j][i]),
+						float64(a[2][k][j][i]*b[0][k][j][i] - a[0][k][j][i]*b[2][k][j][i]),
+						float64(a[0][k][j][i]*b[1][k][j][i] - a[1][k][j][i]*b[0][k][j][i]),
+					}
+					maxb := 0.
+					for c := 0; c < 3; c++ {
+						maxb += float64(m[c][k][j][i]) * axb[c]
+					}
+
+					dissipation += float64(alpha[k][j][i]*ms[k][j][i]) * ψ2
+					precession += float64(ms[k][j][i]) * maxb
+				}
+			}
+		}
+
+		Γ[p] = -freqs[p] * dissipation / (2 * precession)
+	}
+
+	return Γ
+}
@@ -0,0 +1,371 @@
+package solver
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"sort"
+
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+var (
+	LOBPCG_TOL     = 1e-4 // the residual, relative to the frequency, at which a mode has converged.
+	LOBPCG_MAXITER = 500  // the maximum number of iterations.
+)
+
+// A LOBPCG solver returns the NEV modes of lowest frequency by the locally optimal block preconditioned conjugate gradient method,
+// for a stable ground state without Damping. In the local frame of the ground state, the modes solve H x = ω K x, where H is the
+// linear Hamiltonian, which is positive definite, and K = (Ms/γ)(-iJ) is the metric of the spin waves (see pseudoHermitianLanczos).
+// The modes of lowest positive frequency are those of the smallest eigenvalues μ = -1/ω of the symmetric definite problem -K x = μ H x,
+// which LOBPCG finds with a block of vectors, applying H on the GPU without forming it and preconditioning with the inverse of its local part at each cell.
+// It suits large meshes, where the Arnoldi solvers converge slowly to the smallest frequencies.
+type LOBPCG struct {
+	eigenSolver
+	NEV int // the number of modes to find, counting each of a ± pair. If it is zero, ARNOLDI_NEV are found.
+}
+
+// Modes returns the eigenfrequencies and corresponding eigenmodes of the system, each mode followed by its partner of opposite frequency.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation is currently stored in en.M.
+func (solver LOBPCG) Modes() ([]float64, []CSlice) {
+
+	if Damping || SpinTransfer {
+		panic(&OptionError{Option: "dynamics", Reason: "LOBPCG needs the undamped dynamics without spin-transfer torques"})
+	}
+
+	nev := solver.NEV
+	if nev == 0 {
+		nev = ARNOLDI_NEV
+	}
+	k := (nev + 1) / 2
+
+	NCell := en.Mesh().NCell()
+	size := en.MeshSize()
+	ms := mag.HostSlice(en.Msat).Host()[0]
+
+	rotOp := newRotatedOperator()
+	defer rotOp.free()
+	src := NewCSlice(2, size)
+	defer src.Free()
+	dst := NewCSlice(2, size)
+	defer dst.Free()
+
+	// H x, from the field driving the precession multiplied by Ms.
+	applyH := func(y, x []complex128) {
+		Copy(src, transverseMode(x, size))
+		rotOp.applyHamiltonian(dst.Real(), src.Real())
+		rotOp.applyHamiltonian(dst.Imag(), src.Imag())
+		yRe := dst.Real().HostCopy().Host()
+		yIm := dst.Imag().HostCopy().Host()
+		for c := 0; c < 2; c++ {
+			for idx := 0; idx < NCell; idx++ {
+				m := float64(ms[idx])
+				y[c*NCell+idx] = complex(m*float64(yRe[c][idx]), m*float64(yIm[c][idx]))
+			}
+		}
+	}
+
+	// K x = (Ms/γ)(-iJ) x.
+	applyK := func(y, x []complex128) {
+		for idx := 0; idx < NCell; idx++ {
+			s := complex(float64(ms[idx])/en.GammaLL, 0)
+			y[idx] = complex(0, 1) * s * x[NCell+idx]
+			y[NCell+idx] = complex(0, -1) * s * x[idx]
+		}
+	}
+
+	// the inverse of the local part of H at each cell.
+	h := localHamiltonianXY()
+	applyT := func(y, x []complex128) {
+		for idx := 0; idx < NCell; idx++ {
+			a, b, c, d := h[idx][0][0], h[idx][0][1], h[idx][1][0], h[idx][1][1]
+			det := a*d - b*c
+			if ms[idx] == 0 || det <= 0 {
+				y[idx], y[NCell+idx] = x[idx], x[NCell+idx]
+				continue
+			}
+			y[idx] = complex(d/det, 0)*x[idx] - complex(b/det, 0)*x[NCell+idx]
+			y[NCell+idx] = -complex(c/det, 0)*x[idx] + complex(a/det, 0)*x[NCell+idx]
+		}
+	}
+
+	rng := rand.New(rand.NewSource(0))
+	X := make([][]complex128, k)
+	for i := range X {
+		X[i] = make([]complex128, 2*NCell)
+		for j := range X[i] {
+			if ms[j%NCell] != 0 {
+				X[i][j] = complex(rng.NormFloat64(), rng.NormFloat64())
+			}
+		}
+	}
+
+	lp := lobpcg{applyH: applyH, applyK: applyK, precond: applyT}
+	ω, vectors := lp.solve(X, LOBPCG_TOL, LOBPCG_MAXITER)
+
+	var freqs []float64
+	var paired [][]complex128
+	for p := range ω {
+		x := vectors[p]
+		normaliseComplex(x)
+		partner := make([]complex128, len(x))
+		for i := range x {
+			partner[i] = cmplx.Conj(x[i])
+		}
+		freqs = append(freqs, ω[p], -ω[p])
+		paired = append(paired, x, partner)
+	}
+
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+	return freqs, lanczosModes(paired, rot.DerotateMode)
+}
+
+// A lobpcg finds the smallest eigenvalues μ of -K x = μ H x, with H Hermitian positive definite and K Hermitian,
+// given by applyH and applyK, which set dst from src. precond approximates H⁻¹.
+type lobpcg struct {
+	applyH, applyK, precond func(dst, src []complex128)
+}
+
+// solve iterates from the block X, and returns the frequencies ω = -1/μ of the len(X) smallest eigenvalues, in increasing order,
+// with their eigenvectors. The eigenvectors are H-orthonormal. Converged vectors stay in the block, but are no longer
+// given a search direction.
+func (lp lobpcg) solve(X [][]complex128, tol float64, maxIter int) ([]float64, [][]complex128) {
+
+	k := len(X)
+	n := len(X[0])
+
+	HX := lp.applyAll(lp.applyH, X)
+	Z, HZ := hOrthonormalise(nil, nil, X, HX)
+	if len(Z) < k {
+		panic(&OptionError{Option: "NEV", Reason: "the starting block of LOBPCG is degenerate"})
+	}
+	μ, C := lp.rayleighRitz(Z, k)
+	X, HX = combine(Z, C, 0), combine(HZ, C, 0)
+
+	var P, HP [][]complex128
+
+	for iter := 0; ; iter++ {
+
+		var W [][]complex128
+		r := make([]complex128, n)
+		KX := lp.applyAll(lp.applyK, X)
+		for i := range X {
+			for j := range r {
+				r[j] = -KX[i][j] - complex(μ[i], 0)*HX[i][j]
+			}
+			residual := math.Sqrt(real(dotc(r, r))) / (math.Abs(μ[i]) * math.Sqrt(real(dotc(HX[i], HX[i]))))
+			if residual < tol {
+				continue
+			}
+			w := make([]complex128, n)
+			lp.precond(w, r)
+			W = append(W, w)
+		}
+
+		ritz := make([]complex128, k)
+		for i := range μ {
+			ritz[i] = complex(μ[i], 0)
+		}
+		progress(iter, k-len(W), ritz)
+
+		if len(W) == 0 || iter == maxIter {
+			if len(W) > 0 {
+				util.Log(fmt.Sprintf("LOBPCG stopped after %d iterations with %d of %d modes converged.", iter, k-len(W), k))
+				notConverged(&ConvergenceError{Solver: "LOBPCG", Converged: k - len(W), Wanted: k, Iterations: iter})
+			} else {
+				util.Log(fmt.Sprintf("Found %d modes in %d iterations.", k, iter))
+			}
+			break
+		}
+
+		// the search space of the block, its residuals, and its previous directions, orthonormalised in the H inner product.
+		Z, HZ = hOrthonormalise(nil, nil, X, HX)
+		Z, HZ = hOrthonormalise(Z, HZ, W, lp.applyAll(lp.applyH, W))
+		Z, HZ = hOrthonormalise(Z, HZ, P, HP)
+
+		μ, C = lp.rayleighRitz(Z, k)
+		X, HX = combine(Z, C, 0), combine(HZ, C, 0)
+		P, HP = combine(Z, C, k), combine(HZ, C, k)
+	}
+
+	ω := make([]float64, k)
+	for i := range μ {
+		if μ[i] >= 0 {
+			panic(&OptionError{Option: "ground state", Reason: "LOBPCG found fewer modes of positive frequency than sought; the ground state may not be stable (see Stability)"})
+		}
+		ω[i] = -1 / μ[i]
+	}
+	return ω, X
+}
+
+func (lp lobpcg) applyAll(op func(dst, src []complex128), X [][]complex128) [][]complex128 {
+	Y := make([][]complex128, len(X))
+	for i := range X {
+		Y[i] = make([]complex128, len(X[i]))
+		op(Y[i], X[i])
+	}
+	return Y
+}
+
+// rayleighRitz returns the k smallest eigenvalues of Z* (-K) Z, for the H-orthonormal basis Z, and the coefficients of their eigenvectors.
+func (lp lobpcg) rayleighRitz(Z [][]complex128, k int) ([]float64, [][]complex128) {
+
+	KZ := lp.applyAll(lp.applyK, Z)
+	d := len(Z)
+	G := make([][]complex128, d)
+	for i := range G {
+		G[i] = make([]complex128, d)
+		for j := range G[i] {
+			G[i][j] = -dotc(Z[i], KZ[j])
+		}
+	}
+
+	μ, V := hermitianEigen(G)
+
+	C := make([][]complex128, d)
+	for i := range C {
+		C[i] = V[i][:k]
+	}
+	return μ[:k], C
+}
+
+// combine returns the vectors Σ_{i >= from} Z_i C_ij, for each column j of C.
+func combine(Z, C [][]complex128, from int) [][]complex128 {
+	if len(C) == 0 {
+		return nil
+	}
+	Y := make([][]complex128, len(C[0]))
+	for j := range Y {
+		Y[j] = make([]complex128, len(Z[0]))
+		for i := from; i < len(Z); i++ {
+			c := C[i][j]
+			for l := range Y[j] {
+				Y[j][l] += c * Z[i][l]
+			}
+		}
+	}
+	return Y
+}
+
+// hOrthonormalise appends the vectors X to the H-orthonormal basis Z, orthonormalising them in the H inner product by
+// modified Gram-Schmidt, twice for stability. HX holds H X, and the H images of the basis are returned with it.
+// Vectors which are nearly dependent on the basis are dropped.
+func hOrthonormalise(Z, HZ, X, HX [][]complex128) ([][]complex128, [][]complex128) {
+
+	for p := range X {
+		x := append([]complex128(nil), X[p]...)
+		Hx := append([]complex128(nil), HX[p]...)
+		n0 := math.Sqrt(math.Abs(real(dotc(x, Hx))))
+
+		for pass := 0; pass < 2; pass++ {
+			for q := range Z {
+				c := dotc(HZ[q], x)
+				for i := range x {
+					x[i] -= c * Z[q][i]
+					Hx[i] -= c * HZ[q][i]
+				}
+			}
+		}
+
+		if real(dotc(x, Hx)) < 1e-16*n0*n0 {
+			continue
+		}
+		s := 1 / hNorm(x, Hx)
+		scaleComplex(x, s)
+		scaleComplex(Hx, s)
+		Z = append(Z, x)
+		HZ = append(HZ, Hx)
+	}
+	return Z, HZ
+}
+
+// hermitianEigen returns the eigenvalues, in increasing order, and eigenvectors, in the columns, of the Hermitian matrix a by the cyclic Jacobi method.
+// a is overwritten.
+func hermitianEigen(a [][]complex128) ([]float64, [][]complex128) {
+
+	n := len(a)
+	v := make([][]complex128, n)
+	for i := range v {
+		v[i] = make([]complex128, n)
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+
+		off, total := 0., 0.
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				a2 := real(a[i][j] * cmplx.Conj(a[i][j]))
+				total += a2
+				if i != j {
+					off += a2
+				}
+			}
+		}
+		if off <= 1e-28*total {
+			break
+		}
+
+		for p := 0; p < n-1; p++ {
+			for q := p + 1; q < n; q++ {
+
+				r := cmplx.Abs(a[p][q])
+				if r == 0 {
+					continue
+				}
+
+				// G = diag(1, e^{-iφ}) R, where the phase makes the (p, q) element real and R is the real Jacobi rotation which removes it.
+				phase := cmplx.Conj(a[p][q]) / complex(r, 0)
+				ζ := (real(a[q][q]) - real(a[p][p])) / (2 * r)
+				t := 1 / (math.Abs(ζ) + math.Sqrt(1+ζ*ζ))
+				if ζ < 0 {
+					t = -t
+				}
+				c := 1 / math.Sqrt(1+t*t)
+				s := t * c
+				gpp, gpq := complex(c, 0), complex(s, 0)
+				gqp, gqq := -complex(s, 0)*phase, complex(c, 0)*phase
+
+				for i := 0; i < n; i++ {
+					aip, aiq := a[i][p], a[i][q]
+					a[i][p] = aip*gpp + aiq*gqp
+					a[i][q] = aip*gpq + aiq*gqq
+					vip, viq := v[i][p], v[i][q]
+					v[i][p] = vip*gpp + viq*gqp
+					v[i][q] = vip*gpq + viq*gqq
+				}
+				for j := 0; j < n; j++ {
+					apj, aqj := a[p][j], a[q][j]
+					a[p][j] = cmplx.Conj(gpp)*apj + cmplx.Conj(gqp)*aqj
+					a[q][j] = cmplx.Conj(gpq)*apj + cmplx.Conj(gqq)*aqj
+				}
+			}
+		}
+	}
+
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(i, j int) bool { return real(a[order[i]][order[i]]) < real(a[order[j]][order[j]]) })
+
+	values := make([]float64, n)
+	vectors := make([][]complex128, n)
+	for i := range vectors {
+		vectors[i] = make([]complex128, n)
+	}
+	for j, o := range order {
+		values[j] = real(a[o][o])
+		for i := 0; i < n; i++ {
+			vectors[i][j] = v[i][o]
+		}
+	}
+	return values, vectors
+}
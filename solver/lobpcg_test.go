@@ -0,0 +1,48 @@
+package solver
+
+import (
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestLOBPCG checks that the lowest modes found by LOBPCG are found by RotatedToZ, and that they are the lowest.
+func TestLOBPCG(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		Solver = LOBPCG{NEV: 6}
+		valsB, vecsB := Modes()
+
+		if len(valsB) != 6 {
+			t.Fatalf("%d: found %d modes; want 6", test_idx, len(valsB))
+		}
+
+		err := tests.EqualSubDecompositions(vecsA, vecsB, valsA, valsB, 1e-3, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions are not equal: %d%% error", test_idx, 100*err/len(valsB))
+		}
+
+		// no mode of RotatedToZ lies below the highest found by LOBPCG without being found.
+		highest := valsB[len(valsB)-2]
+		below := 0
+		for _, f := range valsA {
+			if f > 0 && f < highest*(1-1e-3) {
+				below++
+			}
+		}
+		if below > len(valsB)/2-1 {
+			t.Errorf("%d: %d modes lie below the highest mode found by LOBPCG", test_idx, below)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package solver
+
+import (
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// ExcitationOverlaps returns the overlap Σ_r Ms_r V ψ_r*.h_r of each mode ψ with a driving field h, which must live on the CPU.
+// To first order, the amplitude to which a field h oscillating at the frequency of a mode excites it is proportional to this overlap.
+// The modes are those returned by Modes, with three components.
+func ExcitationOverlaps(modes []CSlice, h *data.Slice) []complex128 {
+
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	cellsize := en.Mesh().CellSize()
+	volume := cellsize[0] * cellsize[1] * cellsize[2]
+	hArr := h.Host()
+
+	overlaps := make([]complex128, len(modes))
+	for p, mode := range modes {
+
+		if !mode.CPUAccess() {
+			mode = mode.HostCopy()
+		}
+		a := mode.Real().Host()
+		b := mode.Imag().Host()
+
+		var overlap complex128
+		for c := 0; c < 3; c++ {
+			for idx := range hArr[c] {
+				overlap += complex(float64(ms[idx]*a[c][idx]*hArr[c][idx]), -float64(ms[idx]*b[c][idx]*hArr[c][idx]))
+			}
+		}
+		overlaps[p] = overlap * complex(volume, 0)
+	}
+
+	return overlaps
+}
+
+// StrainOverlaps returns the ExcitationOverlaps of the modes with the magnetoelastic drive field of a dynamic strain profile,
+// with the 6 components mag.STRAIN_XX, ... on the CPU.
+func StrainOverlaps(modes []CSlice, strain *data.Slice) []complex128 {
+	return ExcitationOverlaps(modes, mag.MagnetoelasticDriveField(strain))
+}
@@ -0,0 +1,46 @@
+package solver
+
+import (
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// unpinnedIndices returns the indices of the degrees of freedom which are not pinned by mag.PinnedSurfaces,
+// for vectors with nComp components ordered by component and then by cell.
+func unpinnedIndices(nComp int) []int {
+
+	pinned := mag.PinnedCells()
+	length := len(pinned)
+
+	var keep []int
+	for c := 0; c < nComp; c++ {
+		for idx := 0; idx < length; idx++ {
+			if !pinned[idx] {
+				keep = append(keep, c*length+idx)
+			}
+		}
+	}
+	return keep
+}
+
+// reduceMatrix returns the submatrix of the n x n row major matrix arr made of the rows and columns in keep.
+func reduceMatrix(arr []float64, n int, keep []int) []float64 {
+
+	m := len(keep)
+	reduced := make([]float64, m*m)
+	for p, row := range keep {
+		for q, col := range keep {
+			reduced[p*m+q] = arr[row*n+col]
+		}
+	}
+	return reduced
+}
+
+// expandVector returns the vector of length n with the elements of v at the indices in keep, and zero elsewhere.
+func expandVector(v []complex128, n int, keep []int) []complex128 {
+
+	expanded := make([]complex128, n)
+	for p, idx := range keep {
+		expanded[idx] = v[p]
+	}
+	return expanded
+}
@@ -0,0 +1,92 @@
+package solver
+
+import (
+	"math"
+	"sort"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestPinnedStandingModes checks the perpendicular standing spin waves of a film magnetised normal to its plane, without demagnetising interactions,
+// with both surfaces pinned. For M free layers of thickness d these are the discrete standing waves
+// ω_n = γ (B + (2 Aex / Msat) (2 / d²) (1 - cos(nπ / (M + 1)))).
+// The lowest of them are also checked for Straight, which diagonalises the pinned tensor, and for the matrix-free Krylov–Schur solver.
+// It also checks that ValidateSolver reports the pinning for TwoSublattice, which does not support it.
+func TestPinnedStandingModes(t *testing.T) {
+
+	defer en.InitAndClose()()
+	defer func() { mag.PinnedSurfaces = nil }()
+
+	Nz := 10
+	d := 2e-9
+	Msat := 8e5
+	Aex := 1.3e-11
+	B := 1.
+
+	Setup(`
+		SetGridSize(2, 2, 10)
+		SetCellSize(2e-9, 2e-9, 2e-9)
+		Msat = 8e5
+		Aex = 1.3e-11
+		EnableDemag = false
+		B_ext = vector(0, 0, 1)
+		m = uniform(0, 0, 1)
+	`)
+
+	mag.PinnedSurfaces = []mag.Surface{mag.BoundarySurface(mag.FACE_Z_MIN), mag.BoundarySurface(mag.FACE_Z_MAX)}
+
+	M := Nz - 2
+	for _, s := range []struct {
+		name   string
+		solver EigenSolver
+	}{
+		{"RotatedToZ", new(RotatedToZ)},
+		{"Straight", new(Straight)},
+		{"KrylovSchur", &KrylovSchur{NEV: 2 * M}},
+	} {
+
+		Solver = s.solver
+		freqs, _ := Modes()
+
+		if _, iterative := s.solver.(*KrylovSchur); !iterative && len(freqs) != 2*4*M {
+			t.Fatalf("%s: %d modes; want %d", s.name, len(freqs), 2*4*M)
+		}
+
+		var positive []float64
+		for _, f := range freqs {
+			if f > 0 {
+				positive = append(positive, f)
+			}
+		}
+		sort.Float64s(positive)
+		if len(positive) < M {
+			t.Fatalf("%s: %d positive frequencies; want at least %d", s.name, len(positive), M)
+		}
+
+		err := 0
+		for n := 1; n <= M; n++ {
+			want := en.GammaLL * (B + (2*Aex/Msat)*(2/(d*d))*(1-math.Cos(float64(n)*math.Pi/float64(M+1))))
+			err += tests.EqualScalars(want, positive[n-1], 1e-3)
+		}
+		if err > 0 {
+			t.Errorf("%s: Standing mode frequencies are not equal: %d%% error", s.name, 100*err/M)
+		}
+	}
+
+	// TwoSublattice does not pin, which must be reported whether the Solver is set to a value or a pointer.
+	for _, s := range []EigenSolver{TwoSublattice{}, new(TwoSublattice)} {
+		Solver = s
+		reported := false
+		for _, f := range ValidateSolver() {
+			reported = reported || f.Name == "PinnedSurfaces"
+		}
+		if !reported {
+			t.Errorf("ValidateSolver does not report the pinning for %T", s)
+		}
+	}
+}
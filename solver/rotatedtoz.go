@@ -10,6 +10,7 @@ import (
 // such that the z direction at each point coincides with the ground state direction at that point.
 // As a result (and because non-null eigenmodes are perpendicular to the ground state), the zero eigenmodes can be eliminated from the system
 // and hence a smaller matrix can be diagonalised.
+// The degrees of freedom of the cells in mag.PinnedSurfaces are also eliminated.
 // The time complexity is O((2*Nx*Ny*Nz)^3)
 type RotatedToZ struct {
 	eigenSolver
@@ -24,48 +25,99 @@ func (solver RotatedToZ) Modes() ([]float64, []CSlice) {
 	return solver.Solve(t)
 }
 
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the system, which include the decay rates when Damping is set.
+func (solver RotatedToZ) ComplexModes() ([]complex128, []CSlice) {
+	t := EigenProblemTensor()
+	return solver.SolveComplex(t)
+}
+
+// SystemModes returns the eigenfrequencies and corresponding eigenmodes of the System s, without the mumax engine.
+// None of its cells are pinned.
+func (solver RotatedToZ) SystemModes(s *mag.System) ([]float64, []CSlice) {
+	return realFrequencies(solver.SystemComplexModes(s))
+}
+
+// SystemComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the System s, which include the decay rates when s.Damping is set.
+func (solver RotatedToZ) SystemComplexModes(s *mag.System) ([]complex128, []CSlice) {
+
+	rot := new(mag.RotationToZ)
+	rot.InitRotationFrom(s.M)
+
+	n := s.Size[0] * s.Size[1] * s.Size[2]
+	keep := make([]int, 2*n)
+	for idx := range keep {
+		keep[idx] = idx
+	}
+	return solveRotated(s.EigenProblemTensor(), rot, keep)
+}
+
 // Solve returns the non-null eigenpairs of a particular input Tensor after taking cross product with the system magnetisation.
 func (solver RotatedToZ) Solve(t Tensor) ([]float64, []CSlice) {
+	return realFrequencies(solver.SolveComplex(t))
+}
+
+// SolveComplex returns the non-null eigenpairs of a particular input Tensor, with complex frequencies.
+func (solver RotatedToZ) SolveComplex(t Tensor) ([]complex128, []CSlice) {
 
 	rot := new(mag.RotationToZ)
 	rot.InitRotation()
+
+	// the pinned degrees of freedom are removed from the eigenproblem, and are zero in the modes.
+	return solveRotated(t, rot, unpinnedIndices(2))
+
+}
+
+// solveRotated returns the non-null eigenpairs of the Tensor t rotated by rot, keeping only the transverse degrees of freedom in keep.
+func solveRotated(t Tensor, rot *mag.RotationToZ, keep []int) ([]complex128, []CSlice) {
+
 	rotated := rot.RotateTensor(t)
 
 	twoD := rotated.XY()
 	arr := twoD.To1D()
 
 	totalSize := 2 * twoD.Length()
-	values, vectors := Eig(totalSize, arr)
 
-	freq := make([]float64, totalSize)
-	modes := make([]CSlice, totalSize)
+	values, vectors, err := Eig(len(keep), reduceMatrix(arr, totalSize, keep))
+	if err != nil {
+		panic(err)
+	}
+
+	freq := make([]complex128, len(values))
+	modes := make([]CSlice, len(values))
 
-	Nx := t.Size[0]
-	Ny := t.Size[1]
-	Nz := t.Size[2]
+	for p := range values {
 
-	for p := 0; p < totalSize; p++ {
+		freq[p] = complexFrequency(values[p])
+		vector := expandVector(vectors[p], totalSize, keep)
+		mode := transverseMode(vector, t.Size)
+		modes[p] = rot.DerotateMode(mode)
+	}
 
-		freq[p] = imag(values[p])
+	return freq, modes
 
-		mode := NewCSliceCPU(2, t.Size)
-		modeReal := mode.Real().Tensors()
-		modeImag := mode.Imag().Tensors()
+}
 
-		for c := 0; c < 2; c++ {
-			for i := 0; i < Nx; i++ {
-				for j := 0; j < Ny; j++ {
-					for k := 0; k < Nz; k++ {
-						modeReal[c][k][j][i] = float32(real(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-						modeImag[c][k][j][i] = float32(imag(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-					}
+// transverseMode returns the two component CSlice on the CPU, of a system of the given size, holding the vector ordered by component and then by cell.
+func transverseMode(vector []complex128, size [3]int) CSlice {
+
+	Nx := size[0]
+	Ny := size[1]
+	Nz := size[2]
+
+	mode := NewCSliceCPU(2, size)
+	modeReal := mode.Real().Tensors()
+	modeImag := mode.Imag().Tensors()
+
+	for c := 0; c < 2; c++ {
+		for i := 0; i < Nx; i++ {
+			for j := 0; j < Ny; j++ {
+				for k := 0; k < Nz; k++ {
+					modeReal[c][k][j][i] = float32(real(vector[c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
+					modeImag[c][k][j][i] = float32(imag(vector[c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
 				}
 			}
 		}
-
-		modes[p] = rot.DerotateMode(mode)
 	}
 
-	return freq, modes
-
+	return mode
 }
@@ -21,7 +21,9 @@ func TestSave(t *testing.T) {
 	Solver = new(RotatedToZ)
 	vals, vecs := Modes()
 
-	WriteModes(vals, vecs, "direct.out")
+	if err := WriteModes(vals, vecs, "direct.out"); err != nil {
+		t.Fatal(err)
+	}
 
 	mag.SelfInteractionTensor().ToCSV("direct.out/H.csv")
 	mag.LinearHamiltonianTensor().ToCSV("direct.out/H0.csv")
@@ -0,0 +1,263 @@
+package solver
+
+import (
+	"fmt"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/field"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// A rotatedOperator applies the linear evolution L of the system in the local frame of the ground state,
+// to two component slices on the GPU.
+type rotatedOperator struct {
+	le     *field.LinearEvolution
+	rot    *field.RotationToZ
+	x3, y3 *data.Slice
+}
+
+func newRotatedOperator() *rotatedOperator {
+	o := &rotatedOperator{le: field.NewLinearEvolution(), rot: new(field.RotationToZ)}
+	o.rot.InitRotation()
+	o.x3 = cuda.NewSlice(3, en.MeshSize())
+	o.y3 = cuda.NewSlice(3, en.MeshSize())
+	return o
+}
+
+func (o *rotatedOperator) apply(dst, src *data.Slice) {
+	interrupt()
+	o.rot.DerotateMode(o.x3, src)
+	o.le.Operate(o.y3, o.x3)
+	o.rot.RotateMode(dst, o.y3)
+}
+
+// applyHamiltonian sets dst to the field driving the precession of src, without the cross product of the linear evolution;
+// see field.LinearEvolution.OperateHamiltonian. This is the linear Hamiltonian in the local frame, divided by Ms.
+func (o *rotatedOperator) applyHamiltonian(dst, src *data.Slice) {
+	interrupt()
+	o.rot.DerotateMode(o.x3, src)
+	o.le.OperateHamiltonian(o.y3, o.x3)
+	o.rot.RotateMode(dst, o.y3)
+}
+
+func (o *rotatedOperator) applyComplex(dst, src CSlice) {
+	o.apply(dst.Real(), src.Real())
+	o.apply(dst.Imag(), src.Imag())
+}
+
+// applyBatch sets each dst[i] to the operation on src[i], as apply does, for a block of vectors at once with field.LinearEvolution.OperateBatch.
+func (o *rotatedOperator) applyBatch(dst, src []*data.Slice) {
+	interrupt()
+	x3 := make([]*data.Slice, len(src))
+	y3 := make([]*data.Slice, len(src))
+	for i := range src {
+		x3[i] = cuda.Buffer(3, en.MeshSize())
+		defer cuda.Recycle(x3[i])
+		y3[i] = cuda.Buffer(3, en.MeshSize())
+		defer cuda.Recycle(y3[i])
+		o.rot.DerotateMode(x3[i], src[i])
+	}
+	o.le.OperateBatch(y3, x3)
+	for i := range dst {
+		o.rot.RotateMode(dst[i], y3[i])
+	}
+}
+
+// applyComplexBatch applies the operator to the real and imaginary parts of the whole block in a single batch.
+func (o *rotatedOperator) applyComplexBatch(dst, src []CSlice) {
+	d := make([]*data.Slice, 0, 2*len(dst))
+	s := make([]*data.Slice, 0, 2*len(src))
+	for i := range src {
+		d = append(d, dst[i].Real(), dst[i].Imag())
+		s = append(s, src[i].Real(), src[i].Imag())
+	}
+	o.applyBatch(d, s)
+}
+
+func (o *rotatedOperator) free() {
+	o.le.Free()
+	o.rot.Free()
+	o.x3.Free()
+	o.y3.Free()
+}
+
+// A shiftInvert applies Re((L - iσ)⁻¹), whose largest eigenvalues belong to the modes of L with frequencies nearest ±σ,
+// by solving with GMRES preconditioned by the inverse of the local part of L - iσ at each cell.
+type shiftInvert struct {
+	op         *rotatedOperator
+	σ          float64
+	precond    [][2][2]complex128 // the inverse of the local part of L - iσ at each cell, indexed as Tensor.Idx.
+	b, z, Lz   CSlice
+	iterations int // the total number of GMRES iterations.
+}
+
+func newShiftInvert(op *rotatedOperator, σ float64) *shiftInvert {
+	size := en.MeshSize()
+	return &shiftInvert{
+		op:      op,
+		σ:       σ,
+		precond: localPreconditioner(complex(0, σ)),
+		b:       NewCSlice(2, size),
+		z:       NewCSlice(2, size),
+		Lz:      NewCSlice(2, size),
+	}
+}
+
+func (si *shiftInvert) free() {
+	si.b.Free()
+	si.z.Free()
+	si.Lz.Free()
+}
+
+// apply sets y = Re((L - iσ)⁻¹ x) for the host vectors x and y of length 2 * Nx * Ny * Nz.
+func (si *shiftInvert) apply(y, x []float32) {
+
+	n := en.Mesh().NCell()
+	data.Copy(si.b.Real(), data.SliceFromArray([][]float32{x[0:n], x[n : 2*n]}, en.MeshSize()))
+	cuda.Zero(si.b.Imag())
+	Zero(si.z)
+
+	a := func(dst, src CSlice) {
+		si.op.applyComplex(dst, src)
+		CMadd2(dst, dst, src, 1, complex64(complex(0, -si.σ)))
+	}
+	iter, residual := gmres(a, si.applyPreconditioner, si.z, si.b, GMRES_RESTART, GMRES_MAXITER, GMRES_TOL)
+	si.iterations += iter
+	if residual > GMRES_TOL {
+		util.Log(fmt.Sprintf("GMRES did not converge: relative residual %g after %d iterations.", residual, iter))
+	}
+
+	data.Copy(data.SliceFromArray([][]float32{y[0:n], y[n : 2*n]}, en.MeshSize()), si.z.Real())
+}
+
+// applyPreconditioner applies the inverse of the local part of L - iσ at each cell.
+func (si *shiftInvert) applyPreconditioner(dst, src CSlice) {
+	applyLocal(si.precond, dst, src)
+}
+
+// applyLocal applies the 2x2 block P[idx] at each cell to the two component CSlice src. This is done on the CPU.
+func applyLocal(precond [][2][2]complex128, dst, src CSlice) {
+
+	srcCPU := src.HostCopy()
+	sRe := srcCPU.Real().Host()
+	sIm := srcCPU.Imag().Host()
+
+	dstCPU := NewCSliceCPU(2, src.Size())
+	dRe := dstCPU.Real().Host()
+	dIm := dstCPU.Imag().Host()
+
+	for idx, P := range precond {
+		for p := 0; p < 2; p++ {
+			var d complex128
+			for q := 0; q < 2; q++ {
+				d += P[p][q] * complex(float64(sRe[q][idx]), float64(sIm[q][idx]))
+			}
+			dRe[p][idx] = float32(real(d))
+			dIm[p][idx] = float32(imag(d))
+		}
+	}
+
+	data.Copy(dst.Real(), dstCPU.Real())
+	data.Copy(dst.Imag(), dstCPU.Imag())
+}
+
+// rayleighQuotient returns v* L v / v* v for a vector v of length 2 * Nx * Ny * Nz in the local frame,
+// which recovers the eigenvalue of L from an eigenvector of the shift-inverted operator.
+func (si *shiftInvert) rayleighQuotient(v []complex128) complex128 {
+
+	vCPU := transverseMode(v, en.MeshSize())
+	Copy(si.z, vCPU)
+	si.op.applyComplex(si.Lz, si.z)
+	return complex128(Dotc(si.z, si.Lz) / Dotc(si.z, si.z))
+}
+
+// localPreconditioner returns the inverse of the local part of L - shift at each cell, in the local frame of the ground state,
+// from the local part of the linear Hamiltonian (see mag.LocalHamiltonian) and the local dynamics, including damping.
+func localPreconditioner(shift complex128) [][2][2]complex128 {
+
+	h := localHamiltonianXY()
+
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	var alpha []float32
+	if Damping {
+		alpha = mag.HostSlice(en.Alpha).Host()[0]
+	}
+
+	P := make([][2][2]complex128, len(h))
+
+	for idx := range h {
+
+		if ms[idx] == 0 {
+			continue
+		}
+
+		// the local dynamics, as in mag.DynamicOperateRotated.
+		α := 0.
+		if alpha != nil {
+			α = float64(alpha[idx])
+		}
+		factor := en.GammaLL / (float64(ms[idx]) * (1 + α*α))
+		var L [2][2]complex128
+		for q := 0; q < 2; q++ {
+			L[0][q] = complex((-h[idx][1][q]-α*h[idx][0][q])*factor, 0)
+			L[1][q] = complex((h[idx][0][q]-α*h[idx][1][q])*factor, 0)
+		}
+		L[0][0] -= shift
+		L[1][1] -= shift
+
+		det := L[0][0]*L[1][1] - L[0][1]*L[1][0]
+		if det == 0 {
+			P[idx] = [2][2]complex128{{1, 0}, {0, 1}}
+			continue
+		}
+		P[idx] = [2][2]complex128{
+			{L[1][1] / det, -L[0][1] / det},
+			{-L[1][0] / det, L[0][0] / det},
+		}
+	}
+
+	return P
+}
+
+// localHamiltonianXY returns the local part of the linear Hamiltonian (see mag.LocalHamiltonian) at each cell in the local frame of the ground state,
+// R H Rᵀ restricted to the transverse components, indexed as Tensor.Idx. It is zero at the cells without magnetisation.
+func localHamiltonianXY() [][2][2]float64 {
+
+	H := mag.LocalHamiltonian()
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+
+	ms := mag.HostSlice(en.Msat).Host()[0]
+	size := en.MeshSize()
+	h := make([][2][2]float64, len(H))
+
+	for k := 0; k < size[2]; k++ {
+		for j := 0; j < size[1]; j++ {
+			for i := 0; i < size[0]; i++ {
+
+				idx := size[0]*(size[1]*k+j) + i
+				if ms[idx] == 0 {
+					continue
+				}
+
+				R := rot.R[k][j][i]
+				for p := 0; p < 2; p++ {
+					for q := 0; q < 2; q++ {
+						for r := 0; r < 3; r++ {
+							for s := 0; s < 3; s++ {
+								h[idx][p][q] += R[p][r] * H[idx][r][s] * R[q][s]
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return h
+}
@@ -0,0 +1,312 @@
+package solver
+
+import (
+	"fmt"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+	"github.com/mumax/3/util"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/field"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+var (
+	SLICE_PROBES  = 8    // the number of random vectors used to estimate the number of modes in a window.
+	SLICE_NODES   = 8    // the number of quadrature nodes on the contour around a window.
+	SLICE_OVERLAP = 0.05 // the fraction of its width by which each window is extended at its edges, so modes at the edges are not lost.
+	SLICE_RETRIES = 2    // the number of times the number of modes sought in a window is doubled if too few are found.
+
+	// SLICE_WORKERS is the number of windows solved concurrently. With the default of 1 they are solved on the calling goroutine.
+	// Otherwise each worker locks its own thread to the CUDA context, but the solvers share the global state of mumax and the GPU buffer pool,
+	// which are not safe for concurrent use, so it should only be increased for a backend whose operators and buffers are.
+	SLICE_WORKERS = 1
+)
+
+// A SpectrumSlicing solver returns every mode with frequency in the band [FMin, FMax) (rad/s), by splitting the band into
+// Windows and finding the modes in each with a shift-invert ArnoldiField solve centred on the window.
+// The number of modes in each window is estimated first by stochastic trace estimation of the spectral projector,
+// so that enough modes are sought. The results of neighbouring windows are merged, and modes found by both are kept once.
+// Only the positive frequency member of each pair of modes is returned.
+type SpectrumSlicing struct {
+	eigenSolver
+	FMin, FMax float64
+	Windows    int
+}
+
+// A SliceWindow holds the modes found in one window of a SpectrumSlicing.
+type SliceWindow struct {
+	FMin, FMax float64 // the band of the window, before it is extended by SLICE_OVERLAP.
+	Estimate   int     // the estimated number of modes in the band.
+	freqs      []complex128
+	modes      []CSlice
+}
+
+func (solver SpectrumSlicing) Modes() ([]float64, []CSlice) {
+	return realFrequencies(solver.ComplexModes())
+}
+
+// ComplexModes returns the complex eigenfrequencies, in increasing order of their real part, and corresponding eigenmodes of the system in the band.
+func (solver SpectrumSlicing) ComplexModes() ([]complex128, []CSlice) {
+	return solver.merge(solver.Slice())
+}
+
+// Slice solves each window, SLICE_WORKERS at a time, and returns them without merging.
+// It is the Must-style form of SliceContext, and panics with the errors of the windows.
+func (solver SpectrumSlicing) Slice() []SliceWindow {
+
+	if solver.Windows < 1 || solver.FMax <= solver.FMin || solver.FMin < 0 {
+		panic(&OptionError{Option: "windows", Reason: "spectrum slicing needs at least one window and a band of positive frequencies"})
+	}
+
+	windows := make([]SliceWindow, solver.Windows)
+	width := (solver.FMax - solver.FMin) / float64(solver.Windows)
+	for w := range windows {
+		windows[w].FMin = solver.FMin + float64(w)*width
+		windows[w].FMax = windows[w].FMin + width
+	}
+
+	if SLICE_WORKERS <= 1 {
+		for w := range windows {
+			solveWindow(&windows[w], int64(w))
+		}
+		return windows
+	}
+
+	// the first panic of a worker, such as the cancellation of the solve, is raised again on the calling goroutine once they have all stopped.
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		recovered interface{}
+	)
+	next := make(chan int)
+	for worker := 0; worker < SLICE_WORKERS; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cuda.LockThread()
+			defer runtime.UnlockOSThread()
+			defer func() {
+				if r := recover(); r != nil {
+					mu.Lock()
+					if recovered == nil {
+						recovered = r
+					}
+					mu.Unlock()
+					for range next {
+					}
+				}
+			}()
+			for w := range next {
+				solveWindow(&windows[w], int64(w))
+			}
+		}()
+	}
+	for w := range windows {
+		next <- w
+	}
+	close(next)
+	wg.Wait()
+
+	if recovered != nil {
+		panic(recovered)
+	}
+	return windows
+}
+
+// solveWindow estimates the number of modes in the window, and finds them with a shift-invert solve centred on it.
+func solveWindow(w *SliceWindow, seed int64) {
+
+	w.Estimate = EstimateModeCount(w.FMin, w.FMax, seed)
+
+	margin := SLICE_OVERLAP * (w.FMax - w.FMin)
+	lo, hi := w.FMin-margin, w.FMax+margin
+
+	totalSize := 2 * en.Mesh().NCell()
+	nev := 2*w.Estimate + 4
+
+	for attempt := 0; ; attempt++ {
+
+		if nev > totalSize-2 {
+			nev = totalSize - 2
+		}
+
+		solver := ArnoldiField{ShiftInvert: true, Sigma: (w.FMin + w.FMax) / 2, NEV: nev}
+		freqs, modes := solver.ComplexModes()
+
+		w.freqs, w.modes = nil, nil
+		inBand := 0
+		for p, f := range freqs {
+			if real(f) >= lo && real(f) < hi {
+				w.freqs = append(w.freqs, f)
+				w.modes = append(w.modes, modes[p])
+				if real(f) >= w.FMin && real(f) < w.FMax {
+					inBand++
+				}
+			}
+		}
+
+		if inBand >= w.Estimate || attempt == SLICE_RETRIES || nev == totalSize-2 {
+			if inBand < w.Estimate {
+				util.Log(fmt.Sprintf("Found %d modes in [%g, %g), but estimated %d.", inBand, w.FMin, w.FMax, w.Estimate))
+			}
+			return
+		}
+		nev *= 2
+	}
+}
+
+// merge joins the modes of the windows in the band of the solver. Modes found in more than one window,
+// with close frequencies and profiles, are kept once: the one with the smallest residual.
+func (solver SpectrumSlicing) merge(windows []SliceWindow) ([]complex128, []CSlice) {
+
+	type candidate struct {
+		freq     complex128
+		mode     CSlice
+		residual float64
+	}
+
+	le := field.NewLinearEvolution()
+	defer le.Free()
+
+	var candidates []candidate
+	for _, w := range windows {
+		for p, f := range w.freqs {
+			candidates = append(candidates, candidate{f, w.modes[p], modeResidual(le, f, w.modes[p])})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return real(candidates[i].freq) < real(candidates[j].freq) })
+
+	var kept []candidate
+	for _, c := range candidates {
+
+		// a duplicate has a frequency within 0.1% and a normalised overlap above 0.9.
+		duplicate := -1
+		for k := len(kept) - 1; k >= 0; k-- {
+			if real(c.freq)-real(kept[k].freq) > 1e-3*math.Abs(real(c.freq)) {
+				break
+			}
+			if normalisedOverlap(c.mode, kept[k].mode) > 0.9 {
+				duplicate = k
+				break
+			}
+		}
+
+		if duplicate < 0 {
+			kept = append(kept, c)
+		} else if c.residual < kept[duplicate].residual {
+			kept[duplicate] = c
+		}
+	}
+
+	var freqs []complex128
+	var modes []CSlice
+	for _, c := range kept {
+		if real(c.freq) >= solver.FMin && real(c.freq) < solver.FMax {
+			freqs = append(freqs, c.freq)
+			modes = append(modes, c.mode)
+		}
+	}
+	return freqs, modes
+}
+
+// modeResidual returns |L ψ - λ ψ| / (|λ| |ψ|), for a mode ψ with three components and the complex frequency f,
+// which corresponds to the eigenvalue λ of the linear evolution L.
+func modeResidual(le *field.LinearEvolution, f complex128, mode CSlice) float64 {
+
+	λ := complex(-imag(f), real(f))
+
+	ψ := mode.DevCopy()
+	defer ψ.Free()
+	Lψ := NewCSlice(3, ψ.Size())
+	defer Lψ.Free()
+
+	le.Operate(Lψ.Real(), ψ.Real())
+	le.Operate(Lψ.Imag(), ψ.Imag())
+	CMadd2(Lψ, Lψ, ψ, 1, complex64(-λ))
+
+	return norm(Lψ) / (cmplx.Abs(λ) * norm(ψ))
+}
+
+// EstimateModeCount returns an estimate of the number of modes with frequency in [fMin, fMax) (rad/s).
+// This is the trace of the spectral projector P = (1/2πi) ∮ (z - L)⁻¹ dz onto the eigenvalues of the linear evolution L
+// inside a circle through ifMin and ifMax, estimated from the average of vᵀ P v over SLICE_PROBES random vectors v with
+// entries ±1, with the contour integral evaluated by the trapezium rule at SLICE_NODES points. Each point needs a GMRES solve for each vector.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// It is the Must-style form of EstimateModeCountContext, and panics with the errors of the solves.
+func EstimateModeCount(fMin, fMax float64, seed int64) int {
+
+	rng := rand.New(rand.NewSource(seed))
+
+	op := newRotatedOperator()
+	defer op.free()
+
+	size := en.MeshSize()
+	n := en.Mesh().NCell()
+	ms := mag.HostSlice(en.Msat).Host()[0]
+
+	centre := complex(0, (fMin+fMax)/2)
+	radius := (fMax - fMin) / 2
+
+	v := NewCSlice(2, size)
+	defer v.Free()
+	x := NewCSlice(2, size)
+	defer x.Free()
+
+	nodes := make([]complex128, SLICE_NODES)
+	precond := make([][][2][2]complex128, SLICE_NODES)
+	for k := range nodes {
+		θ := 2 * math.Pi * (float64(k) + 0.5) / float64(SLICE_NODES)
+		nodes[k] = centre + complex(radius, 0)*cmplx.Exp(complex(0, θ))
+		precond[k] = localPreconditioner(nodes[k])
+	}
+
+	var trace complex128
+	for probe := 0; probe < SLICE_PROBES; probe++ {
+
+		vCPU := data.NewSlice(2, size)
+		vArr := vCPU.Host()
+		for c := 0; c < 2; c++ {
+			for idx := 0; idx < n; idx++ {
+				if ms[idx] == 0 {
+					continue
+				}
+				vArr[c][idx] = float32(2*rng.Intn(2) - 1)
+			}
+		}
+		data.Copy(v.Real(), vCPU)
+		cuda.Zero(v.Imag())
+
+		for k, z := range nodes {
+
+			interrupt()
+
+			// x = (z - L)⁻¹ v, by solving (L - z) x = -v.
+			a := func(dst, src CSlice) {
+				op.applyComplex(dst, src)
+				CMadd2(dst, dst, src, 1, complex64(-z))
+			}
+			pre := func(dst, src CSlice) { applyLocal(precond[k], dst, src) }
+
+			SScal(v, v, -1)
+			Zero(x)
+			gmres(a, pre, x, v, GMRES_RESTART, GMRES_MAXITER, GMRES_TOL)
+			SScal(v, v, -1)
+
+			// the trapezium rule weight of (1/2πi) dz.
+			weight := (z - centre) / complex(float64(SLICE_NODES), 0)
+			trace += weight * complex128(Dotc(v, x))
+		}
+	}
+
+	estimate := real(trace) / float64(SLICE_PROBES)
+	return int(math.Max(0, math.Round(estimate)))
+}
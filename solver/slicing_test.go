@@ -0,0 +1,51 @@
+package solver
+
+import (
+	"sort"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestSpectrumSlicing checks that the modes found by slicing a band into windows are those found by RotatedToZ, each found once.
+func TestSpectrumSlicing(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+		Setup(s)
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, _ := Modes()
+
+		var positive []float64
+		for _, f := range valsA {
+			if f > 0 {
+				positive = append(positive, f)
+			}
+		}
+		sort.Float64s(positive)
+
+		// the band ends between two modes, so it holds exactly nBand of them.
+		nBand := len(positive) / 2
+		fMax := (positive[nBand-1] + positive[nBand]) / 2
+
+		valsB, _ := SpectrumSlicing{FMin: 0, FMax: fMax, Windows: 3}.Modes()
+		sort.Float64s(valsB)
+
+		if len(valsB) != nBand {
+			t.Fatalf("%d: %d modes in the band; want %d", test_idx, len(valsB), nBand)
+		}
+		err := 0
+		for i := range valsB {
+			err += tests.EqualScalars(positive[i], valsB[i], 1e-3)
+		}
+		if err > 0 {
+			t.Errorf("%d: sliced frequencies are not equal: %d%% error", test_idx, 100*err/nBand)
+		}
+	}
+}
@@ -2,12 +2,15 @@ package solver
 
 import (
 	"fmt"
+	"math"
+	"sort"
 	"testing"
 	"time"
 
 	en "github.com/mumax/3/engine"
 
 	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
 	"github.com/will-henderson/mumax-vhf/tests"
 )
 
@@ -82,3 +85,102 @@ func TestRotatedToZ(t *testing.T) {
 
 	}
 }
+
+// TestSystemModes checks that RotatedToZ finds the same modes for the System set up in mumax, without the engine, as it does through the engine.
+func TestSystemModes(t *testing.T) {
+	testcases := tests.Load()
+	defer en.InitAndClose()()
+
+	for test_idx, s := range testcases {
+
+		Setup(s)
+
+		en.Relax()
+
+		Solver = new(RotatedToZ)
+		valsA, vecsA := Modes()
+
+		sys, sysErr := mag.EngineSystem()
+		if sysErr != nil {
+			t.Fatalf("%d: %v", test_idx, sysErr)
+		}
+		valsB, vecsB := RotatedToZ{}.SystemModes(sys)
+
+		err := tests.EqualDecompositions(vecsA, vecsB, valsA, valsB, 1e-4, 1e-3)
+		if err > 0 {
+			t.Errorf("%d: Decompositions are not equal: %d%% error", test_idx, 100*err/len(valsA))
+		}
+
+	}
+}
+
+// TestSystemKrylovSchur checks the spin waves of a periodic chain, built as a System in Go without a mumax script,
+// found by Krylov–Schur with the System applied on the GPU. Without demagnetising interactions, the wave vector k = 2πn / (N Δ)
+// precesses at ω_n = γ (B + (2 Aex / Msat) (2 / Δ²) (1 - cos(kΔ))), which needs the exchange across the periodic boundary.
+// Krylov–Schur finds fewer than all 2N modes, so the highest, n = N/2, is not sought.
+func TestSystemKrylovSchur(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	N := 8
+	Δ := 2e-9
+	Msat := 8e5
+	Aex := 1.3e-11
+	B := 0.5
+
+	size := [3]int{N, 1, 1}
+	sys := mag.NewSystem(size, [3]float64{Δ, Δ, Δ})
+	sys.PBC = [3]int{1, 0, 0}
+	sys.EnableDemag = false
+	sys.Msat = mag.Uniform(size, float32(Msat))
+	sys.SetAex(mag.Uniform(size, float32(Aex)))
+	sys.BExt = mag.Uniform(size, 0, 0, float32(B))
+
+	want := make([]float64, N)
+	for n := range want {
+		want[n] = sys.GammaLL * (B + (2*Aex/Msat)*(2/(Δ*Δ))*(1-math.Cos(2*math.Pi*float64(n)/float64(N))))
+	}
+	sort.Float64s(want)
+
+	want = want[:N-1]
+
+	freqs, _ := KrylovSchur{NEV: 2*N - 2}.SystemModes(sys)
+
+	var positive []float64
+	for _, f := range freqs {
+		if f > 0 {
+			positive = append(positive, f)
+		}
+	}
+	sort.Float64s(positive)
+	if len(positive) != len(want) {
+		t.Fatalf("%d positive frequencies; want %d", len(positive), len(want))
+	}
+
+	err := 0
+	for n := range want {
+		err += tests.EqualScalars(want[n], positive[n], 1e-3)
+	}
+	if err > 0 {
+		t.Errorf("Spin wave frequencies are not equal: %d%% error", 100*err/len(want))
+	}
+}
+
+// TestProcessStraight checks that processStraight takes the eigenvector of each non-null eigenvalue, rather than that at the position
+// of the mode, and that it finds all the non-null eigenvalues when a null one comes first.
+func TestProcessStraight(t *testing.T) {
+
+	size := [3]int{1, 1, 1}
+	values := []complex128{0, 2i, -3i}
+	vectors := [][]complex128{{1, 0, 0}, {0, 1, 0}, {0, 0, 1}}
+
+	freqs, modes := processStraight(values, vectors, size)
+	if len(freqs) != 2 {
+		t.Fatalf("found %d modes; want 2", len(freqs))
+	}
+	for q, c := range []int{1, 2} {
+		if mode := modes[q].Real().Vectors(); mode[c][0][0][0] != 1 {
+			t.Errorf("mode %d with frequency %v is not eigenvector %d: %v", q, freqs[q], c, mode)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package solver
+
+import (
+	"math"
+
+	"github.com/mumax/3/cuda"
+	en "github.com/mumax/3/engine"
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+var (
+	// TorqueTolerance is the largest residual torque |m x B0| (T) for which the ground state is considered to be relaxed.
+	TorqueTolerance = 1e-4
+
+	// SoftThreshold is the largest eigenvalue of the linear Hamiltonian, relative to the largest in magnitude,
+	// for which a direction is considered soft.
+	SoftThreshold = 1e-3
+
+	// StabilityDirections is the number of lowest eigenvalues of the linear Hamiltonian returned by Stability.
+	StabilityDirections = 10
+)
+
+// A StabilityReport describes whether the ground state stored in en.M is a stable minimum of the energy.
+type StabilityReport struct {
+	MaxTorque   float64   // The largest residual torque |m x B0| (T), with B0 the ground state field of the registered interactions.
+	Eigenvalues []float64 // The lowest eigenvalues (J/m3) of the linear Hamiltonian transverse to the ground state, in increasing order.
+	Directions  []CSlice  // The corresponding deviations of the magnetisation, which are real and live on the CPU.
+	Unstable    []int     // The indices of the negative Eigenvalues, along which the energy decreases.
+	Soft        []int     // The indices of the non-negative Eigenvalues below SoftThreshold.
+}
+
+// Relaxed returns whether the residual torque is below TorqueTolerance.
+func (r StabilityReport) Relaxed() bool {
+	return r.MaxTorque <= TorqueTolerance
+}
+
+// Stable returns whether the ground state is relaxed and is a minimum of the energy.
+func (r StabilityReport) Stable() bool {
+	return r.Relaxed() && len(r.Unstable) == 0
+}
+
+// Stability checks that the ground state stored in en.M is a stable minimum. It calculates the residual torque,
+// and the lowest eigenvalues of the linear Hamiltonian rotated such that the ground state is along z at each point.
+// The ground state is a minimum when these are all positive; a negative eigenvalue gives a direction in which the
+// magnetisation is unstable, and a small one a soft direction which becomes unstable as the field is changed.
+// The degrees of freedom of the cells in mag.PinnedSurfaces, and of the cells outside the magnet, are not included.
+// It returns a *BackendError if the eigendecomposition fails.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+func Stability() (report StabilityReport, err error) {
+
+	defer func() { Catch(recover(), &err) }()
+
+	report.MaxTorque = residualTorque()
+
+	t := mag.LinearHamiltonianTensor()
+	rot := new(mag.RotationToZ)
+	rot.InitRotation()
+	twoD := rot.RotateTensor(t).XY()
+
+	totalSize := 2 * twoD.Length()
+	keep := magnetIndices(unpinnedIndices(2))
+	reduced := reduceMatrix(twoD.To1D(), totalSize, keep)
+
+	// the tensor is symmetric up to rounding, which is removed before the symmetric eigensolver is used.
+	n := len(keep)
+	for p := 0; p < n; p++ {
+		for q := p + 1; q < n; q++ {
+			avg := (reduced[p*n+q] + reduced[q*n+p]) / 2
+			reduced[p*n+q] = avg
+			reduced[q*n+p] = avg
+		}
+	}
+
+	var eig mat.EigenSym
+	if !eig.Factorize(mat.NewSymDense(n, reduced), true) {
+		return StabilityReport{}, &BackendError{Routine: "EigenSym", Reason: "eigendecomposition of the linear Hamiltonian failed"}
+	}
+	values := eig.Values(nil)
+	var vectors mat.Dense
+	eig.VectorsTo(&vectors)
+
+	scale := math.Max(math.Abs(values[0]), math.Abs(values[n-1]))
+
+	for p := 0; p < n && p < StabilityDirections; p++ {
+
+		vector := make([]complex128, n)
+		for q := range vector {
+			vector[q] = complex(vectors.At(q, p), 0)
+		}
+		mode := transverseMode(expandVector(vector, totalSize, keep), t.Size)
+
+		report.Eigenvalues = append(report.Eigenvalues, values[p])
+		report.Directions = append(report.Directions, rot.DerotateMode(mode))
+
+		if values[p] < 0 {
+			report.Unstable = append(report.Unstable, p)
+		} else if values[p] < SoftThreshold*scale {
+			report.Soft = append(report.Soft, p)
+		}
+	}
+
+	return report, nil
+}
+
+// residualTorque returns the largest |m x B0| over the magnet, for the ground state stored in en.M and its field B0 from the registered interactions.
+func residualTorque() float64 {
+
+	B0GPU := cuda.NewSlice(3, en.MeshSize())
+	defer B0GPU.Free()
+	cuda.Zero(B0GPU)
+	mag.AddGroundStateField(B0GPU)
+	B0 := B0GPU.HostCopy().Host()
+
+	m := en.M.Buffer().HostCopy().Host()
+
+	max := 0.
+	for idx := range m[0] {
+		torque := 0.
+		for c := 0; c < 3; c++ {
+			a := float64(m[(c+1)%3][idx]*B0[(c+2)%3][idx] - m[(c+2)%3][idx]*B0[(c+1)%3][idx])
+			torque += a * a
+		}
+		max = math.Max(max, math.Sqrt(torque))
+	}
+	return max
+}
+
+// magnetIndices returns the indices in keep, of the degrees of freedom ordered by component and then by cell, which belong to cells with non-zero saturation magnetisation.
+func magnetIndices(keep []int) []int {
+
+	ms := mag.HostSlice(en.Msat).Host()[0]
+
+	var inMagnet []int
+	for _, i := range keep {
+		if ms[i%len(ms)] != 0 {
+			inMagnet = append(inMagnet, i)
+		}
+	}
+	return inMagnet
+}
@@ -0,0 +1,48 @@
+package solver
+
+import (
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestStability checks a magnet without demagnetising interactions in an external field B. When the magnetisation is along the field
+// it is stable, and when it is against the field it is unstable to uniform rotation. Both have no torque, and the lowest eigenvalue is ±Msat B.
+func TestStability(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Msat := 8e5
+	B := 1.
+
+	for test_idx, mz := range []float64{1, -1} {
+
+		Setup(`
+			SetGridSize(2, 2, 1)
+			SetCellSize(2e-9, 2e-9, 2e-9)
+			Msat = 8e5
+			Aex = 1.3e-11
+			EnableDemag = false
+			B_ext = vector(0, 0, 1)
+		`)
+		en.M.Set(en.Uniform(0, 0, mz))
+
+		report, err := Stability()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !report.Relaxed() {
+			t.Errorf("%d: residual torque %g T", test_idx, report.MaxTorque)
+		}
+		if report.Stable() != (mz > 0) {
+			t.Errorf("%d: stable is %v", test_idx, report.Stable())
+		}
+		if tests.EqualScalars(mz*Msat*B, report.Eigenvalues[0], 1e-4) > 0 {
+			t.Errorf("%d: lowest eigenvalue %g; want %g", test_idx, report.Eigenvalues[0], mz*Msat*B)
+		}
+	}
+}
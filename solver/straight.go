@@ -20,31 +20,55 @@ func (solver Straight) Modes() ([]float64, []CSlice) {
 	return solver.Solve(t)
 }
 
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the system, which include the decay rates when Damping is set.
+func (solver Straight) ComplexModes() ([]complex128, []CSlice) {
+	t := mag.EigenProblemTensor()
+	return solver.SolveComplex(t)
+}
+
+// SystemModes returns the eigenfrequencies and corresponding eigenmodes of the System s, without the mumax engine.
+func (solver Straight) SystemModes(s *mag.System) ([]float64, []CSlice) {
+	return solver.Solve(s.EigenProblemTensor())
+}
+
+// SystemComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the System s, which include the decay rates when s.Damping is set.
+func (solver Straight) SystemComplexModes(s *mag.System) ([]complex128, []CSlice) {
+	return solver.SolveComplex(s.EigenProblemTensor())
+}
+
 // Solve returns the non-null eigenpairs of a particular input Tensor after taking cross product with the system magnetisation.
 func (solver Straight) Solve(t Tensor) ([]float64, []CSlice) {
+	return realFrequencies(solver.SolveComplex(t))
+}
+
+// SolveComplex returns the non-null eigenpairs of a particular input Tensor, with complex frequencies.
+func (solver Straight) SolveComplex(t Tensor) ([]complex128, []CSlice) {
 
 	arr := t.To1D()
 
-	values, vectors := Eig(3*t.Length(), arr)
+	values, vectors, err := Eig(3*t.Length(), arr)
+	if err != nil {
+		panic(err)
+	}
 
 	return processStraight(values, vectors, t.Size)
 
 }
 
-func processStraight(values []complex128, vectors [][]complex128, size [3]int) ([]float64, []CSlice) {
+func processStraight(values []complex128, vectors [][]complex128, size [3]int) ([]complex128, []CSlice) {
 
 	Nx := size[0]
 	Ny := size[1]
 	Nz := size[2]
 
 	totalSize := 2 * Nx * Ny * Nz
-	freqs := make([]float64, totalSize)
+	freqs := make([]complex128, totalSize)
 	modes := make([]CSlice, totalSize)
 
 	q := 0
-	for p := 0; p < totalSize; p++ {
+	for p := 0; p < len(values) && q < totalSize; p++ {
 
-		freq := imag(values[p])
+		freq := complexFrequency(values[p])
 
 		if freq != 0 {
 
@@ -57,8 +81,8 @@ func processStraight(values []complex128, vectors [][]complex128, size [3]int) (
 				for k := 0; k < size[2]; k++ {
 					for j := 0; j < size[1]; j++ {
 						for i := 0; i < size[0]; i++ {
-							modeReal[c][k][j][i] = float32(real(vectors[q][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
-							modeImag[c][k][j][i] = float32(imag(vectors[q][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
+							modeReal[c][k][j][i] = float32(real(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
+							modeImag[c][k][j][i] = float32(imag(vectors[p][c*Nx*Ny*Nz+k*Nx*Ny+j*Nx+i]))
 						}
 					}
 				}
@@ -69,5 +93,5 @@ func processStraight(values []complex128, vectors [][]complex128, size [3]int) (
 		}
 	}
 
-	return freqs, modes
+	return freqs[0:q], modes[0:q]
 }
@@ -0,0 +1,104 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+	mumag "github.com/mumax/3/mag"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestSpinTransferShift checks the Doppler shift of the spin waves of a periodic chain of uncoupled cells by a current along it.
+// Without exchange, each wave vector k = 2πn / (N Δ) precesses at γB, shifted by the adiabatic torque u ∂/∂x,
+// which the central difference takes to ω_n = γB - u sin(kΔ) / Δ, with the drift velocity u = μB P J / (2 e Msat).
+// This is checked with both the tensor and the operator on the GPU. Krylov–Schur needs NEV + 2 <= Restart <= 2N, so it is asked
+// for all but one pair, and each of the frequencies it finds must be one of the shifted frequencies.
+func TestSpinTransferShift(t *testing.T) {
+
+	defer en.InitAndClose()()
+	defer func() { SpinTransfer = false }()
+
+	N := 8
+	Δ := 2e-9
+	Msat := 8e5
+	B := 0.2
+	J := 1e12
+
+	Setup(`
+		SetGridSize(8, 1, 1)
+		SetCellSize(2e-9, 2e-9, 2e-9)
+		SetPBC(1, 0, 0)
+		Msat = 8e5
+		Aex = 0
+		EnableDemag = false
+		B_ext = vector(0, 0, 0.2)
+		Pol = 1
+		Xi = 0
+		J = vector(1e12, 0, 0)
+		m = uniform(0, 0, 1)
+	`)
+	SpinTransfer = true
+
+	u := mumag.MuB * J / (2 * mumag.Qe * Msat)
+	want := make([]float64, N)
+	for n := range want {
+		want[n] = en.GammaLL*B - u*math.Sin(2*math.Pi*float64(n)/float64(N))/Δ
+	}
+	sort.Float64s(want)
+
+	for _, s := range []struct {
+		name   string
+		solver ComplexEigenSolver
+		n      int // the number of positive frequencies found.
+	}{
+		{"Straight", new(Straight), N},
+		{"KrylovSchur", &KrylovSchur{NEV: 2*N - 2}, N - 1},
+	} {
+
+		freqs, _ := s.solver.ComplexModes()
+
+		var positive []float64
+		for _, f := range freqs {
+			if real(f) > 0 {
+				positive = append(positive, real(f))
+			}
+		}
+		sort.Float64s(positive)
+
+		if len(positive) != s.n {
+			t.Errorf("%s: %d positive frequencies; want %d", s.name, len(positive), s.n)
+			continue
+		}
+
+		// each frequency found must be one of the shifted frequencies, and no two the same one.
+		used := make([]bool, N)
+		err := 0
+		for _, f := range positive {
+			matched := false
+			for n := range want {
+				if !used[n] && tests.EqualScalars(want[n], f, 1e-3) == 0 {
+					used[n] = true
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				err++
+			}
+		}
+		if err > 0 {
+			t.Errorf("%s: Shifted frequencies are not equal: %d%% error", s.name, 100*err/s.n)
+		}
+	}
+
+	// Krylov–Schur keeps a full complex pair beyond the modes it returns in a basis of at most 2N vectors, so it cannot be asked for all of them.
+	if _, _, err := (KrylovSchur{NEV: 2 * N}).ModesContext(context.Background()); !errors.As(err, new(*OptionError)) {
+		t.Errorf("KrylovSchur asked for all %d modes returned %v", 2*N, err)
+	}
+}
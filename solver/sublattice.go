@@ -0,0 +1,145 @@
+package solver
+
+import (
+	"github.com/mumax/3/cuda"
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+)
+
+// A TwoSublattice solver returns the modes of a two-sublattice antiferromagnet or ferrimagnet, whose first sublattice is en.M
+// and whose second is described by Sublattice.
+// Like RotatedToZ, it rotates each sublattice such that its ground state is along z and eliminates the zero eigenmodes,
+// so it diagonalises a matrix of size 4*Nx*Ny*Nz.
+// The modes have 6 components, the deviations of the first sublattice followed by those of the second (see mag.SublatticeDeviation).
+// The time complexity is O((4*Nx*Ny*Nz)^3), unless KrylovSchur is set.
+type TwoSublattice struct {
+	eigenSolver
+	Sublattice mag.Sublattice
+
+	// KrylovSchur, if set, finds the modes with the Krylov–Schur iteration and the options of this solver,
+	// applying the mag.SublatticeOperator on the GPU rather than diagonalising the tensor.
+	KrylovSchur *KrylovSchur
+}
+
+// Modes returns the eigenfrequencies and corresponding eigenmodes of the two-sublattice system.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables.
+// and assumes that the ground state magnetisation of the first sublattice is currently stored in en.M.
+// It returns 4 * Nx * Ny * Nz eigenpairs (zero eigenfrequencies are ignored)
+func (solver TwoSublattice) Modes() ([]float64, []CSlice) {
+	return realFrequencies(solver.ComplexModes())
+}
+
+// unsupported reports the terms set up in mumax which the sublattice Hamiltonian does not include (see mag.SystemUnmodelled),
+// as it has only the demagnetising, exchange, uniaxial anisotropy and Zeeman terms and the coupling between the sublattices.
+func (solver TwoSublattice) unsupported() mag.UnsupportedFeatures {
+	features := mag.SystemUnmodelled()
+	for i := range features {
+		features[i].Description += " is not supported by TwoSublattice"
+	}
+	return features
+}
+
+// ComplexModes returns the complex eigenfrequencies and corresponding eigenmodes of the two-sublattice system,
+// which include the decay rates when Damping is set.
+// It panics with an *OptionError for the first term set up in mumax which it does not support (see mag.SystemUnmodelled),
+// rather than drop it from the Hamiltonian.
+func (solver TwoSublattice) ComplexModes() ([]complex128, []CSlice) {
+	if features := solver.unsupported(); len(features) > 0 {
+		panic(&OptionError{Option: features[0].Name, Reason: features[0].Description})
+	}
+	if solver.KrylovSchur != nil {
+		return solver.krylovSchurModes()
+	}
+	t := solver.Sublattice.EigenProblemTensor()
+	return solver.SolveComplex(t)
+}
+
+// SolveComplex returns the non-null eigenpairs of a particular 6 component input Tensor, with complex frequencies.
+func (solver TwoSublattice) SolveComplex(t Tensor) ([]complex128, []CSlice) {
+
+	rot := mag.NewSublatticeRotation(solver.Sublattice)
+	transverse := rot.TransverseTensor(t)
+	arr := transverse.To1D()
+
+	length := transverse.Length()
+	totalSize := 4 * length
+	values, vectors, err := Eig(totalSize, arr)
+	if err != nil {
+		panic(err)
+	}
+
+	freq := make([]complex128, totalSize)
+	modes := make([]CSlice, totalSize)
+
+	for p := 0; p < totalSize; p++ {
+		freq[p] = complexFrequency(values[p])
+		modes[p] = rot.DerotateMode(sublatticeMode(vectors[p], t.Size))
+	}
+
+	return freq, modes
+}
+
+// krylovSchurModes finds the modes nearest zero frequency with the Krylov–Schur iteration in the transverse frames of the sublattices.
+func (solver TwoSublattice) krylovSchurModes() ([]complex128, []CSlice) {
+
+	ks := solver.KrylovSchur
+	if ks.ShiftInvert {
+		panic(&OptionError{Option: "KrylovSchur.ShiftInvert", Reason: "it is not supported for two sublattices"})
+	}
+	size := en.MeshSize()
+	NCell := en.Mesh().NCell()
+	totalSize := 4 * NCell
+
+	rot := mag.NewSublatticeRotation(solver.Sublattice)
+	op := mag.NewSublatticeOperator(solver.Sublattice, rot)
+	defer op.Free()
+
+	x4 := cuda.NewSlice(4, size)
+	y4 := cuda.NewSlice(4, size)
+	defer x4.Free()
+	defer y4.Free()
+
+	parts := func(v []float32) [][]float32 {
+		return [][]float32{v[0:NCell], v[NCell : 2*NCell], v[2*NCell : 3*NCell], v[3*NCell : 4*NCell]}
+	}
+	yS := make([]float32, totalSize)
+	apply := func(y, x []float64) {
+		interrupt()
+		data.Copy(x4, data.SliceFromArray(parts(toSingle(x)), size))
+		op.Operate(y4, x4)
+		data.Copy(data.SliceFromArray(parts(yS), size), y4)
+		for i := range y {
+			y[i] = float64(yS[i])
+		}
+	}
+
+	values, vectors := ks.iterate(totalSize, apply, magnetisedStart(NCell, 4), magnetisedMask(NCell, 4), smallerMagnitude, "TwoSublattice")
+
+	freq := make([]complex128, len(values))
+	modes := make([]CSlice, len(values))
+	for p := range values {
+		freq[p] = complexFrequency(values[p])
+		modes[p] = rot.DerotateMode(sublatticeMode(vectors[p], size))
+	}
+	return freq, modes
+}
+
+// sublatticeMode returns the 4 component transverse mode on the CPU held by vector, ordered as the rows of SublatticeRotation.TransverseTensor.
+func sublatticeMode(vector []complex128, size [3]int) CSlice {
+
+	length := size[0] * size[1] * size[2]
+	mode := NewCSliceCPU(4, size)
+	modeReal := mode.Real().Host()
+	modeImag := mode.Imag().Host()
+
+	for c := 0; c < 4; c++ {
+		for idx := 0; idx < length; idx++ {
+			modeReal[c][idx] = float32(real(vector[c*length+idx]))
+			modeImag[c][idx] = float32(imag(vector[c*length+idx]))
+		}
+	}
+	return mode
+}
@@ -0,0 +1,155 @@
+package solver
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/mag"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestAntiferromagneticResonance checks the modes of a uniaxial antiferromagnet without demagnetising or intra-sublattice exchange interactions
+// against the Kittel result ω = γ sqrt(B_A (2 B_E + B_A)), with B_A = 2 Ku1 / Msat and B_E = |J| / Msat,
+// and that a term which the sublattice Hamiltonian does not include is refused.
+func TestAntiferromagneticResonance(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Msat := 1e5
+	Ku1 := 1e4
+	J := -1e5
+
+	Setup(`
+		SetGridSize(2, 2, 1)
+		SetCellSize(5e-9, 5e-9, 5e-9)
+		Msat = 1e5
+		Aex = 0
+		Ku1 = 1e4
+		AnisU = vector(0, 0, 1)
+		EnableDemag = false
+		m = uniform(0, 0, 1)
+	`)
+
+	Solver = TwoSublattice{Sublattice: mag.Sublattice{Msat: Msat, J: J}}
+	freqs, _ := Modes()
+
+	BA := 2 * Ku1 / Msat
+	BE := math.Abs(J) / Msat
+	want := en.GammaLL * math.Sqrt(BA*(2*BE+BA))
+
+	err := 0
+	for _, f := range freqs {
+		err += tests.EqualScalars(want, math.Abs(f), 1e-3)
+	}
+	if err > 0 {
+		t.Errorf("Frequencies are not equal to the Kittel frequency: %d%% error", 100*err/len(freqs))
+	}
+
+	// the spin-transfer torque is not in the sublattice Hamiltonian, so it is reported and refused rather than dropped.
+	SpinTransfer = true
+	defer func() { SpinTransfer = false }()
+	reported := false
+	for _, f := range ValidateSolver() {
+		reported = reported || f.Name == "SpinTransfer"
+	}
+	if !reported {
+		t.Errorf("ValidateSolver does not report the spin-transfer torque")
+	}
+	var optionErr *OptionError
+	if _, _, err := ComplexModesContext(context.Background()); !errors.As(err, &optionErr) || optionErr.Option != "SpinTransfer" {
+		t.Errorf("ComplexModesContext with the spin-transfer torque returned %v", err)
+	}
+}
+
+// TestSublatticeKrylovSchur checks that the Krylov–Schur iteration with the matrix-free operator finds the antiferromagnetic resonance
+// of TestAntiferromagneticResonance.
+func TestSublatticeKrylovSchur(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Msat := 1e5
+	Ku1 := 1e4
+	J := -1e5
+
+	Setup(`
+		SetGridSize(2, 2, 1)
+		SetCellSize(5e-9, 5e-9, 5e-9)
+		Msat = 1e5
+		Aex = 0
+		Ku1 = 1e4
+		AnisU = vector(0, 0, 1)
+		EnableDemag = false
+		m = uniform(0, 0, 1)
+	`)
+
+	Solver = TwoSublattice{Sublattice: mag.Sublattice{Msat: Msat, J: J}, KrylovSchur: &KrylovSchur{NEV: 8}}
+	freqs, _ := Modes()
+
+	BA := 2 * Ku1 / Msat
+	BE := math.Abs(J) / Msat
+	want := en.GammaLL * math.Sqrt(BA*(2*BE+BA))
+
+	if len(freqs) != 8 {
+		t.Fatalf("%d modes; want 8", len(freqs))
+	}
+	err := 0
+	for _, f := range freqs {
+		err += tests.EqualScalars(want, math.Abs(f), 1e-3)
+	}
+	if err > 0 {
+		t.Errorf("Frequencies are not equal to the Kittel frequency: %d%% error", 100*err/len(freqs))
+	}
+}
+
+// TestSublatticeParameters checks that the second sublattice uses its own anisotropy. Without any coupling between them,
+// each sublattice precesses about its own anisotropy field, at ω = γ 2 Ku1 / Msat.
+func TestSublatticeParameters(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Setup(`
+		SetGridSize(2, 2, 1)
+		SetCellSize(5e-9, 5e-9, 5e-9)
+		Msat = 1e5
+		Aex = 0
+		Ku1 = 1e4
+		AnisU = vector(0, 0, 1)
+		EnableDemag = false
+		m = uniform(0, 0, 1)
+	`)
+
+	size := en.MeshSize()
+	Msat2 := 2e5
+	Ku2 := 3e4
+	sl := mag.Sublattice{Msat: Msat2, Ku1: mag.Uniform(size, float32(Ku2))}
+
+	Solver = TwoSublattice{Sublattice: sl}
+	freqs, _ := Modes()
+
+	if len(freqs) != 16 {
+		t.Fatalf("%d modes; want 16", len(freqs))
+	}
+	magnitudes := make([]float64, len(freqs))
+	for i, f := range freqs {
+		magnitudes[i] = math.Abs(f)
+	}
+	sort.Float64s(magnitudes)
+
+	err := 0
+	for i, f := range magnitudes {
+		want := en.GammaLL * 2 * 1e4 / 1e5
+		if i >= 8 {
+			want = en.GammaLL * 2 * Ku2 / Msat2
+		}
+		err += tests.EqualScalars(want, f, 1e-3)
+	}
+	if err > 0 {
+		t.Errorf("Frequencies are not equal to those of the anisotropy of each sublattice: %d%% error", 100*err/len(freqs))
+	}
+}
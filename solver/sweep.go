@@ -0,0 +1,247 @@
+package solver
+
+import (
+	"math"
+	"sort"
+
+	"github.com/mumax/3/data"
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+)
+
+var (
+	// TrackingThreshold is the smallest normalised overlap |<ψ, ψ'>| / (|ψ| |ψ'|) of a mode with its match at the previous step of a Sweep
+	// for the branch to keep its character. Below it, the branch is flagged as hybridised.
+	TrackingThreshold = 0.5
+)
+
+// A Sweep steps a mumax parameter along a path, relaxing the ground state from the previous step and solving for the modes at each step
+// with the current Solver. A Solver with a KrylovStart, such as ArnoldiField, is started from the modes of the previous step,
+// which needs Solver to hold a pointer to it, as SetGuess has a pointer receiver: a Solver set to a value is started as without a Sweep.
+// The modes are tracked by the overlap of their profiles, rather than by their order, to give continuous branches.
+type Sweep struct {
+	Set  func(value float64) // Set sets the swept parameter, for example FieldSetter or ParameterSetter.
+	Path []float64           // The values of the swept parameter at each step.
+
+	// OnStep, if not nil, is called after each step with the frequencies and modes of the branches, such that
+	// freqs[b] and modes[b] belong to branch b. A branch which has no mode at the step has frequency NaN and the zero CSlice, whose Real is nil.
+	// Branches which start at the step are at the end.
+	OnStep func(step int, freqs []float64, modes []CSlice)
+}
+
+// A Branch is a mode followed through a Sweep. Each slice has an element for each step of the path.
+type Branch struct {
+	Freqs      []float64 // The eigenfrequency, which is NaN where no mode is matched to the branch.
+	Overlaps   []float64 // The normalised overlap with the mode of the branch at the previous step, which is 1 at the first step.
+	Hybridised []bool    // Whether the overlap is below TrackingThreshold, so the mode has mixed with another, as at an anticrossing.
+	Crossing   []bool    // Whether the branch crossed another branch since the previous step, with both keeping their character.
+}
+
+// FieldSetter returns a function setting en.B_ext to value times direction.
+func FieldSetter(direction [3]float64) func(float64) {
+	return func(value float64) {
+		en.B_ext.Set(data.Vector{value * direction[0], value * direction[1], value * direction[2]})
+	}
+}
+
+// ParameterSetter returns a function setting the scalar mumax parameter p in all regions.
+func ParameterSetter(p *en.ScalarParam) func(float64) {
+	return func(value float64) {
+		p.Set(value)
+	}
+}
+
+// Run performs the sweep, and returns the branches of the modes with positive frequency, ordered by frequency at the first step.
+// When a step finds more modes than there are branches, those which match no branch start new branches after the others,
+// in order of frequency, with frequency NaN at the earlier steps. The Guess of the Solver is restored when it returns.
+// Note that it does not have any inputs. Rather it uses the geometry defined by the global variables,
+// and it starts relaxing from the magnetisation currently stored in en.M. It is the Must-style form of RunContext, and panics with the errors of the Solver.
+func (s Sweep) Run() []Branch {
+
+	var branches []Branch
+	var previous []CSlice
+
+	warm, isWarm := Solver.(warmStarter)
+	if isWarm {
+		defer warm.SetGuess(warm.guess())
+	}
+
+	for step, value := range s.Path {
+
+		interrupt()
+		s.Set(value)
+		en.Relax()
+
+		freqs, modes := positiveModes(Modes())
+
+		matched := matchModes(previous, modes)
+
+		// the modes which match no branch, all of them at the first step, start new branches.
+		used := make([]bool, len(modes))
+		for _, m := range matched {
+			if m.mode >= 0 {
+				used[m.mode] = true
+			}
+		}
+		for p := range modes {
+			if used[p] {
+				continue
+			}
+			var branch Branch
+			for earlier := 0; earlier < step; earlier++ {
+				branch.Freqs = append(branch.Freqs, math.NaN())
+				branch.Overlaps = append(branch.Overlaps, 0)
+				branch.Hybridised = append(branch.Hybridised, true)
+				branch.Crossing = append(branch.Crossing, false)
+			}
+			branches = append(branches, branch)
+			previous = append(previous, CSlice{})
+			matched = append(matched, match{mode: p, overlap: 1})
+		}
+
+		stepFreqs := make([]float64, len(branches))
+		stepModes := make([]CSlice, len(branches))
+		for b := range branches {
+
+			freq := math.NaN()
+			overlap := 0.
+			if p := matched[b].mode; p >= 0 {
+				freq = freqs[p]
+				overlap = matched[b].overlap
+				stepModes[b] = modes[p]
+				previous[b] = modes[p]
+			}
+			stepFreqs[b] = freq
+
+			branches[b].Freqs = append(branches[b].Freqs, freq)
+			branches[b].Overlaps = append(branches[b].Overlaps, overlap)
+			branches[b].Hybridised = append(branches[b].Hybridised, overlap < TrackingThreshold)
+			branches[b].Crossing = append(branches[b].Crossing, false)
+		}
+
+		if step > 0 {
+			flagCrossings(branches, step)
+		}
+
+		if isWarm {
+			warm.SetGuess(modes)
+		}
+
+		if s.OnStep != nil {
+			s.OnStep(step, stepFreqs, stepModes)
+		}
+	}
+
+	return branches
+}
+
+type match struct {
+	mode    int     // the index of the mode matched to a branch, or -1 if there is none.
+	overlap float64 // the normalised overlap of the mode with the previous mode of the branch.
+}
+
+// matchModes assigns the modes to the branches, whose modes at the previous step are previous.
+// The pairs of branch and mode are assigned greedily, in decreasing order of their overlap.
+func matchModes(previous, modes []CSlice) []match {
+
+	matched := make([]match, len(previous))
+	for b := range matched {
+		matched[b] = match{mode: -1}
+	}
+
+	type pair struct {
+		branch, mode int
+		overlap      float64
+	}
+	var pairs []pair
+	for b, prev := range previous {
+		if prev.Real() == nil {
+			continue
+		}
+		for p, mode := range modes {
+			pairs = append(pairs, pair{b, p, normalisedOverlap(prev, mode)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].overlap > pairs[j].overlap })
+
+	used := make([]bool, len(modes))
+	for _, pr := range pairs {
+		if matched[pr.branch].mode >= 0 || used[pr.mode] {
+			continue
+		}
+		matched[pr.branch] = match{mode: pr.mode, overlap: pr.overlap}
+		used[pr.mode] = true
+	}
+
+	return matched
+}
+
+// flagCrossings flags the pairs of branches whose order in frequency changed between the previous step and step,
+// where neither hybridised, as crossing.
+func flagCrossings(branches []Branch, step int) {
+	for a := range branches {
+		for b := a + 1; b < len(branches); b++ {
+
+			if branches[a].Hybridised[step] || branches[b].Hybridised[step] {
+				continue
+			}
+
+			before := branches[a].Freqs[step-1] - branches[b].Freqs[step-1]
+			after := branches[a].Freqs[step] - branches[b].Freqs[step]
+			if before*after < 0 {
+				branches[a].Crossing[step] = true
+				branches[b].Crossing[step] = true
+			}
+		}
+	}
+}
+
+// positiveModes returns the modes with positive frequency, in increasing order of frequency, on the CPU.
+func positiveModes(freqs []float64, modes []CSlice) ([]float64, []CSlice) {
+
+	var order []int
+	for p, f := range freqs {
+		if f > 0 {
+			order = append(order, p)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool { return freqs[order[i]] < freqs[order[j]] })
+
+	positiveFreqs := make([]float64, len(order))
+	positive := make([]CSlice, len(order))
+	for q, p := range order {
+		positiveFreqs[q] = freqs[p]
+		positive[q] = modes[p]
+		if !positive[q].CPUAccess() {
+			positive[q] = positive[q].HostCopy()
+		}
+	}
+	return positiveFreqs, positive
+}
+
+// normalisedOverlap returns |<a, b>| / (|a| |b|) for two modes on the CPU with the same number of components.
+func normalisedOverlap(a, b CSlice) float64 {
+
+	aRe := a.Real().Host()
+	aIm := a.Imag().Host()
+	bRe := b.Real().Host()
+	bIm := b.Imag().Host()
+
+	var dot complex128
+	var aa, bb float64
+	for c := range aRe {
+		for idx := range aRe[c] {
+			x := complex(float64(aRe[c][idx]), float64(aIm[c][idx]))
+			y := complex(float64(bRe[c][idx]), float64(bIm[c][idx]))
+			dot += complex(real(x), -imag(x)) * y
+			aa += real(x)*real(x) + imag(x)*imag(x)
+			bb += real(y)*real(y) + imag(y)*imag(y)
+		}
+	}
+
+	if aa == 0 || bb == 0 {
+		return 0
+	}
+	return math.Sqrt(real(dot)*real(dot)+imag(dot)*imag(dot)) / math.Sqrt(aa*bb)
+}
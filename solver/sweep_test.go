@@ -0,0 +1,109 @@
+package solver
+
+import (
+	"math"
+	"testing"
+
+	en "github.com/mumax/3/engine"
+
+	. "github.com/will-henderson/mumax-vhf/data"
+	"github.com/will-henderson/mumax-vhf/tests"
+)
+
+// TestSweep sweeps the field along a chain of cells magnetised along it, without demagnetising interactions.
+// Every standing wave moves up by γ ΔB, so the branches keep their character and do not cross, and the lowest is the uniform mode ω = γ B.
+func TestSweep(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Setup(`
+		SetGridSize(4, 1, 1)
+		SetCellSize(2e-9, 2e-9, 2e-9)
+		Msat = 8e5
+		Aex = 1.3e-11
+		EnableDemag = false
+		B_ext = vector(0, 0, 0.5)
+		m = uniform(0, 0, 1)
+	`)
+
+	Solver = new(RotatedToZ)
+	path := []float64{0.5, 0.75, 1, 1.25, 1.5}
+	branches := Sweep{Set: FieldSetter([3]float64{0, 0, 1}), Path: path}.Run()
+
+	if len(branches) != 4 {
+		t.Fatalf("%d branches; want 4", len(branches))
+	}
+
+	for step, B := range path {
+		if tests.EqualScalars(en.GammaLL*B, branches[0].Freqs[step], 1e-3) > 0 {
+			t.Errorf("%d: uniform mode at %g rad/s; want %g", step, branches[0].Freqs[step], en.GammaLL*B)
+		}
+		for b, branch := range branches {
+			if branch.Hybridised[step] || branch.Crossing[step] {
+				t.Errorf("%d: branch %d hybridised %v, crossing %v", step, b, branch.Hybridised[step], branch.Crossing[step])
+			}
+		}
+	}
+}
+
+// stepSolver returns, at each call of Modes, the frequencies of the next of its steps, with the mode of frequency freqs[p]
+// along the x component of cell p, so the modes of each step keep their character.
+type stepSolver struct {
+	eigenSolver
+	steps [][]float64
+	step  *int
+}
+
+func (solver stepSolver) Modes() ([]float64, []CSlice) {
+	freqs := solver.steps[*solver.step]
+	*solver.step++
+	modes := make([]CSlice, len(freqs))
+	for p := range freqs {
+		modes[p] = NewCSliceCPU(3, en.MeshSize())
+		modes[p].Real().Host()[0][p] = 1
+	}
+	return freqs, modes
+}
+
+// TestSweepBranches checks that a mode which appears after the first step starts a new branch, rather than being dropped,
+// and that the Guess of a warm-started Solver is restored after the sweep.
+func TestSweepBranches(t *testing.T) {
+
+	defer en.InitAndClose()()
+
+	Setup(`
+		SetGridSize(4, 1, 1)
+		SetCellSize(2e-9, 2e-9, 2e-9)
+		Msat = 8e5
+		Aex = 1.3e-11
+		EnableDemag = false
+		B_ext = vector(0, 0, 0.5)
+		m = uniform(0, 0, 1)
+	`)
+
+	step := 0
+	Solver = stepSolver{steps: [][]float64{{1, 2}, {1.1, 2.1, 3.1}}, step: &step}
+	branches := Sweep{Set: FieldSetter([3]float64{0, 0, 1}), Path: []float64{0.5, 0.6}}.Run()
+
+	if len(branches) != 3 {
+		t.Fatalf("%d branches; want 3", len(branches))
+	}
+	if !math.IsNaN(branches[2].Freqs[0]) || branches[2].Freqs[1] != 3.1 {
+		t.Errorf("new branch has frequencies %v; want [NaN 3.1]", branches[2].Freqs)
+	}
+	for b, want := range []float64{1.1, 2.1} {
+		if branches[b].Freqs[1] != want {
+			t.Errorf("branch %d has frequency %g at the second step; want %g", b, branches[b].Freqs[1], want)
+		}
+	}
+
+	Solver = new(RotatedToZ)
+	_, guess := Modes()
+	arnoldi := &ArnoldiField{NEV: 4}
+	arnoldi.Guess = guess[:2]
+	Solver = arnoldi
+	Sweep{Set: FieldSetter([3]float64{0, 0, 1}), Path: []float64{0.5, 0.6}}.Run()
+	if len(arnoldi.Guess) != 2 || arnoldi.Guess[0] != guess[0] || arnoldi.Guess[1] != guess[1] {
+		t.Errorf("the Guess of the solver was not restored after the sweep")
+	}
+}